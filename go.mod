@@ -2,13 +2,23 @@ module github.com/asadarafat/netmiGO
 
 go 1.22.5
 
-require golang.org/x/crypto v0.25.0
+require (
+	github.com/openconfig/gnmi v0.14.1
+	golang.org/x/crypto v0.32.0
+	google.golang.org/grpc v1.69.2
+	google.golang.org/protobuf v1.36.2
+)
 
-require github.com/kr/fs v0.1.0 // indirect
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250106144421-5f5ef82da422 // indirect
+)
 
 require (
 	github.com/bramvdbogaerde/go-scp v1.5.0
 	github.com/pkg/sftp v1.13.6
 	github.com/sirupsen/logrus v1.9.3
-	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 )
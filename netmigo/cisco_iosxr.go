@@ -1,12 +1,9 @@
 package netmigo
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"regexp"
-	"strconv"
-	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -19,40 +16,84 @@ type IOSXRDeviceConnection struct {
 	Prompt     string
 }
 
+// iosxrErrorPattern matches the error lines IOS-XR prints for a rejected command.
+var iosxrErrorPattern = regexp.MustCompile(`(?m)^% ?(Invalid input detected|Incomplete command|Ambiguous command)[^\r\n]*`)
+
+// iosxrCommitFailurePattern matches the message of a rejected commit, such as
+// "% Failed to commit one or more configuration items during a pseudo-atomic operation.".
+var iosxrCommitFailurePattern = regexp.MustCompile(`(?m)^% ?Failed to commit[^\r\n]*`)
+
 func NewIOSXRDeviceConnection(connection *SSHConnModel, DeviceType string) (*IOSXRDeviceConnection, error) {
-	return &IOSXRDeviceConnection{
+	iosxr := &IOSXRDeviceConnection{
 		DeviceConnection: DeviceConnection{
-			Connection: connection,
-			Return:     "\n",
+			Connection:   connection,
+			Return:       "\n",
+			errorPattern: iosxrErrorPattern,
 		},
 		DeviceType: DeviceType,
-	}, nil
+	}
+	iosxr.prepare = iosxr.findPrompt
+	return iosxr, nil
 }
 
 // NewDevice initializes a new IOSXR device connection
-func InitIOSXRDevice(Host string, Username string, Password string, Port uint8) (*IOSXRDeviceConnection, error) {
+// Extra options are applied after the credentials and port, a zero Port selects the default.
+func InitIOSXRDevice(Host string, Username string, Password string, Port uint16, opts ...Option) (*IOSXRDeviceConnection, error) {
 
 	// Create connection
-	connection, err := InitTransport(Host, Username, Password, "ssh", Port)
+	opts = append([]Option{WithCredentials(Username, Password), WithPort(Port)}, opts...)
+	connection, err := NewConnection(Host, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create the device
-	return NewIOSXRDeviceConnection(connection, "cisco_iosxr")
+	return NewIOSXRDeviceConnection(connection, DeviceTypeIOSXR)
 }
 
 func (iosxr *IOSXRDeviceConnection) Connect() error {
-	if err := iosxr.DeviceConnection.ConnectXterm(); err != nil {
+	return iosxr.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect but aborts when ctx is done.
+func (iosxr *IOSXRDeviceConnection) ConnectContext(ctx context.Context) error {
+	if err := iosxr.DeviceConnection.ConnectXtermContext(ctx); err != nil {
 		return err
 	}
+	return iosxr.findPrompt(ctx)
+}
+
+// OpenChannel opens an extra shell channel over the same SSH client and prepares it like
+// Connect does, so that commands can run on it concurrently with iosxr.
+func (iosxr *IOSXRDeviceConnection) OpenChannel() (*IOSXRDeviceConnection, error) {
+	return iosxr.OpenChannelContext(context.Background())
+}
+
+// OpenChannelContext is like OpenChannel but aborts when ctx is done.
+func (iosxr *IOSXRDeviceConnection) OpenChannelContext(ctx context.Context) (*IOSXRDeviceConnection, error) {
+	channel, err := iosxr.Connection.NewChannel()
+	if err != nil {
+		return nil, err
+	}
+	device, err := NewIOSXRDeviceConnection(channel, iosxr.DeviceType)
+	if err != nil {
+		return nil, err
+	}
+	if err := device.ConnectContext(ctx); err != nil {
+		device.Disconnect()
+		return nil, err
+	}
+	return device, nil
+}
 
+// findPrompt discovers the device prompt, after Connect and on every reconnect.
+func (iosxr *IOSXRDeviceConnection) findPrompt(ctx context.Context) error {
 	// Define the regex pattern to find the IOSXR device prompt
 	// This pattern captures the prompt structure of "RP/0/RP0/CPU0:R11-P#"
 	const promptPattern = `RP\/\d+\/RP\d+\/CPU\d+:[\w\-]+#`
 	const expectedPromptSuffix = "#"
 
-	prompt, err := iosxr.FindDevicePrompt(promptPattern, expectedPromptSuffix)
+	prompt, err := iosxr.FindDevicePromptContext(ctx, promptPattern, expectedPromptSuffix)
 	if err != nil {
 		return err
 	}
@@ -64,70 +105,62 @@ func (iosxr *IOSXRDeviceConnection) Connect() error {
 }
 
 func (iosxr *IOSXRDeviceConnection) SendCommand(command string, cliPromptMode string, timeout time.Duration) (string, error) {
-	var outputBuffer bytes.Buffer
+	return iosxr.SendCommandContext(context.Background(), command, cliPromptMode, timeout)
+}
+
+// SendCommandContext is like SendCommand but aborts when ctx is done, returning the partial output in a ContextError.
+func (iosxr *IOSXRDeviceConnection) SendCommandContext(ctx context.Context, command string, cliPromptMode string, timeout time.Duration) (string, error) {
+	result, err := iosxr.SendCommandResultContext(ctx, command, cliPromptMode, timeout)
+	if err != nil {
+		return "", err
+	}
+	return result.Output, nil
+}
+
+// SendCommandResult is like SendCommand but also returns the raw output of the device.
+func (iosxr *IOSXRDeviceConnection) SendCommandResult(command string, cliPromptMode string, timeout time.Duration) (*CommandResult, error) {
+	return iosxr.SendCommandResultContext(context.Background(), command, cliPromptMode, timeout)
+}
+
+// SendCommandResultContext is like SendCommandResult but aborts when ctx is done, returning the partial output in a ContextError.
+func (iosxr *IOSXRDeviceConnection) SendCommandResultContext(ctx context.Context, command string, cliPromptMode string, timeout time.Duration) (*CommandResult, error) {
+	if err := iosxr.ensureConnected(ctx); err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = iosxr.commandTimeout(ctx)
+	}
 	var promptMode string
-	var processedOutput string
+	var result *CommandResult
 
 	var err error
 
 	stdin := iosxr.Connection.Writer
-	stdout := iosxr.Connection.Reader
-
-	scanner := bufio.NewScanner(stdout)
-	done := make(chan bool)
+	iosxr.syncOutput(ctx)
 
 	if cliPromptMode == "running" {
 		promptMode = iosxr.Prompt // RP/0/RP0/CPU0:R11-P#
 
-		go func() {
-			defer func() { done <- true }()
-			appearanceCount := 0
-			for scanner.Scan() {
-				line := cleanOutputIosxr(scanner.Text())
-				outputBuffer.WriteString(line + "\n")
-				log.Info("Received line: ", line)
-
-				// Increment appearance count if the line contains the specific string
-				if strings.Contains(line, promptMode) {
-
-					appearanceCount++
-					log.Infof("line contain PromptMode, appearance count: %s", strconv.Itoa(appearanceCount))
-
-					if appearanceCount == 1 {
-						log.Infof("Detected %s appearance of end marker", strconv.Itoa(appearanceCount))
-						done <- true
-						return
-					}
-				}
-
-			}
-			if err := scanner.Err(); err != nil {
-				log.Error("Error reading stdout:", err)
-			}
-		}()
-
 		log.Infof("Sending command: %s", command)
 		_, err := fmt.Fprintf(stdin, "%s\n\n", command)
 
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
-		// Wait for the output to be read or timeout
-		select {
-		case <-done:
-			log.Info("Reading completed")
-		case <-time.After(timeout):
-			log.Info("Timeout waiting for reading to complete")
+		// Wait for the end marker, answering pagers, or timeout
+		output, err := iosxr.expectLines(ctx, timeout, "send command "+command, regexp.QuoteMeta(promptMode), 1)
+		if err != nil {
+			return nil, err
+		}
+		// The extra return prints one more prompt
+		iosxr.owePrompt(regexp.QuoteMeta(promptMode))
+		if err := iosxr.commandError(iosxr.Prompt, command, output); err != nil {
+			return nil, err
 		}
 
-		output := outputBuffer.String()
-
-		lines := strings.Split(output, "\n")               // Split the output string into lines
-		trimmedLines := lines[1 : len(lines)-1]            // Remove the first and last two lines
-		processedOutput = strings.Join(trimmedLines, "\n") // Join the remaining lines into a single string
-		// fmt.Print(processedOutput)
+		result = &CommandResult{Command: command, Output: trimOutput(output, command, iosxr.Prompt), Raw: output}
 
 		log.Info("Final output: ")
 		log.Debug(output)
@@ -135,232 +168,123 @@ func (iosxr *IOSXRDeviceConnection) SendCommand(command string, cliPromptMode st
 	} else if cliPromptMode == "candidate" {
 		promptMode = "(config)#"
 
-		go func() {
-			defer func() { done <- true }()
-			appearanceCount := 0
-			for scanner.Scan() {
-				line := cleanOutputIosxr(scanner.Text())
-				outputBuffer.WriteString(line + "\n")
-				log.Info("Received line: ", line+"\n")
-
-				// Increment appearance count if the line contains the specific string
-				if strings.Contains(line, promptMode) {
-
-					appearanceCount++
-					log.Infof("line contain PromptMode, appearance count: %s", strconv.Itoa(appearanceCount))
-
-					if appearanceCount == 2 {
-						log.Infof("Detected %s appearance of end marker", strconv.Itoa(appearanceCount))
-						done <- true
-						return
-					}
-				}
-
-			}
-			if err = scanner.Err(); err != nil {
-				log.Error("Error reading stdout:", err)
-			}
-		}()
-
 		log.Infof("Sending command: %s", command)
 
 		_, err = fmt.Fprintf(stdin, "%s\n", "configure terminal")
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
 		_, err = fmt.Fprintf(stdin, "%s\n", command)
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
 		_, err = fmt.Fprintf(stdin, "%s\n", "exit")
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
 		// _, err = fmt.Fprintf(stdin, "%s\n", "commit")
 		// if err != nil {
 		// 	fmt.Println("Error writing to stdin:", err)
-		// 	return "", err
+		// 	return nil, err
 		// }
 
-		// Wait for the output to be read or timeout
-		select {
-		case <-done:
-			log.Info("Reading completed")
-		case <-time.After(timeout):
-			log.Info("Timeout waiting for reading to complete")
+		// Wait for the end marker, answering pagers, or timeout
+		output, err := iosxr.expectLines(ctx, timeout, "send command "+command, regexp.QuoteMeta(promptMode), 2)
+		if err != nil {
+			return nil, err
+		}
+		if err := iosxr.commandError(iosxr.Prompt, command, output); err != nil {
+			return nil, err
 		}
 
-		output := outputBuffer.String()
-
-		lines := strings.Split(output, "\n")               // Split the output string into lines
-		trimmedLines := lines[1 : len(lines)-2]            // Remove the first and last two lines
-		processedOutput = strings.Join(trimmedLines, "\n") // Join the remaining lines into a single string
-		// fmt.Print(processedOutput)
+		result = &CommandResult{Command: command, Output: trimOutput(output, command, iosxr.Prompt, promptMode), Raw: output}
 		// log.Info("Final output: ")
 		log.Debug(output)
 
 	} else {
-		log.Infof("Unspported cliPromptMode: %s", cliPromptMode)
-		return "", nil
+		log.Errorf("Unsupported cliPromptMode: %s", cliPromptMode)
+		return nil, fmt.Errorf("unsupported cliPromptMode %q", cliPromptMode)
 	}
-	return processedOutput, nil
+	return result, nil
 }
 
-func cleanOutputIosxr(output string) string {
-	re := regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]|\x1b\[\?2004[lh]|\x1b\[\?25[hl]|\x1b\[\?12[hl]|\x1b\[\?7[hl]`)
-	output = re.ReplaceAllString(output, "")
-
-	return output
+func (iosxr *IOSXRDeviceConnection) CopyRunningConfig(savedConfigFileName string, cliPromptMode string, timeout time.Duration) (string, error) {
+	return iosxr.CopyRunningConfigContext(context.Background(), savedConfigFileName, cliPromptMode, timeout)
 }
 
-func (iosxr *IOSXRDeviceConnection) CopyRunningConfig(savedConfigFileName string, cliPromptMode string, timeout time.Duration) (string, error) {
+// CopyRunningConfigContext is like CopyRunningConfig but aborts when ctx is done, returning the partial output in a ContextError.
+func (iosxr *IOSXRDeviceConnection) CopyRunningConfigContext(ctx context.Context, savedConfigFileName string, cliPromptMode string, timeout time.Duration) (string, error) {
+	if err := iosxr.ensureConnected(ctx); err != nil {
+		return "", err
+	}
 
-	var outputBuffer bytes.Buffer
 	var promptMode string
-	var processedOutput string
 	var output string
-
-	stdin := iosxr.Connection.Writer
-	stdout := iosxr.Connection.Reader
-
-	scanner := bufio.NewScanner(stdout)
-	done := make(chan bool)
+	var err error
 
 	expectString := fmt.Sprintf("[/%s]?", savedConfigFileName)
 
 	if cliPromptMode == "running" {
 		promptMode = iosxr.Prompt // RP/0/RP0/CPU0:R11-P#
 
-		go func() {
-			defer func() { done <- true }()
-			appearanceCount := 0
-			for scanner.Scan() {
-				line := cleanOutputIosxr(scanner.Text())
-				// outputBuffer.WriteString(line + "\n")
-				outputBuffer.WriteString(line + "\n")
-
-				log.Info("Received line: ", line)
-
-				if strings.Contains(line, expectString) {
-					log.Info("expectString found: ", line)
-					log.Info("Send Carriage Return")
-					fmt.Fprintf(stdin, "\n") //return enter
-				}
-
-				// Increment appearance count if the line contains the specific string
-				if strings.Contains(line, promptMode) {
-					log.Infof("line contain PromptMode, appearance count: %s", strconv.Itoa(appearanceCount))
-					appearanceCount++
-					if appearanceCount == 1 {
-						log.Infof("line contain PromptMode, appearance count: %s", strconv.Itoa(appearanceCount))
-						log.Infof("Detected %s appearance of end marker", strconv.Itoa(appearanceCount))
-						done <- true
-						return
-					}
-				}
-
-			}
-			if err := scanner.Err(); err != nil {
-				log.Error("Error reading stdout:", err)
-			}
-		}()
-
-		commandSaveRunningConfig := fmt.Sprintf("copy running-config %s", savedConfigFileName)
-		commands := []string{
-			fmt.Sprintf("%s\n", commandSaveRunningConfig),
-			"\n",
-			"yes",
-			"\n",
-		}
-
-		log.Infof("Sending commands: %s", commands)
-
-		for _, cmd := range commands {
-			if _, err := fmt.Fprintf(stdin, "%s", cmd); err != nil {
-				fmt.Println("Error writing to stdin:", err)
-				return "", err
-			}
-		}
-
-		// Wait for the output to be read or timeout
-		select {
-		case <-done:
-			log.Info("Reading completed")
-		case <-time.After(timeout):
-			log.Info("Timeout waiting for reading to complete")
+		// Confirm the destination file name and allow overwriting an existing file.
+		output, err = iosxr.SendInteractiveContext(ctx, Interaction{
+			Command: fmt.Sprintf("copy running-config %s", savedConfigFileName),
+			Steps: []InteractiveStep{
+				{Expect: regexp.QuoteMeta(expectString), Timeout: timeout},
+				{Expect: `(?i)overwrite\?\s*\[(no|yes)\]:?`, Response: "yes", Timeout: timeout},
+			},
+			End:     regexp.QuoteMeta(promptMode),
+			Timeout: timeout,
+		})
+		if err != nil {
+			return output, err
 		}
 
-		output = outputBuffer.String()
-
-		lines := strings.Split(output, "\n")               // Split the output string into lines
-		trimmedLines := lines[1 : len(lines)-1]            // Remove the first and last two lines
-		processedOutput = strings.Join(trimmedLines, "\n") // Join the remaining lines into a single string
-
-		log.Debug(processedOutput)
+		log.Debug(output)
 
 	} else {
-		log.Infof("Unspported cliPromptMode: %s", cliPromptMode)
-		return "", nil
+		log.Errorf("Unsupported cliPromptMode: %s", cliPromptMode)
+		return "", fmt.Errorf("unsupported cliPromptMode %q", cliPromptMode)
 	}
 
 	return output, nil
 }
 
 func (iosxr *IOSXRDeviceConnection) LoadRunningConfig(savedConfigFileName string, cliPromptMode string, timeout time.Duration) (string, error) {
+	return iosxr.LoadRunningConfigContext(context.Background(), savedConfigFileName, cliPromptMode, timeout)
+}
+
+// LoadRunningConfigContext is like LoadRunningConfig but aborts when ctx is done, returning the partial output in a ContextError.
+func (iosxr *IOSXRDeviceConnection) LoadRunningConfigContext(ctx context.Context, savedConfigFileName string, cliPromptMode string, timeout time.Duration) (string, error) {
+	if err := iosxr.ensureConnected(ctx); err != nil {
+		return "", err
+	}
 
-	var outputBuffer bytes.Buffer
 	var promptMode string
-	var processedOutput string
 	var output string
+	var err error
 
 	stdin := iosxr.Connection.Writer
-	stdout := iosxr.Connection.Reader
-
-	scanner := bufio.NewScanner(stdout)
-	done := make(chan bool)
+	iosxr.syncOutput(ctx)
 
 	if cliPromptMode == "candidate" {
 		promptMode = iosxr.Prompt // RP/0/RP0/CPU0:R11-P#
 
-		go func() {
-			defer func() { done <- true }()
-			appearanceCount := 0
-			for scanner.Scan() {
-				line := cleanOutputIosxr(scanner.Text())
-				// outputBuffer.WriteString(line + "\n")
-				outputBuffer.WriteString(line + "\n")
-
-				log.Info("Received line: ", line)
-
-				// Increment appearance count if the line contains the specific string
-				if strings.Contains(line, promptMode) {
-					log.Infof("line contain PromptMode, appearance count: %s", strconv.Itoa(appearanceCount))
-					appearanceCount++
-					if appearanceCount == 1 {
-						log.Infof("line contain PromptMode, appearance count: %s", strconv.Itoa(appearanceCount))
-						log.Infof("Detected %s appearance of end marker", strconv.Itoa(appearanceCount))
-						done <- true
-						return
-					}
-				}
-
-			}
-			if err := scanner.Err(); err != nil {
-				log.Error("Error reading stdout:", err)
-			}
-		}()
-
 		commandSaveRunningConfig := fmt.Sprintf("load %s", savedConfigFileName)
+		// Leave configuration mode after the commit so the exec prompt marks the end.
+		// A failed commit leaves uncommitted changes, which end discards.
 		commands := []string{
 			"configure terminal\n",
 			fmt.Sprintf("%s\n", commandSaveRunningConfig),
-			"commit",
+			"commit\n",
+			"end\n",
 			"\n",
 		}
 
@@ -368,30 +292,31 @@ func (iosxr *IOSXRDeviceConnection) LoadRunningConfig(savedConfigFileName string
 
 		for _, cmd := range commands {
 			if _, err := fmt.Fprintf(stdin, "%s", cmd); err != nil {
-				fmt.Println("Error writing to stdin:", err)
+				log.Errorf("Error writing to stdin: %v", err)
 				return "", err
 			}
 		}
 
-		// Wait for the output to be read or timeout
-		select {
-		case <-done:
-			log.Info("Reading completed")
-		case <-time.After(timeout):
-			log.Info("Timeout waiting for reading to complete")
+		// Wait for the end marker, answering pagers, or timeout
+		output, err = iosxr.expectLines(ctx, timeout, "load running-config", regexp.QuoteMeta(promptMode), 1,
+			expectAnswer{pattern: `(?i)uncommitted changes found.*\?[^\n]*:\s*$`, response: "no\n"})
+		if err != nil {
+			return "", err
+		}
+		// The extra return prints one more prompt
+		iosxr.owePrompt(regexp.QuoteMeta(promptMode))
+		if err := iosxr.commandError(iosxr.Prompt, commandSaveRunningConfig, output); err != nil {
+			return "", err
+		}
+		if err := commitFailure(iosxrCommitFailurePattern, output); err != nil {
+			return "", err
 		}
 
-		output = outputBuffer.String()
-
-		lines := strings.Split(output, "\n")               // Split the output string into lines
-		trimmedLines := lines[1 : len(lines)-1]            // Remove the first and last two lines
-		processedOutput = strings.Join(trimmedLines, "\n") // Join the remaining lines into a single string
-
-		log.Debug(processedOutput)
+		log.Debug(output)
 
 	} else {
-		log.Infof("Unspported cliPromptMode: %s", cliPromptMode)
-		return "", nil
+		log.Errorf("Unsupported cliPromptMode: %s", cliPromptMode)
+		return "", fmt.Errorf("unsupported cliPromptMode %q", cliPromptMode)
 	}
 
 	return output, nil
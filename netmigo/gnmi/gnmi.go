@@ -0,0 +1,507 @@
+// Package gnmi is a gNMI client for SR Linux, SROS and IOS-XR, next to the netmigo SSH
+// drivers. Paths are written in the usual string form, "/interface[name=ethernet-1/1]/state",
+// and values are exchanged as decoded Go values.
+package gnmi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	gpb "github.com/openconfig/gnmi/proto/gnmi"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// DefaultPort is the gNMI port of SR Linux and SROS.
+const DefaultPort = "57400"
+
+// Client is a gNMI client bound to one target.
+type Client struct {
+	Address  string
+	Username string
+	Password string
+	Target   string        // prefix target, for devices behind a gNMI proxy
+	Encoding string        // "json_ietf" (default), "json", "proto", "ascii" or "bytes"
+	Timeout  time.Duration // dial and unary RPC timeout, 30s by default
+
+	insecure  bool
+	tlsConfig *tls.Config
+
+	conn   *grpc.ClientConn
+	client gpb.GNMIClient
+}
+
+// Option configures a Client.
+type Option func(*Client) error
+
+// WithCredentials sends username and password as metadata with every RPC.
+func WithCredentials(username, password string) Option {
+	return func(c *Client) error {
+		c.Username = username
+		c.Password = password
+		return nil
+	}
+}
+
+// WithInsecure disables TLS, for targets running gNMI in plaintext.
+func WithInsecure() Option {
+	return func(c *Client) error {
+		c.insecure = true
+		return nil
+	}
+}
+
+// WithTLS sets the TLS configuration used to reach the target.
+func WithTLS(config *tls.Config) Option {
+	return func(c *Client) error {
+		c.tlsConfig = config
+		return nil
+	}
+}
+
+// WithSkipVerify keeps TLS but does not verify the target certificate, as lab nodes
+// usually serve self-signed ones.
+func WithSkipVerify() Option {
+	return func(c *Client) error {
+		c.tls().InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithCACert verifies the target certificate against the PEM CA certificates in file.
+func WithCACert(file string) Option {
+	return func(c *Client) error {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", file)
+		}
+		c.tls().RootCAs = pool
+		return nil
+	}
+}
+
+// WithTarget sets the target of the request prefixes.
+func WithTarget(target string) Option {
+	return func(c *Client) error {
+		c.Target = target
+		return nil
+	}
+}
+
+// WithEncoding sets the encoding requested for Get and Subscribe and used for Set values.
+func WithEncoding(encoding string) Option {
+	return func(c *Client) error {
+		if _, err := parseEncoding(encoding); err != nil {
+			return err
+		}
+		c.Encoding = encoding
+		return nil
+	}
+}
+
+// WithTimeout sets the dial and unary RPC timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		c.Timeout = timeout
+		return nil
+	}
+}
+
+func (c *Client) tls() *tls.Config {
+	if c.tlsConfig == nil {
+		c.tlsConfig = &tls.Config{}
+	}
+	return c.tlsConfig
+}
+
+// Dial connects to the gNMI server at address; port 57400 is used when address has none.
+// TLS is used unless WithInsecure is given.
+func Dial(address string, opts ...Option) (*Client, error) {
+	return DialContext(context.Background(), address, opts...)
+}
+
+// DialContext is like Dial but aborts when ctx is done.
+func DialContext(ctx context.Context, address string, opts ...Option) (*Client, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(strings.Trim(address, "[]"), DefaultPort)
+	}
+	c := &Client{
+		Address:  address,
+		Encoding: "json_ietf",
+		Timeout:  30 * time.Second,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	transport := insecure.NewCredentials()
+	if !c.insecure {
+		config := c.tls().Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		transport = credentials.NewTLS(config)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(transport)}
+	if c.Username != "" || c.Password != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(&passwordCredentials{c.Username, c.Password}))
+	}
+
+	conn, err := grpc.NewClient(address, dialOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gnmi client for %s: %w", address, err)
+	}
+	c.conn = conn
+	c.client = gpb.NewGNMIClient(conn)
+
+	// grpc.NewClient connects lazily; a Capabilities call checks the target is reachable
+	// and accepts the credentials.
+	if _, err := c.CapabilitiesContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	log.Infof("Connected to gNMI target %s", address)
+	return c, nil
+}
+
+// Close releases the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// passwordCredentials sends the username and password metadata read by SR Linux, SROS and
+// IOS-XR.
+type passwordCredentials struct {
+	username, password string
+}
+
+func (p *passwordCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"username": p.username, "password": p.password}, nil
+}
+
+// RequireTransportSecurity is false so that credentials also work with WithInsecure.
+func (p *passwordCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// unaryContext bounds a unary RPC by the client timeout.
+func (c *Client) unaryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout > 0 {
+		return context.WithTimeout(ctx, c.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Model is a YANG model supported by the target.
+type Model struct {
+	Name         string
+	Organization string
+	Version      string
+}
+
+// Capabilities is the outcome of the Capabilities RPC.
+type Capabilities struct {
+	Version   string
+	Encodings []string
+	Models    []Model
+}
+
+// Capabilities returns the gNMI version, encodings and models supported by the target.
+func (c *Client) Capabilities() (*Capabilities, error) {
+	return c.CapabilitiesContext(context.Background())
+}
+
+// CapabilitiesContext is like Capabilities but aborts when ctx is done.
+func (c *Client) CapabilitiesContext(ctx context.Context) (*Capabilities, error) {
+	ctx, cancel := c.unaryContext(ctx)
+	defer cancel()
+	response, err := c.client.Capabilities(ctx, &gpb.CapabilityRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("gnmi capabilities failed: %w", err)
+	}
+
+	capabilities := &Capabilities{Version: response.GetGNMIVersion()}
+	for _, encoding := range response.GetSupportedEncodings() {
+		capabilities.Encodings = append(capabilities.Encodings, strings.ToLower(encoding.String()))
+	}
+	for _, model := range response.GetSupportedModels() {
+		capabilities.Models = append(capabilities.Models, Model{
+			Name:         model.GetName(),
+			Organization: model.GetOrganization(),
+			Version:      model.GetVersion(),
+		})
+	}
+	return capabilities, nil
+}
+
+// Update is a path and its value. Values read from the target are decoded: JSON values to
+// maps, slices and scalars, scalar values to the matching Go type. Values written are
+// encoded as JSON, except json.RawMessage which is sent as is.
+type Update struct {
+	Path  string
+	Value interface{}
+}
+
+// Get returns the values under paths, state and configuration alike.
+func (c *Client) Get(paths ...string) ([]Update, error) {
+	return c.GetContext(context.Background(), paths...)
+}
+
+// GetContext is like Get but aborts when ctx is done.
+func (c *Client) GetContext(ctx context.Context, paths ...string) ([]Update, error) {
+	request := &gpb.GetRequest{Prefix: c.prefix(), Type: gpb.GetRequest_ALL}
+	var err error
+	if request.Encoding, err = parseEncoding(c.Encoding); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		p, err := ParsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		request.Path = append(request.Path, p)
+	}
+
+	ctx, cancel := c.unaryContext(ctx)
+	defer cancel()
+	response, err := c.client.Get(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("gnmi get failed: %w", err)
+	}
+
+	var updates []Update
+	for _, notification := range response.GetNotification() {
+		n, err := decodeNotification(notification)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, n.Updates...)
+	}
+	return updates, nil
+}
+
+// SetRequest groups the changes applied atomically by Set. Deletes are applied first, then
+// replaces, then updates.
+type SetRequest struct {
+	Deletes  []string
+	Replaces []Update
+	Updates  []Update
+}
+
+// Set applies the request as a single transaction.
+func (c *Client) Set(request SetRequest) error {
+	return c.SetContext(context.Background(), request)
+}
+
+// SetContext is like Set but aborts when ctx is done.
+func (c *Client) SetContext(ctx context.Context, request SetRequest) error {
+	encoding, err := parseEncoding(c.Encoding)
+	if err != nil {
+		return err
+	}
+	set := &gpb.SetRequest{Prefix: c.prefix()}
+	for _, path := range request.Deletes {
+		p, err := ParsePath(path)
+		if err != nil {
+			return err
+		}
+		set.Delete = append(set.Delete, p)
+	}
+	if set.Replace, err = encodeUpdates(request.Replaces, encoding); err != nil {
+		return err
+	}
+	if set.Update, err = encodeUpdates(request.Updates, encoding); err != nil {
+		return err
+	}
+
+	ctx, cancel := c.unaryContext(ctx)
+	defer cancel()
+	if _, err := c.client.Set(ctx, set); err != nil {
+		return fmt.Errorf("gnmi set failed: %w", err)
+	}
+	log.Infof("gNMI set applied: %d deletes, %d replaces, %d updates", len(set.Delete), len(set.Replace), len(set.Update))
+	return nil
+}
+
+func (c *Client) prefix() *gpb.Path {
+	if c.Target == "" {
+		return nil
+	}
+	return &gpb.Path{Target: c.Target}
+}
+
+// Subscription modes.
+const (
+	SubscribeOnce   = "once"
+	SubscribePoll   = "poll"
+	SubscribeStream = "stream"
+)
+
+// Stream subscription modes.
+const (
+	StreamTargetDefined = "target_defined"
+	StreamOnChange      = "on_change"
+	StreamSample        = "sample"
+)
+
+// SubscribeRequest describes a subscription. StreamMode and SampleInterval apply to stream
+// subscriptions only.
+type SubscribeRequest struct {
+	Mode           string // SubscribeOnce, SubscribePoll or SubscribeStream
+	Paths          []string
+	StreamMode     string // StreamTargetDefined (default), StreamOnChange or StreamSample
+	SampleInterval time.Duration
+	UpdatesOnly    bool
+}
+
+// Notification is a set of updates and deletes sent by the target. Sync marks the end of
+// the initial updates of a subscription, or of a poll; it carries no updates.
+type Notification struct {
+	Timestamp time.Time
+	Updates   []Update
+	Deletes   []string
+	Sync      bool
+}
+
+// Subscription is a running subscription.
+type Subscription struct {
+	mode   string
+	stream grpc.BidiStreamingClient[gpb.SubscribeRequest, gpb.SubscribeResponse]
+	cancel context.CancelFunc
+
+	mu sync.Mutex // serializes Poll
+}
+
+// Subscribe starts a subscription; notifications are read with Recv.
+func (c *Client) Subscribe(request SubscribeRequest) (*Subscription, error) {
+	return c.SubscribeContext(context.Background(), request)
+}
+
+// SubscribeContext is like Subscribe but the subscription ends when ctx is done.
+func (c *Client) SubscribeContext(ctx context.Context, request SubscribeRequest) (*Subscription, error) {
+	list := &gpb.SubscriptionList{Prefix: c.prefix(), UpdatesOnly: request.UpdatesOnly}
+	var err error
+	if list.Encoding, err = parseEncoding(c.Encoding); err != nil {
+		return nil, err
+	}
+
+	var streamMode gpb.SubscriptionMode
+	var sampleInterval uint64
+	switch request.Mode {
+	case SubscribeOnce:
+		list.Mode = gpb.SubscriptionList_ONCE
+	case SubscribePoll:
+		list.Mode = gpb.SubscriptionList_POLL
+	case SubscribeStream, "":
+		list.Mode = gpb.SubscriptionList_STREAM
+		switch request.StreamMode {
+		case StreamTargetDefined, "":
+			streamMode = gpb.SubscriptionMode_TARGET_DEFINED
+		case StreamOnChange:
+			streamMode = gpb.SubscriptionMode_ON_CHANGE
+		case StreamSample:
+			streamMode = gpb.SubscriptionMode_SAMPLE
+		default:
+			return nil, fmt.Errorf("unknown stream mode %q", request.StreamMode)
+		}
+		sampleInterval = uint64(request.SampleInterval.Nanoseconds())
+	default:
+		return nil, fmt.Errorf("unknown subscription mode %q", request.Mode)
+	}
+	for _, path := range request.Paths {
+		p, err := ParsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		list.Subscription = append(list.Subscription, &gpb.Subscription{
+			Path:           p,
+			Mode:           streamMode,
+			SampleInterval: sampleInterval,
+		})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.client.Subscribe(ctx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("gnmi subscribe failed: %w", err)
+	}
+	if err := stream.Send(&gpb.SubscribeRequest{Request: &gpb.SubscribeRequest_Subscribe{Subscribe: list}}); err != nil {
+		cancel()
+		return nil, fmt.Errorf("gnmi subscribe failed: %w", err)
+	}
+	log.Infof("gNMI %s subscription started for %s", list.Mode, strings.Join(request.Paths, ", "))
+	return &Subscription{mode: list.Mode.String(), stream: stream, cancel: cancel}, nil
+}
+
+// Recv returns the next notification. It returns io.EOF once the target ends the
+// subscription, which it does after the sync for once subscriptions.
+func (s *Subscription) Recv() (*Notification, error) {
+	response, err := s.stream.Recv()
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gnmi subscription failed: %w", err)
+	}
+
+	switch r := response.GetResponse().(type) {
+	case *gpb.SubscribeResponse_Update:
+		return decodeNotification(r.Update)
+	case *gpb.SubscribeResponse_SyncResponse:
+		return &Notification{Sync: true}, nil
+	default:
+		return nil, fmt.Errorf("unexpected gnmi subscribe response %T", r)
+	}
+}
+
+// Poll asks a poll subscription for a new set of updates, ended by a Sync notification.
+func (s *Subscription) Poll() error {
+	if s.mode != gpb.SubscriptionList_POLL.String() {
+		return errors.New("not a poll subscription")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.stream.Send(&gpb.SubscribeRequest{Request: &gpb.SubscribeRequest_Poll{Poll: &gpb.Poll{}}}); err != nil {
+		return fmt.Errorf("gnmi poll failed: %w", err)
+	}
+	return nil
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.cancel()
+}
+
+func decodeNotification(notification *gpb.Notification) (*Notification, error) {
+	n := &Notification{Timestamp: time.Unix(0, notification.GetTimestamp())}
+	prefix := notification.GetPrefix()
+	for _, update := range notification.GetUpdate() {
+		value, err := decodeValue(update.GetVal())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", FormatPath(prefix, update.GetPath()), err)
+		}
+		n.Updates = append(n.Updates, Update{Path: FormatPath(prefix, update.GetPath()), Value: value})
+	}
+	for _, path := range notification.GetDelete() {
+		n.Deletes = append(n.Deletes, FormatPath(prefix, path))
+	}
+	return n, nil
+}
@@ -0,0 +1,195 @@
+package gnmi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	gpb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// ParsePath parses a path such as "/interface[name=ethernet-1/1]/state". An origin may be
+// given before the first slash, "openconfig:/interfaces"; "\" escapes "]" and "\" in key
+// values.
+func ParsePath(path string) (*gpb.Path, error) {
+	p := &gpb.Path{}
+	if i := strings.Index(path, ":/"); i > 0 && !strings.ContainsAny(path[:i], "/[") {
+		p.Origin, path = path[:i], path[i+1:]
+	}
+
+	var elem *gpb.PathElem
+	var name strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '/':
+			if elem != nil || name.Len() > 0 {
+				p.Elem = append(p.Elem, newElem(elem, name.String()))
+				elem = nil
+				name.Reset()
+			}
+		case '[':
+			if name.Len() == 0 && elem == nil {
+				return nil, fmt.Errorf("invalid path %q: key without element", path)
+			}
+			if elem == nil {
+				elem = &gpb.PathElem{Name: name.String(), Key: map[string]string{}}
+			}
+			key, value, n, err := parseKey(path[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %w", path, err)
+			}
+			elem.Key[key] = value
+			i += n
+		default:
+			if elem != nil {
+				return nil, fmt.Errorf("invalid path %q: text after key", path)
+			}
+			name.WriteByte(path[i])
+		}
+	}
+	if elem != nil || name.Len() > 0 {
+		p.Elem = append(p.Elem, newElem(elem, name.String()))
+	}
+	return p, nil
+}
+
+func newElem(elem *gpb.PathElem, name string) *gpb.PathElem {
+	if elem != nil {
+		return elem
+	}
+	return &gpb.PathElem{Name: name}
+}
+
+// parseKey parses "key=value]" and returns the bytes consumed.
+func parseKey(s string) (key, value string, n int, err error) {
+	eq := strings.IndexByte(s, '=')
+	if eq <= 0 {
+		return "", "", 0, fmt.Errorf("key without name")
+	}
+	key = s[:eq]
+	var v strings.Builder
+	for i := eq + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+			}
+			v.WriteByte(s[i])
+		case ']':
+			return key, v.String(), i + 1, nil
+		default:
+			v.WriteByte(s[i])
+		}
+	}
+	return "", "", 0, fmt.Errorf("unterminated key %q", key)
+}
+
+// FormatPath renders prefix and path, either possibly nil, in the form read by ParsePath.
+func FormatPath(prefix, path *gpb.Path) string {
+	var b strings.Builder
+	origin := path.GetOrigin()
+	if origin == "" {
+		origin = prefix.GetOrigin()
+	}
+	if origin != "" {
+		b.WriteString(origin + ":")
+	}
+	elems := append(append([]*gpb.PathElem{}, prefix.GetElem()...), path.GetElem()...)
+	for _, elem := range elems {
+		b.WriteString("/" + elem.GetName())
+		keys := make([]string, 0, len(elem.GetKey()))
+		for key := range elem.GetKey() {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := strings.NewReplacer(`\`, `\\`, `]`, `\]`).Replace(elem.GetKey()[key])
+			b.WriteString("[" + key + "=" + value + "]")
+		}
+	}
+	if len(elems) == 0 {
+		b.WriteString("/")
+	}
+	return b.String()
+}
+
+func parseEncoding(encoding string) (gpb.Encoding, error) {
+	value, ok := gpb.Encoding_value[strings.ToUpper(encoding)]
+	if !ok {
+		return 0, fmt.Errorf("unknown gnmi encoding %q", encoding)
+	}
+	return gpb.Encoding(value), nil
+}
+
+func encodeUpdates(updates []Update, encoding gpb.Encoding) ([]*gpb.Update, error) {
+	var encoded []*gpb.Update
+	for _, update := range updates {
+		path, err := ParsePath(update.Path)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(update.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", update.Path, err)
+		}
+		typed := &gpb.TypedValue{Value: &gpb.TypedValue_JsonIetfVal{JsonIetfVal: value}}
+		if encoding == gpb.Encoding_JSON {
+			typed.Value = &gpb.TypedValue_JsonVal{JsonVal: value}
+		}
+		encoded = append(encoded, &gpb.Update{Path: path, Val: typed})
+	}
+	return encoded, nil
+}
+
+func decodeValue(value *gpb.TypedValue) (interface{}, error) {
+	switch v := value.GetValue().(type) {
+	case nil:
+		return nil, nil
+	case *gpb.TypedValue_JsonIetfVal:
+		return decodeJSON(v.JsonIetfVal)
+	case *gpb.TypedValue_JsonVal:
+		return decodeJSON(v.JsonVal)
+	case *gpb.TypedValue_StringVal:
+		return v.StringVal, nil
+	case *gpb.TypedValue_IntVal:
+		return v.IntVal, nil
+	case *gpb.TypedValue_UintVal:
+		return v.UintVal, nil
+	case *gpb.TypedValue_BoolVal:
+		return v.BoolVal, nil
+	case *gpb.TypedValue_BytesVal:
+		return v.BytesVal, nil
+	case *gpb.TypedValue_FloatVal:
+		return v.FloatVal, nil
+	case *gpb.TypedValue_DoubleVal:
+		return v.DoubleVal, nil
+	case *gpb.TypedValue_DecimalVal:
+		return float64(v.DecimalVal.GetDigits()) / math.Pow10(int(v.DecimalVal.GetPrecision())), nil
+	case *gpb.TypedValue_AsciiVal:
+		return v.AsciiVal, nil
+	case *gpb.TypedValue_ProtoBytes:
+		return v.ProtoBytes, nil
+	case *gpb.TypedValue_LeaflistVal:
+		var list []interface{}
+		for _, element := range v.LeaflistVal.GetElement() {
+			decoded, err := decodeValue(element)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, decoded)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("unsupported gnmi value type %T", v)
+	}
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("invalid json value: %w", err)
+	}
+	return value, nil
+}
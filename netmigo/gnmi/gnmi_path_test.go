@@ -0,0 +1,134 @@
+package gnmi
+
+import (
+	"reflect"
+	"testing"
+
+	gpb "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    *gpb.Path
+		wantErr bool
+	}{
+		{
+			path: "/",
+			want: &gpb.Path{},
+		},
+		{
+			path: "/system/name/host-name",
+			want: &gpb.Path{Elem: []*gpb.PathElem{{Name: "system"}, {Name: "name"}, {Name: "host-name"}}},
+		},
+		{
+			path: "/interface[name=ethernet-1/1]/subinterface[index=0]/state",
+			want: &gpb.Path{Elem: []*gpb.PathElem{
+				{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+				{Name: "subinterface", Key: map[string]string{"index": "0"}},
+				{Name: "state"},
+			}},
+		},
+		{
+			path: "/network-instance[name=default]/protocols/bgp/neighbor[peer-address=2001:db8::1]",
+			want: &gpb.Path{Elem: []*gpb.PathElem{
+				{Name: "network-instance", Key: map[string]string{"name": "default"}},
+				{Name: "protocols"}, {Name: "bgp"},
+				{Name: "neighbor", Key: map[string]string{"peer-address": "2001:db8::1"}},
+			}},
+		},
+		{
+			path: "openconfig:/interfaces/interface[name=Ethernet1]",
+			want: &gpb.Path{Origin: "openconfig", Elem: []*gpb.PathElem{
+				{Name: "interfaces"},
+				{Name: "interface", Key: map[string]string{"name": "Ethernet1"}},
+			}},
+		},
+		{
+			path: "/port[port-id=1/1/c1/1][vlan=10]",
+			want: &gpb.Path{Elem: []*gpb.PathElem{{Name: "port", Key: map[string]string{"port-id": "1/1/c1/1", "vlan": "10"}}}},
+		},
+		{
+			path: `/acl[name=a\]b\\c]`,
+			want: &gpb.Path{Elem: []*gpb.PathElem{{Name: "acl", Key: map[string]string{"name": `a]b\c`}}}},
+		},
+		{path: "/[name=x]", wantErr: true},
+		{path: "/interface[=x]", wantErr: true},
+		{path: "/interface[name=ethernet-1/1", wantErr: true},
+		{path: "/interface[name=x]state", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ParsePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !proto.Equal(got, tt.want) {
+				t.Errorf("ParsePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatPathRoundTrip(t *testing.T) {
+	paths := []string{
+		"/",
+		"/interface[name=ethernet-1/1]/subinterface[index=0]/ipv4/address[ip-prefix=10.0.0.1/31]",
+		"/port[port-id=1/1/c1/1][vlan=10]",
+		"openconfig:/interfaces/interface[name=Ethernet1]/state/counters",
+		`/acl[name=a\]b\\c]/entry[sequence-id=10]`,
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			parsed, err := ParsePath(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FormatPath(nil, parsed); got != path {
+				t.Errorf("FormatPath(ParsePath(%q)) = %q", path, got)
+			}
+		})
+	}
+}
+
+func TestFormatPathPrefix(t *testing.T) {
+	prefix, _ := ParsePath("openconfig:/interfaces/interface[name=Ethernet1]")
+	path, _ := ParsePath("/state/oper-status")
+	want := "openconfig:/interfaces/interface[name=Ethernet1]/state/oper-status"
+	if got := FormatPath(prefix, path); got != want {
+		t.Errorf("FormatPath() = %q, want %q", got, want)
+	}
+	if got := FormatPath(nil, nil); got != "/" {
+		t.Errorf("FormatPath(nil, nil) = %q, want /", got)
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value *gpb.TypedValue
+		want  interface{}
+	}{
+		{name: "json_ietf", value: &gpb.TypedValue{Value: &gpb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{"admin-state":"enable"}`)}}, want: map[string]interface{}{"admin-state": "enable"}},
+		{name: "string", value: &gpb.TypedValue{Value: &gpb.TypedValue_StringVal{StringVal: "up"}}, want: "up"},
+		{name: "uint", value: &gpb.TypedValue{Value: &gpb.TypedValue_UintVal{UintVal: 9000}}, want: uint64(9000)},
+		{name: "bool", value: &gpb.TypedValue{Value: &gpb.TypedValue_BoolVal{BoolVal: true}}, want: true},
+		{name: "decimal", value: &gpb.TypedValue{Value: &gpb.TypedValue_DecimalVal{DecimalVal: &gpb.Decimal64{Digits: 1234, Precision: 2}}}, want: 12.34},
+		{name: "leaf-list", value: &gpb.TypedValue{Value: &gpb.TypedValue_LeaflistVal{LeaflistVal: &gpb.ScalarArray{Element: []*gpb.TypedValue{
+			{Value: &gpb.TypedValue_StringVal{StringVal: "a"}}, {Value: &gpb.TypedValue_IntVal{IntVal: -1}},
+		}}}}, want: []interface{}{"a", int64(-1)}},
+		{name: "empty", value: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeValue(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeValue() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
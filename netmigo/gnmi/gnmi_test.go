@@ -0,0 +1,335 @@
+package gnmi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	gpb "github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// fakeTarget is a gNMI server that records the requests and credentials it gets.
+type fakeTarget struct {
+	gpb.UnimplementedGNMIServer
+
+	mu          sync.Mutex
+	credentials []string // "username:password" of each RPC
+	get         *gpb.GetRequest
+	set         *gpb.SetRequest
+}
+
+func (f *fakeTarget) record(ctx context.Context) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.credentials = append(f.credentials, first(md["username"])+":"+first(md["password"]))
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (f *fakeTarget) Capabilities(ctx context.Context, _ *gpb.CapabilityRequest) (*gpb.CapabilityResponse, error) {
+	f.record(ctx)
+	return &gpb.CapabilityResponse{
+		GNMIVersion:        "0.10.0",
+		SupportedEncodings: []gpb.Encoding{gpb.Encoding_JSON_IETF, gpb.Encoding_ASCII},
+		SupportedModels:    []*gpb.ModelData{{Name: "urn:srl_nokia/interfaces:srl_nokia-interfaces", Organization: "Nokia", Version: "2024-10-31"}},
+	}, nil
+}
+
+func (f *fakeTarget) Get(ctx context.Context, request *gpb.GetRequest) (*gpb.GetResponse, error) {
+	f.record(ctx)
+	f.mu.Lock()
+	f.get = request
+	f.mu.Unlock()
+	return &gpb.GetResponse{Notification: []*gpb.Notification{interfaceNotification("up")}}, nil
+}
+
+func (f *fakeTarget) Set(ctx context.Context, request *gpb.SetRequest) (*gpb.SetResponse, error) {
+	f.record(ctx)
+	f.mu.Lock()
+	f.set = request
+	f.mu.Unlock()
+	return &gpb.SetResponse{}, nil
+}
+
+// Subscribe answers with the oper-state of ethernet-1/1 and a sync, then, for a poll
+// subscription, once more after each poll, and for a stream one with a later change.
+func (f *fakeTarget) Subscribe(stream grpc.BidiStreamingServer[gpb.SubscribeRequest, gpb.SubscribeResponse]) error {
+	f.record(stream.Context())
+	request, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	update := func(state string) error {
+		return stream.Send(&gpb.SubscribeResponse{Response: &gpb.SubscribeResponse_Update{Update: interfaceNotification(state)}})
+	}
+	sync := func() error {
+		return stream.Send(&gpb.SubscribeResponse{Response: &gpb.SubscribeResponse_SyncResponse{SyncResponse: true}})
+	}
+	if err := update("up"); err != nil {
+		return err
+	}
+	if err := sync(); err != nil {
+		return err
+	}
+
+	switch request.GetSubscribe().GetMode() {
+	case gpb.SubscriptionList_ONCE:
+		return nil
+	case gpb.SubscriptionList_POLL:
+		for {
+			request, err := stream.Recv()
+			if err != nil {
+				return nil
+			}
+			if request.GetPoll() == nil {
+				return errors.New("expected a poll")
+			}
+			if err := update("up"); err != nil {
+				return err
+			}
+			if err := sync(); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := update("down"); err != nil {
+			return err
+		}
+		<-stream.Context().Done()
+		return nil
+	}
+}
+
+func interfaceNotification(state string) *gpb.Notification {
+	prefix, _ := ParsePath("/interface[name=ethernet-1/1]")
+	path, _ := ParsePath("/oper-state")
+	return &gpb.Notification{
+		Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano(),
+		Prefix:    prefix,
+		Update: []*gpb.Update{{
+			Path: path,
+			Val:  &gpb.TypedValue{Value: &gpb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`"` + state + `"`)}},
+		}},
+	}
+}
+
+// dialFake serves target on a localhost port and returns a client connected to it.
+func dialFake(t *testing.T, target *fakeTarget, opts ...Option) *Client {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	gpb.RegisterGNMIServer(server, target)
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+
+	opts = append([]Option{WithInsecure(), WithCredentials("admin", "NokiaSrl1!"), WithTimeout(5 * time.Second)}, opts...)
+	client, err := Dial(l.Addr().String(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestCapabilities(t *testing.T) {
+	target := &fakeTarget{}
+	client := dialFake(t, target)
+	capabilities, err := client.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Capabilities{
+		Version:   "0.10.0",
+		Encodings: []string{"json_ietf", "ascii"},
+		Models:    []Model{{Name: "urn:srl_nokia/interfaces:srl_nokia-interfaces", Organization: "Nokia", Version: "2024-10-31"}},
+	}
+	if !reflect.DeepEqual(capabilities, want) {
+		t.Errorf("Capabilities() = %+v, want %+v", capabilities, want)
+	}
+	// Dial checks the target with a Capabilities call too
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if want := []string{"admin:NokiaSrl1!", "admin:NokiaSrl1!"}; !reflect.DeepEqual(target.credentials, want) {
+		t.Errorf("credentials = %q, want %q", target.credentials, want)
+	}
+}
+
+func TestGet(t *testing.T) {
+	target := &fakeTarget{}
+	client := dialFake(t, target, WithTarget("leaf1"))
+	updates, err := client.Get("/interface[name=ethernet-1/1]/oper-state", "/system/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Update{{Path: "/interface[name=ethernet-1/1]/oper-state", Value: "up"}}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("Get() = %+v, want %+v", updates, want)
+	}
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	request := target.get
+	if request.GetPrefix().GetTarget() != "leaf1" || request.GetEncoding() != gpb.Encoding_JSON_IETF || request.GetType() != gpb.GetRequest_ALL {
+		t.Errorf("request = %v, want target leaf1, json_ietf encoding, all data", request)
+	}
+	var paths []string
+	for _, path := range request.GetPath() {
+		paths = append(paths, FormatPath(nil, path))
+	}
+	if want := []string{"/interface[name=ethernet-1/1]/oper-state", "/system/name"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %q, want %q", paths, want)
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		request  SetRequest
+		deletes  []string
+		replaces map[string]string
+		updates  map[string]string
+	}{
+		{
+			name: "update",
+			request: SetRequest{Updates: []Update{
+				{Path: "/interface[name=ethernet-1/1]/description", Value: "uplink"},
+				{Path: "/system/name", Value: json.RawMessage(`{"host-name":"leaf1"}`)},
+			}},
+			updates: map[string]string{
+				"/interface[name=ethernet-1/1]/description": `"uplink"`,
+				"/system/name": `{"host-name":"leaf1"}`,
+			},
+		},
+		{
+			name:     "replace",
+			encoding: "json",
+			request:  SetRequest{Replaces: []Update{{Path: "/interface[name=ethernet-1/1]", Value: map[string]string{"admin-state": "enable"}}}},
+			replaces: map[string]string{"/interface[name=ethernet-1/1]": `{"admin-state":"enable"}`},
+		},
+		{
+			name:    "delete",
+			request: SetRequest{Deletes: []string{"/interface[name=ethernet-1/1]/subinterface[index=0]", "/system/banner"}},
+			deletes: []string{"/interface[name=ethernet-1/1]/subinterface[index=0]", "/system/banner"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &fakeTarget{}
+			var opts []Option
+			if tt.encoding != "" {
+				opts = append(opts, WithEncoding(tt.encoding))
+			}
+			if err := dialFake(t, target, opts...).Set(tt.request); err != nil {
+				t.Fatal(err)
+			}
+
+			target.mu.Lock()
+			defer target.mu.Unlock()
+			var deletes []string
+			for _, path := range target.set.GetDelete() {
+				deletes = append(deletes, FormatPath(nil, path))
+			}
+			if !reflect.DeepEqual(deletes, tt.deletes) {
+				t.Errorf("deletes = %q, want %q", deletes, tt.deletes)
+			}
+			checkUpdates(t, "replace", target.set.GetReplace(), tt.replaces, tt.encoding)
+			checkUpdates(t, "update", target.set.GetUpdate(), tt.updates, tt.encoding)
+		})
+	}
+}
+
+// checkUpdates compares the updates of a set request with want, JSON values by path.
+func checkUpdates(t *testing.T, kind string, updates []*gpb.Update, want map[string]string, encoding string) {
+	t.Helper()
+	got := map[string]string{}
+	for _, update := range updates {
+		value := update.GetVal().GetJsonIetfVal()
+		if encoding == "json" {
+			value = update.GetVal().GetJsonVal()
+		}
+		got[FormatPath(nil, update.GetPath())] = string(value)
+	}
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %q, want %q", kind, got, want)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	upPath := "/interface[name=ethernet-1/1]/oper-state"
+	tests := []struct {
+		mode string
+		want []string // updates and syncs received, in order
+	}{
+		{mode: SubscribeOnce, want: []string{"up", "sync"}},
+		{mode: SubscribePoll, want: []string{"up", "sync", "up", "sync"}},
+		{mode: SubscribeStream, want: []string{"up", "sync", "down"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			target := &fakeTarget{}
+			client := dialFake(t, target)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			subscription, err := client.SubscribeContext(ctx, SubscribeRequest{Mode: tt.mode, Paths: []string{upPath}, StreamMode: StreamOnChange})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer subscription.Close()
+
+			var got []string
+			for len(got) < len(tt.want) {
+				notification, err := subscription.Recv()
+				if err != nil {
+					t.Fatalf("Recv() error = %v after %q", err, got)
+				}
+				switch {
+				case notification.Sync:
+					got = append(got, "sync")
+					if tt.mode == SubscribePoll && len(got) == 2 {
+						if err := subscription.Poll(); err != nil {
+							t.Fatal(err)
+						}
+					}
+				case len(notification.Updates) == 1 && notification.Updates[0].Path == upPath:
+					got = append(got, notification.Updates[0].Value.(string))
+				default:
+					t.Fatalf("unexpected notification %+v", notification)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("received %q, want %q", got, tt.want)
+			}
+			if tt.mode == SubscribeOnce {
+				if _, err := subscription.Recv(); err != io.EOF {
+					t.Errorf("Recv() after the sync of a once subscription = %v, want io.EOF", err)
+				}
+			}
+			if tt.mode != SubscribePoll {
+				if err := subscription.Poll(); err == nil {
+					t.Errorf("Poll() of a %s subscription succeeded", tt.mode)
+				}
+			}
+		})
+	}
+}
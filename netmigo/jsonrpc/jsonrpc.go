@@ -0,0 +1,399 @@
+// Package jsonrpc is a client for the SR Linux JSON-RPC management interface, a structured
+// alternative to scraping the CLI with the netmigo SSH driver.
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Datastores.
+const (
+	Running   = "running"
+	Candidate = "candidate"
+	State     = "state"
+	Tools     = "tools"
+)
+
+// Set actions.
+const (
+	ActionUpdate  = "update"
+	ActionReplace = "replace"
+	ActionDelete  = "delete"
+)
+
+// CLI output formats.
+const (
+	FormatJSON  = "json"
+	FormatText  = "text"
+	FormatTable = "table"
+)
+
+// Client is a JSON-RPC client for one SR Linux node.
+type Client struct {
+	URL      string
+	Username string
+	Password string
+	Timeout  time.Duration // per request, 30s by default
+
+	insecure   bool
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+	id         atomic.Int64
+}
+
+// Option configures a Client.
+type Option func(*Client) error
+
+// WithCredentials sets the username and password sent with HTTP basic authentication.
+func WithCredentials(username, password string) Option {
+	return func(c *Client) error {
+		c.Username = username
+		c.Password = password
+		return nil
+	}
+}
+
+// WithInsecure uses plain HTTP instead of HTTPS.
+func WithInsecure() Option {
+	return func(c *Client) error {
+		c.insecure = true
+		return nil
+	}
+}
+
+// WithTLS sets the TLS configuration used to reach the node.
+func WithTLS(config *tls.Config) Option {
+	return func(c *Client) error {
+		c.tlsConfig = config
+		return nil
+	}
+}
+
+// WithSkipVerify keeps HTTPS but does not verify the node certificate.
+func WithSkipVerify() Option {
+	return func(c *Client) error {
+		c.tls().InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithCACert verifies the node certificate against the PEM CA certificates in file.
+func WithCACert(file string) Option {
+	return func(c *Client) error {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", file)
+		}
+		c.tls().RootCAs = pool
+		return nil
+	}
+}
+
+// WithTimeout sets the per request timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) error {
+		c.Timeout = timeout
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client, for custom transports or proxies. TLS options are
+// ignored in that case.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) error {
+		c.httpClient = client
+		return nil
+	}
+}
+
+func (c *Client) tls() *tls.Config {
+	if c.tlsConfig == nil {
+		c.tlsConfig = &tls.Config{}
+	}
+	return c.tlsConfig
+}
+
+// NewClient returns a client for the node at address, a host with an optional port, or a
+// full URL. HTTPS is used unless WithInsecure is given.
+func NewClient(address string, opts ...Option) (*Client, error) {
+	c := &Client{Timeout: 30 * time.Second}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case strings.Contains(address, "://"):
+		c.URL = address
+	case c.insecure:
+		c.URL = "http://" + address + "/jsonrpc"
+	default:
+		c.URL = "https://" + address + "/jsonrpc"
+	}
+	if c.httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if c.tlsConfig != nil {
+			transport.TLSClientConfig = c.tlsConfig
+		}
+		c.httpClient = &http.Client{Transport: transport}
+	}
+	return c, nil
+}
+
+// Command is one entry of the commands parameter. Datastore applies to get commands, Action
+// and Value to set and validate commands.
+type Command struct {
+	Path      string      `json:"path"`
+	Datastore string      `json:"datastore,omitempty"`
+	Action    string      `json:"action,omitempty"`
+	Value     interface{} `json:"value,omitempty"`
+	Recursive *bool       `json:"recursive,omitempty"`
+}
+
+// Params are the parameters of a get, set or validate request. Datastore selects the
+// datastore of set and validate, candidate by default or tools.
+type Params struct {
+	Commands     []Command `json:"commands"`
+	Datastore    string    `json:"datastore,omitempty"`
+	OutputFormat string    `json:"output-format,omitempty"`
+}
+
+// CLIParams are the parameters of a cli request.
+type CLIParams struct {
+	Commands     []string `json:"commands"`
+	OutputFormat string   `json:"output-format,omitempty"`
+}
+
+// Request is a JSON-RPC request.
+type Request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int64       `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// Response is a JSON-RPC response. Result holds one entry per command.
+type Response struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      int64             `json:"id"`
+	Result  []json.RawMessage `json:"result,omitempty"`
+	Error   *Error            `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error returned by the node.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("json-rpc error %d: %s", e.Code, e.Message)
+}
+
+// NewRequest builds a request for method with the next request ID.
+func (c *Client) NewRequest(method string, params interface{}) *Request {
+	return &Request{JSONRPC: "2.0", ID: c.id.Add(1), Method: method, Params: params}
+}
+
+// Do sends a request and returns its response. A JSON-RPC error is returned as *Error.
+func (c *Client) Do(ctx context.Context, request *Request) (*Response, error) {
+	var response Response
+	if err := c.post(ctx, request, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return &response, response.Error
+	}
+	return &response, nil
+}
+
+// DoBatch sends requests as one JSON-RPC batch and returns the responses in request order.
+// Errors of individual requests are left in their responses.
+func (c *Client) DoBatch(ctx context.Context, requests []*Request) ([]*Response, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	var responses []*Response
+	if err := c.post(ctx, requests, &responses); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*Response, len(responses))
+	for _, response := range responses {
+		byID[response.ID] = response
+	}
+	ordered := make([]*Response, len(requests))
+	for i, request := range requests {
+		response, ok := byID[request.ID]
+		if !ok {
+			return nil, fmt.Errorf("no response for json-rpc request %d", request.ID)
+		}
+		ordered[i] = response
+	}
+	return ordered, nil
+}
+
+func (c *Client) post(ctx context.Context, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if c.Username != "" || c.Password != "" {
+		request.SetBasicAuth(c.Username, c.Password)
+	}
+
+	log.Debugf("JSON-RPC request to %s: %s", c.URL, payload)
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("json-rpc request to %s timed out: %w", c.URL, err)
+		}
+		return fmt.Errorf("json-rpc request to %s failed: %w", c.URL, err)
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("json-rpc response read failed: %w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("json-rpc request to %s failed: %s: %s", c.URL, response.Status, strings.TrimSpace(string(data)))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("invalid json-rpc response: %w", err)
+	}
+	return nil
+}
+
+// Get returns the content of paths in datastore, one result per path.
+func (c *Client) Get(datastore string, paths ...string) ([]json.RawMessage, error) {
+	return c.GetContext(context.Background(), datastore, paths...)
+}
+
+// GetContext is like Get but aborts when ctx is done.
+func (c *Client) GetContext(ctx context.Context, datastore string, paths ...string) ([]json.RawMessage, error) {
+	commands := make([]Command, len(paths))
+	for i, path := range paths {
+		commands[i] = Command{Path: path, Datastore: datastore}
+	}
+	response, err := c.Do(ctx, c.NewRequest("get", Params{Commands: commands}))
+	if err != nil {
+		return nil, err
+	}
+	return response.Result, nil
+}
+
+// Set applies commands to datastore, candidate when empty, and commits them as one
+// transaction.
+func (c *Client) Set(datastore string, commands ...Command) error {
+	return c.SetContext(context.Background(), datastore, commands...)
+}
+
+// SetContext is like Set but aborts when ctx is done.
+func (c *Client) SetContext(ctx context.Context, datastore string, commands ...Command) error {
+	_, err := c.Do(ctx, c.NewRequest("set", Params{Commands: commands, Datastore: datastore}))
+	if err == nil {
+		log.Infof("JSON-RPC set of %d commands committed on %s", len(commands), c.URL)
+	}
+	return err
+}
+
+// Validate checks commands against datastore, candidate when empty, without committing them.
+func (c *Client) Validate(datastore string, commands ...Command) error {
+	return c.ValidateContext(context.Background(), datastore, commands...)
+}
+
+// ValidateContext is like Validate but aborts when ctx is done.
+func (c *Client) ValidateContext(ctx context.Context, datastore string, commands ...Command) error {
+	_, err := c.Do(ctx, c.NewRequest("validate", Params{Commands: commands, Datastore: datastore}))
+	return err
+}
+
+// CLI runs CLI commands and returns one result per command, formatted as format.
+func (c *Client) CLI(format string, commands ...string) ([]json.RawMessage, error) {
+	return c.CLIContext(context.Background(), format, commands...)
+}
+
+// CLIContext is like CLI but aborts when ctx is done.
+func (c *Client) CLIContext(ctx context.Context, format string, commands ...string) ([]json.RawMessage, error) {
+	response, err := c.Do(ctx, c.NewRequest("cli", CLIParams{Commands: commands, OutputFormat: format}))
+	if err != nil {
+		return nil, err
+	}
+	return response.Result, nil
+}
+
+// Batch collects set commands to be validated or committed together.
+type Batch struct {
+	client    *Client
+	datastore string
+	Commands  []Command
+}
+
+// NewBatch starts a batch of changes for datastore, candidate when empty.
+func (c *Client) NewBatch(datastore string) *Batch {
+	return &Batch{client: c, datastore: datastore}
+}
+
+// Update merges value at path.
+func (b *Batch) Update(path string, value interface{}) *Batch {
+	b.Commands = append(b.Commands, Command{Path: path, Action: ActionUpdate, Value: value})
+	return b
+}
+
+// Replace replaces the content of path with value.
+func (b *Batch) Replace(path string, value interface{}) *Batch {
+	b.Commands = append(b.Commands, Command{Path: path, Action: ActionReplace, Value: value})
+	return b
+}
+
+// Delete removes path.
+func (b *Batch) Delete(path string) *Batch {
+	b.Commands = append(b.Commands, Command{Path: path, Action: ActionDelete})
+	return b
+}
+
+// Validate checks the batch without committing it.
+func (b *Batch) Validate(ctx context.Context) error {
+	return b.client.ValidateContext(ctx, b.datastore, b.Commands...)
+}
+
+// Commit applies the batch as one transaction.
+func (b *Batch) Commit(ctx context.Context) error {
+	if len(b.Commands) == 0 {
+		return errors.New("empty batch")
+	}
+	return b.client.SetContext(ctx, b.datastore, b.Commands...)
+}
@@ -0,0 +1,242 @@
+package jsonrpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeNode is a JSON-RPC endpoint that records the requests it gets and answers with reply.
+type fakeNode struct {
+	bodies   []map[string]interface{}
+	username string
+	password string
+	reply    func(request Request) Response
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.username, n.password, _ = r.BasicAuth()
+	data, _ := io.ReadAll(r.Body)
+
+	// A batch is answered in reverse order, as nodes are free to do
+	if strings.HasPrefix(string(data), "[") {
+		var requests []Request
+		json.Unmarshal(data, &requests)
+		var responses []Response
+		for i := len(requests) - 1; i >= 0; i-- {
+			responses = append(responses, n.reply(requests[i]))
+		}
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+
+	var body map[string]interface{}
+	json.Unmarshal(data, &body)
+	n.bodies = append(n.bodies, body)
+	var request Request
+	json.Unmarshal(data, &request)
+	json.NewEncoder(w).Encode(n.reply(request))
+}
+
+// echoResult answers each request with its method as the only result.
+func echoResult(request Request) Response {
+	return Response{JSONRPC: "2.0", ID: request.ID, Result: []json.RawMessage{json.RawMessage(`"` + request.Method + `"`)}}
+}
+
+func newFakeClient(t *testing.T, node *fakeNode) *Client {
+	t.Helper()
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+	client, err := NewClient(server.URL+"/jsonrpc", WithCredentials("admin", "NokiaSrl1!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestClientRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		call   func(c *Client) error
+		method string
+		params string
+	}{
+		{
+			name: "get",
+			call: func(c *Client) error {
+				_, err := c.Get(State, "/interface[name=ethernet-1/1]", "/system/name")
+				return err
+			},
+			method: "get",
+			params: `{"commands":[{"path":"/interface[name=ethernet-1/1]","datastore":"state"},{"path":"/system/name","datastore":"state"}]}`,
+		},
+		{
+			name: "set",
+			call: func(c *Client) error {
+				return c.Set("", Command{Path: "/system/name/host-name", Action: ActionUpdate, Value: "leaf1"})
+			},
+			method: "set",
+			params: `{"commands":[{"path":"/system/name/host-name","action":"update","value":"leaf1"}]}`,
+		},
+		{
+			name: "validate tools",
+			call: func(c *Client) error {
+				return c.Validate(Tools, Command{Path: "/interface[name=ethernet-1/1]/statistics", Action: ActionUpdate, Value: map[string]bool{"clear": true}})
+			},
+			method: "validate",
+			params: `{"commands":[{"path":"/interface[name=ethernet-1/1]/statistics","action":"update","value":{"clear":true}}],"datastore":"tools"}`,
+		},
+		{
+			name: "cli",
+			call: func(c *Client) error {
+				_, err := c.CLI(FormatText, "show version")
+				return err
+			},
+			method: "cli",
+			params: `{"commands":["show version"],"output-format":"text"}`,
+		},
+		{
+			name: "batch",
+			call: func(c *Client) error {
+				return c.NewBatch(Candidate).
+					Update("/system/name", map[string]string{"host-name": "leaf1"}).
+					Replace("/system/banner", map[string]string{"login-banner": "lab"}).
+					Delete("/interface[name=ethernet-1/2]").
+					Commit(context.Background())
+			},
+			method: "set",
+			params: `{"commands":[{"path":"/system/name","action":"update","value":{"host-name":"leaf1"}},` +
+				`{"path":"/system/banner","action":"replace","value":{"login-banner":"lab"}},` +
+				`{"path":"/interface[name=ethernet-1/2]","action":"delete"}],"datastore":"candidate"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &fakeNode{reply: echoResult}
+			if err := tt.call(newFakeClient(t, node)); err != nil {
+				t.Fatal(err)
+			}
+			if len(node.bodies) != 1 {
+				t.Fatalf("node got %d requests, want 1", len(node.bodies))
+			}
+			body := node.bodies[0]
+			if body["jsonrpc"] != "2.0" || body["method"] != tt.method || body["id"] == nil {
+				t.Errorf("request = %v, want a JSON-RPC 2.0 %s request with an id", body, tt.method)
+			}
+			var want interface{}
+			json.Unmarshal([]byte(tt.params), &want)
+			if !reflect.DeepEqual(body["params"], want) {
+				got, _ := json.Marshal(body["params"])
+				t.Errorf("params = %s, want %s", got, tt.params)
+			}
+			if node.username != "admin" || node.password != "NokiaSrl1!" {
+				t.Errorf("basic auth = %q, %q, want the credentials", node.username, node.password)
+			}
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	rpcError := func(request Request) Response {
+		return Response{JSONRPC: "2.0", ID: request.ID, Error: &Error{Code: -1, Message: "Path not valid"}}
+	}
+	t.Run("json-rpc error", func(t *testing.T) {
+		_, err := newFakeClient(t, &fakeNode{reply: rpcError}).Get(Running, "/bad")
+		var jsonErr *Error
+		if !errors.As(err, &jsonErr) || jsonErr.Code != -1 || jsonErr.Message != "Path not valid" {
+			t.Errorf("Get() error = %v, want the json-rpc error", err)
+		}
+	})
+
+	t.Run("http status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "authentication failed", http.StatusUnauthorized)
+		}))
+		defer server.Close()
+		client, _ := NewClient(server.URL)
+		_, err := client.Get(Running, "/system")
+		var jsonErr *Error
+		if err == nil || errors.As(err, &jsonErr) || !strings.Contains(err.Error(), "401") || !strings.Contains(err.Error(), "authentication failed") {
+			t.Errorf("Get() error = %v, want the HTTP status and body", err)
+		}
+	})
+
+	t.Run("empty batch", func(t *testing.T) {
+		node := &fakeNode{reply: echoResult}
+		if err := newFakeClient(t, node).NewBatch(Candidate).Commit(context.Background()); err == nil {
+			t.Error("Commit() of an empty batch succeeded")
+		}
+		if len(node.bodies) != 0 {
+			t.Errorf("empty batch sent %d requests", len(node.bodies))
+		}
+	})
+}
+
+func TestDoBatch(t *testing.T) {
+	client := newFakeClient(t, &fakeNode{reply: echoResult})
+	requests := []*Request{
+		client.NewRequest("get", Params{Commands: []Command{{Path: "/system/name", Datastore: State}}}),
+		client.NewRequest("cli", CLIParams{Commands: []string{"show version"}}),
+		client.NewRequest("validate", Params{Commands: []Command{{Path: "/system", Action: ActionDelete}}}),
+	}
+	responses, err := client.DoBatch(context.Background(), requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, response := range responses {
+		if response.ID != requests[i].ID || string(response.Result[0]) != `"`+requests[i].Method+`"` {
+			t.Errorf("response %d = id %d %s, want the response to %s", i, response.ID, response.Result[0], requests[i].Method)
+		}
+	}
+
+	dropFirst := newFakeClient(t, &fakeNode{reply: func(request Request) Response {
+		if request.ID == 1 {
+			request.ID = 99
+		}
+		return echoResult(request)
+	}})
+	requests = []*Request{dropFirst.NewRequest("get", Params{}), dropFirst.NewRequest("get", Params{})}
+	if _, err := dropFirst.DoBatch(context.Background(), requests); err == nil || !strings.Contains(err.Error(), "request 1") {
+		t.Errorf("DoBatch() error = %v, want the missing response reported", err)
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(&fakeNode{reply: echoResult})
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "https://")
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "trusted CA", opts: []Option{WithTLS(&tls.Config{RootCAs: pool})}},
+		{name: "skip verify", opts: []Option{WithSkipVerify()}},
+		{name: "unknown CA", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(address, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.URL != "https://"+address+"/jsonrpc" {
+				t.Errorf("URL = %s, want https", client.URL)
+			}
+			if _, err := client.CLI(FormatJSON, "show version"); (err != nil) != tt.wantErr {
+				t.Errorf("CLI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,11 +1,10 @@
 package netmigo
 
 import (
-	"bufio"
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -19,40 +18,90 @@ type JUNOSDeviceConnection struct {
 	Prompt     string
 }
 
+// junosErrorPattern matches the error lines JUNOS prints for a rejected command.
+var junosErrorPattern = regexp.MustCompile(`(?m)^[ \t]*(syntax error|unknown command)[^\r\n]*`)
+
+// junosCommitFailurePattern matches the messages of a rejected commit, such as
+// "error: configuration check-out failed" and "error: commit failed: ...".
+var junosCommitFailurePattern = regexp.MustCompile(`(?m)^[ \t]*error: [^\r\n]*`)
+
+// configPrompt returns the configuration mode prompt for an operational prompt, such as
+// "admin@vmx-ne1#" for "admin@vmx-ne1>".
+func configPrompt(prompt string) string {
+	return strings.TrimSuffix(prompt, ">") + "#"
+}
+
 func NewJUNOSDeviceConnection(connection *SSHConnModel, DeviceType string) (*JUNOSDeviceConnection, error) {
-	return &JUNOSDeviceConnection{
+	junos := &JUNOSDeviceConnection{
 		DeviceConnection: DeviceConnection{
-			Connection: connection,
-			Return:     "\n",
+			Connection:   connection,
+			Return:       "\n",
+			errorPattern: junosErrorPattern,
 		},
 		DeviceType: DeviceType,
-	}, nil
+	}
+	junos.prepare = junos.findPrompt
+	return junos, nil
 }
 
 // NewDevice initializes a new JUNOS device connection
-func InitJUNOSDevice(Host string, Username string, Password string, Port uint8) (*JUNOSDeviceConnection, error) {
+// Extra options are applied after the credentials and port, a zero Port selects the default.
+func InitJUNOSDevice(Host string, Username string, Password string, Port uint16, opts ...Option) (*JUNOSDeviceConnection, error) {
 
 	// Create connection
-	connection, err := InitTransport(Host, Username, Password, "ssh", Port)
+	opts = append([]Option{WithCredentials(Username, Password), WithPort(Port)}, opts...)
+	connection, err := NewConnection(Host, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create the device
-	return NewJUNOSDeviceConnection(connection, "juniper_junos")
+	return NewJUNOSDeviceConnection(connection, DeviceTypeJUNOS)
 }
 
 func (junos *JUNOSDeviceConnection) Connect() error {
-	if err := junos.DeviceConnection.ConnectXterm(); err != nil {
+	return junos.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect but aborts when ctx is done.
+func (junos *JUNOSDeviceConnection) ConnectContext(ctx context.Context) error {
+	if err := junos.DeviceConnection.ConnectXtermContext(ctx); err != nil {
 		return err
 	}
+	return junos.findPrompt(ctx)
+}
 
+// OpenChannel opens an extra shell channel over the same SSH client and prepares it like
+// Connect does, so that commands can run on it concurrently with junos.
+func (junos *JUNOSDeviceConnection) OpenChannel() (*JUNOSDeviceConnection, error) {
+	return junos.OpenChannelContext(context.Background())
+}
+
+// OpenChannelContext is like OpenChannel but aborts when ctx is done.
+func (junos *JUNOSDeviceConnection) OpenChannelContext(ctx context.Context) (*JUNOSDeviceConnection, error) {
+	channel, err := junos.Connection.NewChannel()
+	if err != nil {
+		return nil, err
+	}
+	device, err := NewJUNOSDeviceConnection(channel, junos.DeviceType)
+	if err != nil {
+		return nil, err
+	}
+	if err := device.ConnectContext(ctx); err != nil {
+		device.Disconnect()
+		return nil, err
+	}
+	return device, nil
+}
+
+// findPrompt discovers the device prompt, after Connect and on every reconnect.
+func (junos *JUNOSDeviceConnection) findPrompt(ctx context.Context) error {
 	// Define the regex pattern to find the JUNOS device prompt
 	// This pattern captures the prompt with variable content before the '>'
 	const promptPattern = `[\w\-\.@]+>` // Matches strings like "admin@vmx-ne1>"
 	const expectedPromptSuffix = ">"
 
-	prompt, err := junos.FindDevicePrompt(promptPattern, expectedPromptSuffix)
+	prompt, err := junos.FindDevicePromptContext(ctx, promptPattern, expectedPromptSuffix)
 	if err != nil {
 		return err
 	}
@@ -64,70 +113,75 @@ func (junos *JUNOSDeviceConnection) Connect() error {
 }
 
 func (junos *JUNOSDeviceConnection) SendCommand(command string, cliPromptMode string, timeout time.Duration) (string, error) {
+	return junos.SendCommandContext(context.Background(), command, cliPromptMode, timeout)
+}
+
+// SendCommandContext is like SendCommand but aborts when ctx is done, returning the partial output in a ContextError.
+func (junos *JUNOSDeviceConnection) SendCommandContext(ctx context.Context, command string, cliPromptMode string, timeout time.Duration) (string, error) {
+	result, err := junos.SendCommandResultContext(ctx, command, cliPromptMode, timeout)
+	if err != nil {
+		return "", err
+	}
+	return result.Output, nil
+}
+
+// SendCommandResult is like SendCommand but also returns the raw output of the device.
+func (junos *JUNOSDeviceConnection) SendCommandResult(command string, cliPromptMode string, timeout time.Duration) (*CommandResult, error) {
+	return junos.SendCommandResultContext(context.Background(), command, cliPromptMode, timeout)
+}
+
+// SendCommandResultContext is like SendCommandResult but aborts when ctx is done, returning the partial output in a ContextError.
+// The "exec" cliPromptMode runs the command over an exec channel instead of the shell.
+func (junos *JUNOSDeviceConnection) SendCommandResultContext(ctx context.Context, command string, cliPromptMode string, timeout time.Duration) (*CommandResult, error) {
+	if cliPromptMode == "exec" {
+		// Run the command in its own exec channel, no prompt matching needed
+		output, err := junos.execOutput(ctx, command)
+		if err != nil {
+			return nil, err
+		}
+		return &CommandResult{Command: command, Output: output, Raw: output}, nil
+	}
+	if err := junos.ensureConnected(ctx); err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = junos.commandTimeout(ctx)
+	}
 
-	var outputBuffer bytes.Buffer
 	var promptMode string
-	var processedOutput string
+	var result *CommandResult
 
 	var err error
 
 	stdin := junos.Connection.Writer
-	stdout := junos.Connection.Reader
-
-	scanner := bufio.NewScanner(stdout)
-	done := make(chan bool)
+	junos.syncOutput(ctx)
 
 	if cliPromptMode == "running" {
 		promptMode = junos.Prompt // admin@vmx-ne1>
 
-		go func() {
-			defer func() { done <- true }()
-			appearanceCount := 0
-			for scanner.Scan() {
-				line := cleanOutputJunos(scanner.Text())
-				outputBuffer.WriteString(line + "\n")
-				log.Info("Received line: ", line)
-
-				// Increment appearance count if the line contains the specific string
-				if strings.Contains(line, promptMode) {
-					log.Infof("line contain PromptMode, appearance count: %s", strconv.Itoa(appearanceCount))
-					appearanceCount++
-					if appearanceCount == 1 {
-						log.Infof("line contain PromptMode, appearance count: %s", strconv.Itoa(appearanceCount))
-						log.Infof("Detected %s appearance of end marker", strconv.Itoa(appearanceCount))
-						done <- true
-						return
-					}
-				}
-
-			}
-			if err := scanner.Err(); err != nil {
-				log.Error("Error reading stdout:", err)
-			}
-		}()
+		// The device echoes the command as sent, pipe included
+		sent := command + " | no-more"
 
 		log.Infof("Sending command: %s", command)
-		_, err := fmt.Fprintf(stdin, "%s | no-more \n\n", command)
+		_, err := fmt.Fprintf(stdin, "%s \n\n", sent)
 
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
-		// Wait for the output to be read or timeout
-		select {
-		case <-done:
-			log.Info("Reading completed")
-		case <-time.After(timeout):
-			log.Info("Timeout waiting for reading to complete")
+		// Wait for the end marker, answering pagers, or timeout
+		output, err := junos.expectLines(ctx, timeout, "send command "+command, regexp.QuoteMeta(promptMode), 1)
+		if err != nil {
+			return nil, err
+		}
+		// The extra return prints one more prompt
+		junos.owePrompt(regexp.QuoteMeta(promptMode))
+		if err := junos.commandError(junos.Prompt, command, output); err != nil {
+			return nil, err
 		}
 
-		output := outputBuffer.String()
-
-		lines := strings.Split(output, "\n")               // Split the output string into lines
-		trimmedLines := lines[1 : len(lines)-1]            // Remove the first and last two lines
-		processedOutput = strings.Join(trimmedLines, "\n") // Join the remaining lines into a single string
-		// fmt.Print(processedOutput)
+		result = &CommandResult{Command: command, Output: trimOutput(output, sent, junos.Prompt), Raw: output}
 
 		log.Info("Final output: ")
 		log.Debug(output)
@@ -135,80 +189,58 @@ func (junos *JUNOSDeviceConnection) SendCommand(command string, cliPromptMode st
 	} else if cliPromptMode == "candidate" {
 		promptMode = "[edit]"
 
-		go func() {
-			defer func() { done <- true }()
-			appearanceCount := 0
-			for scanner.Scan() {
-				line := cleanOutputJunos(scanner.Text())
-				outputBuffer.WriteString(line + "\n")
-				log.Info("Received line: ", line+"\n")
-
-				// Increment appearance count if the line contains the specific string
-				if strings.Contains(line, promptMode) || strings.Contains(line, "commit complete") {
-					appearanceCount++
-					if appearanceCount == 4 {
-						log.Info("Detected second appearance of end marker")
-						done <- true
-						return
-					}
-				}
-
-			}
-			if err = scanner.Err(); err != nil {
-				log.Error("Error reading stdout:", err)
-			}
-		}()
-
 		log.Infof("Sending command: %s", command)
 
 		_, err = fmt.Fprintf(stdin, "%s\n", "configure")
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
 		_, err = fmt.Fprintf(stdin, "%s\n", command)
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
 		_, err = fmt.Fprintf(stdin, "%s\n", "commit")
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
-		// Wait for the output to be read or timeout
-		select {
-		case <-done:
-			log.Info("Reading completed")
-		case <-time.After(timeout):
-			log.Info("Timeout waiting for reading to complete")
+		// Wait for the end marker, answering pagers, or timeout
+		output, err := junos.expectLines(ctx, timeout, "send command "+command, regexp.QuoteMeta(promptMode)+"|commit complete", 4)
+		if err != nil {
+			var timeoutErr *TimeoutError
+			if errors.As(err, &timeoutErr) {
+				if err := junos.commandError(junos.Prompt, command, timeoutErr.Output); err != nil {
+					return nil, err
+				}
+				return nil, &CommitError{Output: timeoutErr.Output, Err: err}
+			}
+			return nil, err
+		}
+		if err := junos.commandError(junos.Prompt, command, output); err != nil {
+			return nil, err
+		}
+		if err := commitFailure(junosCommitFailurePattern, output); err != nil {
+			return nil, err
+		}
+		if !strings.Contains(output, "commit complete") {
+			return nil, &CommitError{Output: output}
 		}
 
-		output := outputBuffer.String()
-
-		lines := strings.Split(output, "\n")               // Split the output string into lines
-		trimmedLines := lines[10 : len(lines)-2]           // Remove the first and last two lines
-		processedOutput = strings.Join(trimmedLines, "\n") // Join the remaining lines into a single string
-		// fmt.Print(processedOutput)
+		result = &CommandResult{Command: command, Output: trimOutput(output, command, junos.Prompt, configPrompt(junos.Prompt), promptMode), Raw: output}
 
 		log.Info("Final output: ")
 		log.Debug(output)
 
 	} else {
-		log.Infof("Unspported cliPromptMode: %s", cliPromptMode)
-		return "", nil
+		log.Errorf("Unsupported cliPromptMode: %s", cliPromptMode)
+		return nil, fmt.Errorf("unsupported cliPromptMode %q", cliPromptMode)
 	}
 
-	return processedOutput, nil
-
-}
-
-func cleanOutputJunos(output string) string {
-	re := regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]|\x1b\[\?2004[lh]|\x1b\[\?25[hl]|\x1b\[\?12[hl]|\x1b\[\?7[hl]`)
-	output = re.ReplaceAllString(output, "")
+	return result, nil
 
-	return output
 }
@@ -0,0 +1,238 @@
+package netmigo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Algorithms lists the SSH algorithms offered to the device, in preference order. An empty
+// list keeps the choice of the selected profile.
+type Algorithms struct {
+	KeyExchanges []string
+	HostKeys     []string
+	MACs         []string
+	Ciphers      []string
+}
+
+// Algorithm profiles selectable with WithAlgorithmProfile.
+const (
+	// AlgorithmsModern offers only current algorithms: no SHA-1, no CBC modes.
+	AlgorithmsModern = "modern"
+	// AlgorithmsCompat adds diffie-hellman-group14-sha1, ssh-rsa host keys, hmac-sha1,
+	// aes128-cbc and 3des-cbc. It is the default and offers every cipher netmigo offered
+	// before profiles existed.
+	AlgorithmsCompat = "compat"
+	// AlgorithmsLegacy further adds diffie-hellman-group1-sha1, group exchange, ssh-dss
+	// host keys and hmac-sha1-96 for old releases.
+	AlgorithmsLegacy = "legacy"
+)
+
+var (
+	modernKeyExchanges = []string{
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+	}
+	modernHostKeys = []string{
+		ssh.CertAlgoED25519v01, ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+		ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	}
+	modernMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com", "hmac-sha2-256", "hmac-sha2-512",
+	}
+	modernCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+		"aes256-ctr", "aes192-ctr", "aes128-ctr",
+	}
+)
+
+// algorithmProfiles maps profile names to their algorithms. Each profile extends the
+// previous one, keeping the stronger algorithms first.
+var algorithmProfiles = map[string]Algorithms{
+	AlgorithmsModern: {
+		KeyExchanges: modernKeyExchanges,
+		HostKeys:     modernHostKeys,
+		MACs:         modernMACs,
+		Ciphers:      modernCiphers,
+	},
+	AlgorithmsCompat: {
+		KeyExchanges: extend(modernKeyExchanges, "diffie-hellman-group14-sha1"),
+		HostKeys:     extend(modernHostKeys, ssh.CertAlgoRSAv01, ssh.KeyAlgoRSA),
+		MACs:         extend(modernMACs, "hmac-sha1"),
+		Ciphers:      extend(modernCiphers, "aes128-cbc", "3des-cbc"),
+	},
+	AlgorithmsLegacy: {
+		KeyExchanges: extend(modernKeyExchanges, "diffie-hellman-group14-sha1",
+			"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1", "diffie-hellman-group1-sha1"),
+		HostKeys: extend(modernHostKeys, ssh.CertAlgoRSAv01, ssh.KeyAlgoRSA, ssh.CertAlgoDSAv01, ssh.KeyAlgoDSA),
+		MACs:     extend(modernMACs, "hmac-sha1", "hmac-sha1-96"),
+		Ciphers:  extend(modernCiphers, "aes128-cbc", "3des-cbc"),
+	},
+}
+
+// extend returns base followed by the algorithms it does not contain yet.
+func extend(base []string, algorithms ...string) []string {
+	result := append([]string(nil), base...)
+	for _, algorithm := range algorithms {
+		if !contains(result, algorithm) {
+			result = append(result, algorithm)
+		}
+	}
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WithAlgorithmProfile selects the "modern", "compat" or "legacy" algorithm profile.
+func WithAlgorithmProfile(profile string) Option {
+	return func(c *SSHConnModel) error {
+		if _, ok := algorithmProfiles[profile]; !ok {
+			return fmt.Errorf("unknown algorithm profile %q", profile)
+		}
+		c.AlgorithmProfile = profile
+		return nil
+	}
+}
+
+// WithKeyExchanges overrides the key exchange algorithms of the profile.
+func WithKeyExchanges(algorithms ...string) Option {
+	return func(c *SSHConnModel) error {
+		return setAlgorithms(&c.Algorithms.KeyExchanges, "key exchange", algorithmProfiles[AlgorithmsLegacy].KeyExchanges, algorithms)
+	}
+}
+
+// WithHostKeyAlgorithms overrides the host key algorithms of the profile.
+func WithHostKeyAlgorithms(algorithms ...string) Option {
+	return func(c *SSHConnModel) error {
+		return setAlgorithms(&c.Algorithms.HostKeys, "host key", algorithmProfiles[AlgorithmsLegacy].HostKeys, algorithms)
+	}
+}
+
+// WithMACs overrides the MAC algorithms of the profile.
+func WithMACs(algorithms ...string) Option {
+	return func(c *SSHConnModel) error {
+		return setAlgorithms(&c.Algorithms.MACs, "MAC", algorithmProfiles[AlgorithmsLegacy].MACs, algorithms)
+	}
+}
+
+// WithCiphers overrides the ciphers of the profile.
+func WithCiphers(algorithms ...string) Option {
+	return func(c *SSHConnModel) error {
+		return setAlgorithms(&c.Algorithms.Ciphers, "cipher", algorithmProfiles[AlgorithmsLegacy].Ciphers, algorithms)
+	}
+}
+
+// setAlgorithms checks algorithms against the supported ones and stores them in target.
+func setAlgorithms(target *[]string, kind string, supported, algorithms []string) error {
+	if len(algorithms) == 0 {
+		return fmt.Errorf("at least one %s algorithm is required", kind)
+	}
+	for _, algorithm := range algorithms {
+		if !contains(supported, algorithm) {
+			return fmt.Errorf("unsupported %s algorithm %q", kind, algorithm)
+		}
+	}
+	*target = algorithms
+	return nil
+}
+
+// algorithms returns the profile algorithms with the per-device overrides applied.
+func (c *SSHConnModel) algorithms() (Algorithms, error) {
+	name := c.AlgorithmProfile
+	if name == "" {
+		name = AlgorithmsCompat
+	}
+	result, ok := algorithmProfiles[name]
+	if !ok {
+		return Algorithms{}, fmt.Errorf("unknown algorithm profile %q", name)
+	}
+	if len(c.Algorithms.KeyExchanges) > 0 {
+		result.KeyExchanges = c.Algorithms.KeyExchanges
+	}
+	if len(c.Algorithms.HostKeys) > 0 {
+		result.HostKeys = c.Algorithms.HostKeys
+	}
+	if len(c.Algorithms.MACs) > 0 {
+		result.MACs = c.Algorithms.MACs
+	}
+	if len(c.Algorithms.Ciphers) > 0 {
+		result.Ciphers = c.Algorithms.Ciphers
+	}
+	return result, nil
+}
+
+// AlgorithmNegotiationError is returned when the client and the device share no algorithm
+// of some kind. DeviceLacks tells which side is missing one: when false, the device offers
+// algorithms netmigo supports but the profile or overrides do not enable.
+type AlgorithmNegotiationError struct {
+	Host          string
+	Kind          string // "key exchange", "host key", "client to server cipher", ...
+	ClientOffered []string
+	DeviceOffered []string
+	DeviceLacks   bool
+}
+
+func (e *AlgorithmNegotiationError) Error() string {
+	if e.DeviceLacks {
+		return fmt.Sprintf("%s: device does not support any offered %s algorithm: client offered %v, device offered %v",
+			e.Host, e.Kind, e.ClientOffered, e.DeviceOffered)
+	}
+	return fmt.Sprintf("%s: client configuration does not enable any %s algorithm of the device, "+
+		"select a wider algorithm profile or override the list: client offered %v, device offered %v",
+		e.Host, e.Kind, e.ClientOffered, e.DeviceOffered)
+}
+
+var noCommonAlgorithmRegexp = regexp.MustCompile(`no common algorithm for ([^;]+); client offered: \[([^\]]*)\], server offered: \[([^\]]*)\]`)
+
+// negotiationError turns the x/crypto "no common algorithm" handshake error into an
+// AlgorithmNegotiationError. Other errors are returned unchanged.
+func negotiationError(host string, err error) error {
+	var negotiation *AlgorithmNegotiationError
+	if err == nil || errors.As(err, &negotiation) {
+		return err
+	}
+	m := noCommonAlgorithmRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+
+	e := &AlgorithmNegotiationError{
+		Host:          host,
+		Kind:          m[1],
+		ClientOffered: strings.Fields(m[2]),
+		DeviceOffered: strings.Fields(m[3]),
+		DeviceLacks:   true,
+	}
+	supported := algorithmProfiles[AlgorithmsLegacy]
+	var known []string
+	switch {
+	case e.Kind == "key exchange":
+		known = supported.KeyExchanges
+	case e.Kind == "host key":
+		known = supported.HostKeys
+	case strings.HasSuffix(e.Kind, "cipher"):
+		known = supported.Ciphers
+	case strings.HasSuffix(e.Kind, "MAC"):
+		known = supported.MACs
+	}
+	for _, algorithm := range e.DeviceOffered {
+		if contains(known, algorithm) {
+			e.DeviceLacks = false
+			break
+		}
+	}
+	return e
+}
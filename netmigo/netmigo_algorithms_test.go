@@ -0,0 +1,164 @@
+package netmigo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAlgorithmProfiles(t *testing.T) {
+	// Every cipher offered before the profiles existed stays in the default profile
+	for _, cipher := range []string{"aes256-ctr", "aes128-ctr", "aes128-cbc", "3des-cbc"} {
+		if !contains(algorithmProfiles[AlgorithmsCompat].Ciphers, cipher) {
+			t.Errorf("compat profile lacks cipher %s", cipher)
+		}
+	}
+	for _, cipher := range algorithmProfiles[AlgorithmsModern].Ciphers {
+		if cipher == "aes128-cbc" || cipher == "3des-cbc" {
+			t.Errorf("modern profile offers CBC cipher %s", cipher)
+		}
+	}
+	// Each profile extends the previous one
+	order := []string{AlgorithmsModern, AlgorithmsCompat, AlgorithmsLegacy}
+	for i := 1; i < len(order); i++ {
+		narrow, wide := algorithmProfiles[order[i-1]], algorithmProfiles[order[i]]
+		for _, lists := range [][2][]string{
+			{narrow.KeyExchanges, wide.KeyExchanges},
+			{narrow.HostKeys, wide.HostKeys},
+			{narrow.MACs, wide.MACs},
+			{narrow.Ciphers, wide.Ciphers},
+		} {
+			if !reflect.DeepEqual(lists[0], lists[1][:len(lists[0])]) {
+				t.Errorf("%s profile does not start with the %s algorithms: %v, %v", order[i], order[i-1], lists[1], lists[0])
+			}
+		}
+	}
+}
+
+func TestConnectionAlgorithms(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		want    func(Algorithms) bool
+		wantErr bool
+	}{
+		{
+			name: "compat by default",
+			want: func(a Algorithms) bool { return reflect.DeepEqual(a, algorithmProfiles[AlgorithmsCompat]) },
+		},
+		{
+			name: "profile",
+			opts: []Option{WithAlgorithmProfile(AlgorithmsModern)},
+			want: func(a Algorithms) bool { return reflect.DeepEqual(a, algorithmProfiles[AlgorithmsModern]) },
+		},
+		{
+			name: "override",
+			opts: []Option{WithAlgorithmProfile(AlgorithmsModern), WithCiphers("3des-cbc")},
+			want: func(a Algorithms) bool {
+				return reflect.DeepEqual(a.Ciphers, []string{"3des-cbc"}) &&
+					reflect.DeepEqual(a.MACs, algorithmProfiles[AlgorithmsModern].MACs)
+			},
+		},
+		{
+			name:    "unknown profile",
+			opts:    []Option{WithAlgorithmProfile("paranoid")},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported cipher",
+			opts:    []Option{WithCiphers("rc4")},
+			wantErr: true,
+		},
+		{
+			name:    "empty override",
+			opts:    []Option{WithMACs()},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewConnection("192.0.2.1", tt.opts...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("NewConnection() succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.algorithms()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.want(got) {
+				t.Errorf("algorithms() = %+v", got)
+			}
+		})
+	}
+}
+
+func TestNegotiationError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want *AlgorithmNegotiationError
+	}{
+		{
+			name: "device lacks",
+			err:  errors.New("ssh: handshake failed: ssh: no common algorithm for key exchange; client offered: [curve25519-sha256 ecdh-sha2-nistp256], server offered: [sntrup761x25519-sha512@openssh.com]"),
+			want: &AlgorithmNegotiationError{
+				Host:          "r1",
+				Kind:          "key exchange",
+				ClientOffered: []string{"curve25519-sha256", "ecdh-sha2-nistp256"},
+				DeviceOffered: []string{"sntrup761x25519-sha512@openssh.com"},
+				DeviceLacks:   true,
+			},
+		},
+		{
+			name: "profile too narrow",
+			err:  errors.New("ssh: handshake failed: ssh: no common algorithm for client to server cipher; client offered: [aes128-gcm@openssh.com aes128-ctr], server offered: [aes128-cbc 3des-cbc]"),
+			want: &AlgorithmNegotiationError{
+				Host:          "r1",
+				Kind:          "client to server cipher",
+				ClientOffered: []string{"aes128-gcm@openssh.com", "aes128-ctr"},
+				DeviceOffered: []string{"aes128-cbc", "3des-cbc"},
+			},
+		},
+		{
+			name: "host key",
+			err:  errors.New("ssh: handshake failed: ssh: no common algorithm for host key; client offered: [ssh-ed25519], server offered: [ssh-dss]"),
+			want: &AlgorithmNegotiationError{
+				Host:          "r1",
+				Kind:          "host key",
+				ClientOffered: []string{"ssh-ed25519"},
+				DeviceOffered: []string{"ssh-dss"},
+			},
+		},
+		{
+			name: "other error",
+			err:  errors.New("ssh: handshake failed: EOF"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := negotiationError("r1", tt.err)
+			var got *AlgorithmNegotiationError
+			if !errors.As(err, &got) {
+				if tt.want != nil {
+					t.Fatalf("negotiationError() = %v, want an AlgorithmNegotiationError", err)
+				}
+				if err != tt.err {
+					t.Errorf("negotiationError() = %v, want the error unchanged", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("negotiationError() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if negotiationError("r1", nil) != nil {
+		t.Error("negotiationError(nil) != nil")
+	}
+}
@@ -0,0 +1,152 @@
+package netmigo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSHKey describes a private key file used for public-key authentication.
+// Certificate optionally points to an OpenSSH user certificate (for example
+// "id_ed25519-cert.pub") signed for this key.
+type SSHKey struct {
+	Path        string
+	Passphrase  string
+	Certificate string
+}
+
+// AddPrivateKey adds a private key file to the authentication methods of the connection.
+// The passphrase may be empty for unencrypted keys.
+func (c *SSHConnModel) AddPrivateKey(path, passphrase string) {
+	c.Keys = append(c.Keys, SSHKey{Path: path, Passphrase: passphrase})
+}
+
+// AddCertificate adds a private key together with its OpenSSH user certificate.
+func (c *SSHConnModel) AddCertificate(keyPath, certPath, passphrase string) {
+	c.Keys = append(c.Keys, SSHKey{Path: keyPath, Passphrase: passphrase, Certificate: certPath})
+}
+
+// EnableAgent makes the connection offer the keys held by the ssh-agent listening on SSH_AUTH_SOCK.
+func (c *SSHConnModel) EnableAgent() {
+	c.UseAgent = true
+}
+
+// authMethods returns the SSH authentication methods configured for the connection.
+// Public keys (agent first, then key files) are tried before password and keyboard-interactive.
+func (c *SSHConnModel) authMethods() ([]ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+
+	if c.UseAgent {
+		agentSigners, err := c.agentSigners()
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, agentSigners...)
+	}
+
+	for _, key := range c.Keys {
+		signer, err := loadSigner(key)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	var methods []ssh.AuthMethod
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if c.Password != "" {
+		methods = append(methods, ssh.Password(c.Password), ssh.KeyboardInteractive(getInteractiveCallBack(c.Password)))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no authentication method configured, set a password, a private key or enable the ssh-agent")
+	}
+	return methods, nil
+}
+
+// agentSigners returns the signers held by the ssh-agent. The agent connection is kept open
+// until Disconnect, as signing happens during the handshake.
+func (c *SSHConnModel) agentSigners() ([]ssh.Signer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errors.New("ssh-agent requested but SSH_AUTH_SOCK is not set")
+	}
+
+	if c.agentConn == nil {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to ssh-agent: %w", err)
+		}
+		c.agentConn = conn
+	}
+
+	signers, err := agent.NewClient(c.agentConn).Signers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+	}
+	log.Debugf("ssh-agent offered %d key(s)", len(signers))
+	return signers, nil
+}
+
+// closeAgent closes the ssh-agent connection, if any.
+func (c *SSHConnModel) closeAgent() {
+	if c.agentConn != nil {
+		c.agentConn.Close()
+		c.agentConn = nil
+	}
+}
+
+// loadSigner parses a private key file, decrypting it when a passphrase is given,
+// and wraps it with its user certificate when one is configured.
+func loadSigner(key SSHKey) (ssh.Signer, error) {
+	pemBytes, err := os.ReadFile(key.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key '%s': %w", key.Path, err)
+	}
+
+	var signer ssh.Signer
+	if key.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(key.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(pemBytes)
+	}
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("private key '%s' is encrypted, a passphrase is required: %w", key.Path, err)
+		}
+		return nil, fmt.Errorf("failed to parse private key '%s': %w", key.Path, err)
+	}
+
+	if key.Certificate == "" {
+		return signer, nil
+	}
+
+	certBytes, err := os.ReadFile(key.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate '%s': %w", key.Certificate, err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate '%s': %w", key.Certificate, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not an OpenSSH certificate", key.Certificate)
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("'%s' is not a user certificate", key.Certificate)
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate '%s' does not match private key '%s': %w", key.Certificate, key.Path, err)
+	}
+	return certSigner, nil
+}
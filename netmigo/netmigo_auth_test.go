@@ -0,0 +1,150 @@
+package netmigo
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeKey writes a new ed25519 private key, encrypted when passphrase is set, and returns
+// its path and signer.
+func writeKey(t *testing.T, dir, name, passphrase string) (string, ssh.Signer) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, signer
+}
+
+// writeCertificate signs key with ca as a certificate of certType and returns its path.
+func writeCertificate(t *testing.T, dir, name string, key ssh.PublicKey, ca ssh.Signer, certType uint32) string {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		ValidPrincipals: []string{"admin"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, ssh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSigner(t *testing.T) {
+	dir := t.TempDir()
+	plain, plainSigner := writeKey(t, dir, "id_plain", "")
+	encrypted, _ := writeKey(t, dir, "id_encrypted", "passphrase")
+	other, otherSigner := writeKey(t, dir, "id_other", "")
+	_, ca := writeKey(t, dir, "ca", "")
+	userCert := writeCertificate(t, dir, "id_plain-cert.pub", plainSigner.PublicKey(), ca, ssh.UserCert)
+	hostCert := writeCertificate(t, dir, "host-cert.pub", plainSigner.PublicKey(), ca, ssh.HostCert)
+	otherCert := writeCertificate(t, dir, "id_other-cert.pub", otherSigner.PublicKey(), ca, ssh.UserCert)
+	publicKey := filepath.Join(dir, "id_plain.pub")
+	if err := os.WriteFile(publicKey, ssh.MarshalAuthorizedKey(plainSigner.PublicKey()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      SSHKey
+		wantType string
+		wantErr  string
+	}{
+		{name: "plain", key: SSHKey{Path: plain}, wantType: ssh.KeyAlgoED25519},
+		{name: "encrypted", key: SSHKey{Path: encrypted, Passphrase: "passphrase"}, wantType: ssh.KeyAlgoED25519},
+		{name: "missing passphrase", key: SSHKey{Path: encrypted}, wantErr: "a passphrase is required"},
+		{name: "wrong passphrase", key: SSHKey{Path: encrypted, Passphrase: "wrong"}, wantErr: "failed to parse private key"},
+		{name: "missing file", key: SSHKey{Path: filepath.Join(dir, "missing")}, wantErr: "failed to read private key"},
+		{name: "certificate", key: SSHKey{Path: plain, Certificate: userCert}, wantType: ssh.CertAlgoED25519v01},
+		{name: "host certificate", key: SSHKey{Path: plain, Certificate: hostCert}, wantErr: "is not a user certificate"},
+		{name: "plain public key", key: SSHKey{Path: plain, Certificate: publicKey}, wantErr: "is not an OpenSSH certificate"},
+		{name: "certificate of another key", key: SSHKey{Path: plain, Certificate: otherCert}, wantErr: "does not match private key"},
+		{name: "missing certificate", key: SSHKey{Path: other, Certificate: filepath.Join(dir, "missing")}, wantErr: "failed to read certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := loadSigner(tt.key)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadSigner() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := signer.PublicKey().Type(); got != tt.wantType {
+				t.Errorf("loadSigner() key type = %q, want %q", got, tt.wantType)
+			}
+		})
+	}
+
+	// The causes stay reachable for callers telling a missing file from a locked key
+	if _, err := loadSigner(SSHKey{Path: filepath.Join(dir, "missing")}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("loadSigner() error = %v, want fs.ErrNotExist", err)
+	}
+	var missing *ssh.PassphraseMissingError
+	if _, err := loadSigner(SSHKey{Path: encrypted}); !errors.As(err, &missing) {
+		t.Errorf("loadSigner() error = %v, want a PassphraseMissingError", err)
+	}
+}
+
+func TestAuthMethods(t *testing.T) {
+	key, _ := writeKey(t, t.TempDir(), "id_ed25519", "")
+	tests := []struct {
+		name    string
+		conn    *SSHConnModel
+		wantLen int
+		wantErr bool
+	}{
+		{name: "password", conn: &SSHConnModel{Password: "secret"}, wantLen: 2},
+		{name: "key", conn: &SSHConnModel{Keys: []SSHKey{{Path: key}}}, wantLen: 1},
+		{name: "key and password", conn: &SSHConnModel{Password: "secret", Keys: []SSHKey{{Path: key}}}, wantLen: 3},
+		{name: "nothing", conn: &SSHConnModel{}, wantErr: true},
+		{name: "unreadable key", conn: &SSHConnModel{Password: "secret", Keys: []SSHKey{{Path: key + ".missing"}}}, wantErr: true},
+		{name: "agent without socket", conn: &SSHConnModel{UseAgent: true}, wantErr: true},
+	}
+	t.Setenv("SSH_AUTH_SOCK", "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methods, err := tt.conn.authMethods()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("authMethods() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(methods) != tt.wantLen {
+				t.Errorf("authMethods() returned %d methods, want %d", len(methods), tt.wantLen)
+			}
+		})
+	}
+}
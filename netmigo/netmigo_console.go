@@ -0,0 +1,233 @@
+package netmigo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// telnetBRK is the telnet BREAK command.
+const telnetBRK = 243
+
+// maxConsoleWakeups bounds the carriage returns sent to a silent console line.
+const maxConsoleWakeups = 5
+
+// ConsoleState is the state a console line was found in.
+type ConsoleState int
+
+const (
+	ConsoleUnknown ConsoleState = iota
+	ConsoleCLI
+	ConsoleROMMON
+	ConsoleJunosLoader
+	ConsoleSROSBoot
+)
+
+func (s ConsoleState) String() string {
+	switch s {
+	case ConsoleCLI:
+		return "CLI"
+	case ConsoleROMMON:
+		return "ROMMON"
+	case ConsoleJunosLoader:
+		return "JUNOS loader"
+	case ConsoleSROSBoot:
+		return "SROS boot"
+	default:
+		return "unknown"
+	}
+}
+
+// ConsoleStateError is returned when a console line is not at a CLI prompt. The session
+// stays open so that the device can be recovered with SendCommandPattern, SendBreak and
+// AttachConsole.
+type ConsoleStateError struct {
+	State  ConsoleState
+	Output string
+}
+
+func (e *ConsoleStateError) Error() string {
+	return fmt.Sprintf("console is in %s state, no CLI prompt", e.State)
+}
+
+// Console prompts, most specific first: the CLI prompt pattern matches boot prompts too.
+var (
+	consoleWakePrompt   = regexp.MustCompile(`(?i)press return to get started`)
+	consoleROMMONPrompt = regexp.MustCompile(`(?i)rommon\s*\d*\s*>\s*$`)
+	consoleLoaderPrompt = regexp.MustCompile(`(?i)loader>\s*$`)
+	consoleSROSBoot     = regexp.MustCompile(`(?i)(\[?boot\]?\s*>\s*$|to change boot (options|parms))`)
+	consoleCLIPrompt    = telnetShellPrompt
+
+	consolePrompts = []string{
+		telnetLoginFailed.String(), telnetPasswordPrompt.String(), telnetUsernamePrompt.String(),
+		consoleWakePrompt.String(), consoleROMMONPrompt.String(), consoleLoaderPrompt.String(),
+		consoleSROSBoot.String(), consoleCLIPrompt.String(),
+	}
+)
+
+// Indexes of consolePrompts.
+const (
+	consoleLoginFailed = iota
+	consolePassword
+	consoleUsername
+	consoleWake
+	consoleROMMON
+	consoleLoader
+	consoleBoot
+	consoleCLI
+)
+
+// WithConsole treats the connection as a console line reached through a terminal server,
+// by reverse telnet or SSH on a per-line port. Connecting then wakes the line up, answers
+// the login dialog and waits for a CLI prompt before the vendor driver takes over.
+func WithConsole() Option {
+	return func(c *SSHConnModel) error {
+		c.Console = true
+		return nil
+	}
+}
+
+// AttachConsole wakes up a console line with carriage returns, answers the login dialog and
+// reports the state the device is in. A rejected login is retried on the line with the next
+// credentials of the credential providers, see WithCredentialProvider. It returns a ConsoleStateError unless a CLI prompt
+// is reached; the prompt itself is left for the vendor driver to discover.
+func (d *DeviceConnection) AttachConsole() (ConsoleState, error) {
+	return d.AttachConsoleContext(context.Background())
+}
+
+// AttachConsoleContext is like AttachConsole but aborts when ctx is done.
+func (d *DeviceConnection) AttachConsoleContext(ctx context.Context) (ConsoleState, error) {
+	output, err := d.Connection.sessionOutput()
+	if err != nil {
+		return ConsoleUnknown, err
+	}
+	var transcript strings.Builder
+	candidates := d.Connection.credentialCandidates(ctx)
+	if len(candidates) == 0 {
+		candidates = []Credential{{}}
+	}
+	credential := 0
+	sentUsername, sentPassword := false, false
+	// rejected moves on to the next credentials, the line prompts for them again.
+	rejected := func(reason string) error {
+		if credential+1 == len(candidates) {
+			return d.consoleAuthError(candidates[credential].Username, reason)
+		}
+		log.Warnf("Console login as %s rejected, trying the next credentials (%d/%d)", candidates[credential].Username, credential+2, len(candidates))
+		credential++
+		sentUsername, sentPassword = false, false
+		return nil
+	}
+	for wakeups := 0; wakeups <= maxConsoleWakeups; {
+		result, err := d.expect(ctx, d.readTimeout(ctx), "attach console", consolePrompts...)
+		var ctxErr *ContextError
+		switch {
+		case errors.As(err, &ctxErr):
+			ctxErr.Output = transcript.String() + ctxErr.Output
+			return ConsoleUnknown, err
+		case errors.Is(err, context.DeadlineExceeded):
+			// The line is silent or mid-screen, nudge it.
+			transcript.WriteString(result.Before)
+			log.Debug("Console line silent, sending carriage return")
+			wakeups++
+			if _, err := d.Connection.WriteString(d.Return); err != nil {
+				return ConsoleUnknown, err
+			}
+			continue
+		case err != nil:
+			return ConsoleUnknown, fmt.Errorf("console read failed: %w", err)
+		}
+		out := result.Output()
+		transcript.WriteString(out)
+		if result.Index != consoleWake && !output.quiet(ctx, promptSettleTime) {
+			// More output follows, the match was part of a banner.
+			continue
+		}
+
+		switch result.Index {
+		case consoleLoginFailed:
+			if err := rejected(strings.TrimSpace(out)); err != nil {
+				return ConsoleUnknown, err
+			}
+		case consolePassword:
+			if sentPassword {
+				if err := rejected("password prompted again"); err != nil {
+					return ConsoleUnknown, err
+				}
+			}
+			log.Info("Console password prompt, sending password")
+			sentPassword = true
+			if _, err := d.Connection.WriteString(candidates[credential].Password + d.Return); err != nil {
+				return ConsoleUnknown, err
+			}
+		case consoleUsername:
+			if sentUsername && sentPassword {
+				if err := rejected("username prompted again"); err != nil {
+					return ConsoleUnknown, err
+				}
+			}
+			log.Info("Console login prompt, sending username")
+			sentUsername = true
+			if _, err := d.Connection.WriteString(candidates[credential].Username + d.Return); err != nil {
+				return ConsoleUnknown, err
+			}
+		case consoleWake:
+			if _, err := d.Connection.WriteString(d.Return); err != nil {
+				return ConsoleUnknown, err
+			}
+		case consoleROMMON:
+			return ConsoleROMMON, d.consoleStateError(ConsoleROMMON, transcript.String())
+		case consoleLoader:
+			return ConsoleJunosLoader, d.consoleStateError(ConsoleJunosLoader, transcript.String())
+		case consoleBoot:
+			return ConsoleSROSBoot, d.consoleStateError(ConsoleSROSBoot, transcript.String())
+		default:
+			log.Info("Console reached a CLI prompt")
+			if credential > 0 {
+				// Reconnects try the accepted credentials first
+				d.Connection.Username, d.Connection.Password = candidates[credential].Username, candidates[credential].Password
+			}
+			// Leave a fresh prompt for the vendor prompt discovery.
+			if _, err := d.Connection.WriteString(d.Return); err != nil {
+				return ConsoleUnknown, err
+			}
+			return ConsoleCLI, nil
+		}
+	}
+	return ConsoleUnknown, &ConsoleStateError{State: ConsoleUnknown, Output: transcript.String()}
+}
+
+// consoleAuthError reports a login rejected on the console line.
+func (d *DeviceConnection) consoleAuthError(username, reason string) error {
+	return &AuthError{Host: d.Connection.Addr, Username: username, Err: errors.New("console login rejected: " + reason)}
+}
+
+func (d *DeviceConnection) consoleStateError(state ConsoleState, output string) error {
+	err := &ConsoleStateError{State: state, Output: output}
+	log.Warn(err)
+	return err
+}
+
+// SendBreak sends a serial BREAK to the console line: the telnet BRK command, or an SSH
+// "break" channel request (RFC 4335) on SSH sessions.
+func (c *SSHConnModel) SendBreak() error {
+	if c.telnet != nil {
+		return c.telnet.writeRaw([]byte{telnetIAC, telnetBRK})
+	}
+	if c.session == nil {
+		return errors.New("not connected to device, make sure to call .Connect() first")
+	}
+	ok, err := c.session.SendRequest("break", true, binary.BigEndian.AppendUint32(nil, 500))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("break request refused by the terminal server")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package netmigo
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttachConsole(t *testing.T) {
+	tests := []struct {
+		name    string
+		banner  []string
+		prompt  string
+		want    ConsoleState
+		wantErr error
+	}{
+		{
+			name:   "login",
+			prompt: "router#",
+			want:   ConsoleCLI,
+		},
+		{
+			name:   "banner with prompt characters",
+			banner: []string{"Terminal server ts1 line #12 >", "\r\n### Authorized use only ###\r\n"},
+			prompt: "router#",
+			want:   ConsoleCLI,
+		},
+		{
+			name:    "rommon",
+			prompt:  "rommon 1 >",
+			want:    ConsoleROMMON,
+			wantErr: &ConsoleStateError{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var username, password string
+			d := pipeDevice(t, func(line net.Conn, lines *bufio.Reader) {
+				for _, piece := range tt.banner {
+					line.Write([]byte(piece))
+					time.Sleep(50 * time.Millisecond)
+				}
+				line.Write([]byte("Username: "))
+				username, _ = lines.ReadString('\n')
+				line.Write([]byte("Password: "))
+				password, _ = lines.ReadString('\n')
+				line.Write([]byte("\r\n" + tt.prompt))
+				// Answer the carriage return that leaves a fresh prompt
+				lines.ReadString('\n')
+				line.Write([]byte("\r\n" + tt.prompt))
+			})
+
+			state, err := d.AttachConsole()
+			if state != tt.want {
+				t.Errorf("AttachConsole() state = %v, want %v", state, tt.want)
+			}
+			var stateErr *ConsoleStateError
+			if (tt.wantErr != nil) != errors.As(err, &stateErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("AttachConsole() error = %v, want %T", err, tt.wantErr)
+			}
+			if strings.TrimSpace(username) != "admin" || strings.TrimSpace(password) != "secret" {
+				t.Errorf("line received %q and %q, want the credentials", username, password)
+			}
+		})
+	}
+}
+
+func TestAttachConsoleCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		fallback  []Credential
+		want      ConsoleState
+		wantLogin []string
+	}{
+		{
+			name:      "fallback accepted",
+			fallback:  []Credential{{Username: "admin", Password: "secret"}},
+			want:      ConsoleCLI,
+			wantLogin: []string{"admin/expired", "admin/secret"},
+		},
+		{
+			name:      "all rejected",
+			fallback:  []Credential{{Username: "ops", Password: "other"}},
+			want:      ConsoleUnknown,
+			wantLogin: []string{"admin/expired", "ops/other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logins := make(chan string, 3)
+			d := pipeDevice(t, func(line net.Conn, lines *bufio.Reader) {
+				for i := 0; i < 3; i++ {
+					line.Write([]byte("Username: "))
+					username, _ := lines.ReadString('\n')
+					line.Write([]byte("Password: "))
+					password, _ := lines.ReadString('\n')
+					logins <- strings.TrimSpace(username) + "/" + strings.TrimSpace(password)
+					if strings.TrimSpace(password) == "secret" {
+						line.Write([]byte("\r\nrouter#"))
+						lines.ReadString('\n')
+						line.Write([]byte("\r\nrouter#"))
+						return
+					}
+					line.Write([]byte("\r\n% Login invalid\r\n\r\n"))
+				}
+			})
+			d.Connection.Password = "expired"
+			d.Connection.CredentialProviders = []CredentialProvider{StaticCredentials(tt.fallback)}
+
+			state, err := d.AttachConsole()
+			if state != tt.want {
+				t.Errorf("AttachConsole() state = %v, want %v", state, tt.want)
+			}
+			if tt.want == ConsoleCLI {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d.Connection.Password != "secret" {
+					t.Errorf("password kept for reconnects = %q, want the accepted one", d.Connection.Password)
+				}
+			} else if !errors.Is(err, ErrAuthFailed) {
+				t.Errorf("AttachConsole() error = %v, want ErrAuthFailed", err)
+			}
+			for _, want := range tt.wantLogin {
+				if got := <-logins; got != want {
+					t.Errorf("line received login %s, want %s", got, want)
+				}
+			}
+		})
+	}
+}
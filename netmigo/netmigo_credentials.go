@@ -0,0 +1,414 @@
+package netmigo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Credential is a username and password pair. Its String method hides the password so
+// that credentials can be logged safely.
+type Credential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (c Credential) String() string {
+	return c.Username + ":<redacted>"
+}
+
+// GoString hides the password from %#v as well.
+func (c Credential) GoString() string {
+	return c.String()
+}
+
+// CredentialProvider looks up the credentials for a host, in the order they should be tried.
+// A provider that knows nothing about the host returns no credentials and no error.
+type CredentialProvider interface {
+	Credentials(ctx context.Context, host string) ([]Credential, error)
+}
+
+// WithCredentialProvider adds credential sources, consulted in order on every connect after
+// the username and password of the connection.
+func WithCredentialProvider(providers ...CredentialProvider) Option {
+	return func(c *SSHConnModel) error {
+		for _, provider := range providers {
+			if provider == nil {
+				return errors.New("credential provider must not be nil")
+			}
+		}
+		c.CredentialProviders = append(c.CredentialProviders, providers...)
+		return nil
+	}
+}
+
+// WithFallbackCredentials adds credentials tried in order when the previous ones are
+// rejected, for example local break-glass accounts for when TACACS is down.
+func WithFallbackCredentials(credentials ...Credential) Option {
+	return WithCredentialProvider(StaticCredentials(credentials))
+}
+
+// StaticCredentials is a fixed list of credentials used for every host.
+type StaticCredentials []Credential
+
+// Credentials returns the list.
+func (s StaticCredentials) Credentials(ctx context.Context, host string) ([]Credential, error) {
+	return s, nil
+}
+
+// EnvCredentials reads the username and password from environment variables.
+type EnvCredentials struct {
+	UsernameVar string
+	PasswordVar string
+}
+
+// NewEnvCredentials reads <prefix>_USERNAME and <prefix>_PASSWORD, NETMIGO_USERNAME and
+// NETMIGO_PASSWORD for an empty prefix.
+func NewEnvCredentials(prefix string) *EnvCredentials {
+	if prefix == "" {
+		prefix = "NETMIGO"
+	}
+	return &EnvCredentials{UsernameVar: prefix + "_USERNAME", PasswordVar: prefix + "_PASSWORD"}
+}
+
+// Credentials returns the credential held by the environment, if the username is set.
+func (e *EnvCredentials) Credentials(ctx context.Context, host string) ([]Credential, error) {
+	username := os.Getenv(e.UsernameVar)
+	if username == "" {
+		return nil, nil
+	}
+	return []Credential{{Username: username, Password: os.Getenv(e.PasswordVar)}}, nil
+}
+
+// NetrcCredentials reads a .netrc-style file of "machine <host> login <user> password
+// <password>" entries, with an optional "default" entry.
+type NetrcCredentials struct {
+	Path string
+}
+
+// NewNetrcCredentials reads path, or ~/.netrc when path is empty.
+func NewNetrcCredentials(path string) *NetrcCredentials {
+	if path == "" {
+		path = expandHome("~/.netrc")
+	}
+	return &NetrcCredentials{Path: path}
+}
+
+// Credentials returns the entries for host, or the default entry.
+func (n *NetrcCredentials) Credentials(ctx context.Context, host string) ([]Credential, error) {
+	data, err := os.ReadFile(n.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read netrc file: %w", err)
+	}
+
+	// Tokens of every line, with macdef bodies (up to the next empty line) left out.
+	var fields []string
+	inMacro := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		tokens := strings.Fields(line)
+		for i, token := range tokens {
+			if token == "macdef" {
+				tokens = tokens[:i]
+				inMacro = true
+				break
+			}
+		}
+		fields = append(fields, tokens...)
+	}
+
+	var matches, defaults []Credential
+	var current *Credential
+	for i := 0; i < len(fields); i++ {
+		next := func() string {
+			if i+1 < len(fields) {
+				i++
+				return fields[i]
+			}
+			return ""
+		}
+		switch fields[i] {
+		case "machine":
+			current = nil
+			if strings.EqualFold(next(), host) {
+				matches = append(matches, Credential{})
+				current = &matches[len(matches)-1]
+			}
+		case "default":
+			defaults = append(defaults, Credential{})
+			current = &defaults[len(defaults)-1]
+		case "login":
+			if value := next(); current != nil {
+				current.Username = value
+			}
+		case "password":
+			if value := next(); current != nil {
+				current.Password = value
+			}
+		case "account":
+			next()
+		}
+	}
+	if len(matches) > 0 {
+		return matches, nil
+	}
+	return defaults, nil
+}
+
+// VaultCredentials reads an encrypted vault file written by WriteVault. The vault maps host
+// names or wildcard patterns ("*.lab", "*") to credential lists.
+type VaultCredentials struct {
+	Path       string
+	Passphrase string
+}
+
+// NewVaultCredentials opens the vault at path with passphrase.
+func NewVaultCredentials(path, passphrase string) *VaultCredentials {
+	return &VaultCredentials{Path: path, Passphrase: passphrase}
+}
+
+// vaultFile is the on-disk vault format: AES-256-GCM over the JSON encoded credentials,
+// with the key derived from the passphrase by scrypt.
+type vaultFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// Credentials returns the credentials stored for host, or for the first matching pattern.
+func (v *VaultCredentials) Credentials(ctx context.Context, host string) ([]Credential, error) {
+	entries, err := ReadVault(v.Path, v.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if credentials, ok := entries[host]; ok {
+		return credentials, nil
+	}
+	patterns := make([]string, 0, len(entries))
+	for pattern := range entries {
+		patterns = append(patterns, pattern)
+	}
+	// Longer patterns are more specific, try them first.
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	for _, pattern := range patterns {
+		if matchHostPattern(pattern, host) {
+			return entries[pattern], nil
+		}
+	}
+	return nil, nil
+}
+
+// ReadVault decrypts the vault at path.
+func ReadVault(path, passphrase string) (map[string][]Credential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault: %w", err)
+	}
+	var file vaultFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse vault: %w", err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("unsupported vault version %d", file.Version)
+	}
+
+	aead, err := vaultCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt vault: wrong passphrase or corrupted file")
+	}
+
+	var entries map[string][]Credential
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse vault content: %w", err)
+	}
+	return entries, nil
+}
+
+// WriteVault encrypts entries with passphrase and writes them to path, readable by the
+// owner only.
+func WriteVault(path, passphrase string, entries map[string][]Credential) error {
+	plain, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	file := vaultFile{Version: 1, Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	aead, err := vaultCipher(passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plain, nil)
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func vaultCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errors.New("vault passphrase must not be empty")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// HelperCredentials runs an external helper, in the style of git credential helpers: the
+// helper is invoked with a "get" argument, receives "protocol=ssh" and "host=<host>" lines
+// on stdin and answers with "username=" and "password=" lines on stdout.
+type HelperCredentials struct {
+	Command string
+	Args    []string
+}
+
+// NewHelperCredentials returns a provider running command with args.
+func NewHelperCredentials(command string, args ...string) *HelperCredentials {
+	return &HelperCredentials{Command: command, Args: args}
+}
+
+// Credentials runs the helper for host.
+func (h *HelperCredentials) Credentials(ctx context.Context, host string) ([]Credential, error) {
+	cmd := exec.CommandContext(ctx, h.Command, append(append([]string(nil), h.Args...), "get")...)
+	cmd.Stdin = strings.NewReader("protocol=ssh\nhost=" + host + "\n\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential helper %s failed: %w: %s", h.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var credential Credential
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "username":
+			credential.Username = value
+		case "password":
+			credential.Password = value
+		}
+	}
+	if credential.Username == "" {
+		return nil, nil
+	}
+	return []Credential{credential}, nil
+}
+
+// credentialCandidates returns the credentials to try, in order: the username and password
+// of the connection, then those of every provider. Duplicates are dropped.
+func (c *SSHConnModel) credentialCandidates(ctx context.Context) []Credential {
+	var candidates []Credential
+	add := func(credential Credential) {
+		for _, seen := range candidates {
+			if seen == credential {
+				return
+			}
+		}
+		candidates = append(candidates, credential)
+	}
+
+	if c.Username != "" || c.Password != "" {
+		add(Credential{Username: c.Username, Password: c.Password})
+	}
+	host, _, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		host = c.Addr
+	}
+	for _, provider := range c.CredentialProviders {
+		credentials, err := provider.Credentials(ctx, host)
+		if err != nil {
+			log.Warnf("Credential provider %T failed: %v", provider, err)
+			continue
+		}
+		for _, credential := range credentials {
+			add(credential)
+		}
+	}
+	return candidates
+}
+
+// tryCredentials runs connect with each candidate credential until one is not rejected.
+// The accepted credential stays on the connection, so that reconnects try it first; when
+// all are rejected the original username and password are restored.
+func (c *SSHConnModel) tryCredentials(ctx context.Context, connect func() error) (err error) {
+	if len(c.CredentialProviders) == 0 {
+		return connect()
+	}
+	candidates := c.credentialCandidates(ctx)
+	if len(candidates) == 0 {
+		return errors.New("no credentials available for " + c.Addr)
+	}
+
+	username, password := c.Username, c.Password
+	defer func() {
+		if err != nil {
+			c.Username, c.Password = username, password
+		}
+	}()
+	for i, credential := range candidates {
+		c.Username, c.Password = credential.Username, credential.Password
+		err = connect()
+		if err == nil || !c.isAuthFailure(err) || ctx.Err() != nil {
+			return err
+		}
+		if i+1 < len(candidates) {
+			log.Warnf("Authentication to %s as %s failed, trying the next credentials (%d/%d)", c.Addr, credential.Username, i+2, len(candidates))
+		}
+	}
+	return err
+}
+
+// isAuthFailure reports whether the device rejected the login, as opposed to a network or
+// jump host failure.
+func (c *SSHConnModel) isAuthFailure(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr) && authErr.Host == c.Addr
+}
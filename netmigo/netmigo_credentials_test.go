@@ -0,0 +1,205 @@
+package netmigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCredentialRedaction(t *testing.T) {
+	credential := Credential{Username: "admin", Password: "secret"}
+	for _, format := range []string{"%v", "%s", "%+v", "%#v"} {
+		if out := fmt.Sprintf(format, credential); strings.Contains(out, "secret") {
+			t.Errorf("Sprintf(%q) = %q shows the password", format, out)
+		}
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	t.Setenv("LAB_USERNAME", "admin")
+	t.Setenv("LAB_PASSWORD", "secret")
+	t.Setenv("NETMIGO_USERNAME", "")
+	ctx := context.Background()
+
+	got, err := NewEnvCredentials("LAB").Credentials(ctx, "r1")
+	if err != nil || !reflect.DeepEqual(got, []Credential{{Username: "admin", Password: "secret"}}) {
+		t.Errorf("Credentials() = %v, %v", got, err)
+	}
+	if got, err := NewEnvCredentials("").Credentials(ctx, "r1"); err != nil || got != nil {
+		t.Errorf("Credentials() without a username = %v, %v, want none", got, err)
+	}
+}
+
+func TestNetrcCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	netrc := `machine r1 login admin password secret
+machine r2
+  login ops
+  account lab
+  password s3cret
+machine R2 login backup password b4ckup
+macdef init
+machine r3 login macro password macro
+
+default login guest password guest
+`
+	if err := os.WriteFile(path, []byte(netrc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want []Credential
+	}{
+		{host: "r1", want: []Credential{{Username: "admin", Password: "secret"}}},
+		{host: "r2", want: []Credential{{Username: "ops", Password: "s3cret"}, {Username: "backup", Password: "b4ckup"}}},
+		{host: "r3", want: []Credential{{Username: "guest", Password: "guest"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got, err := NewNetrcCredentials(path).Credentials(context.Background(), tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Credentials() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewNetrcCredentials(filepath.Join(t.TempDir(), "missing")).Credentials(context.Background(), "r1"); err == nil {
+		t.Error("Credentials() of a missing file succeeded")
+	}
+}
+
+func TestVaultCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault", "credentials.json")
+	entries := map[string][]Credential{
+		"r1":      {{Username: "admin", Password: "secret"}},
+		"*.lab":   {{Username: "lab", Password: "lab"}},
+		"pe*.lab": {{Username: "pe", Password: "pe"}},
+		"*":       {{Username: "guest", Password: "guest"}},
+	}
+	if err := WriteVault(path, "passphrase", entries); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Error("vault file holds the password in clear")
+	}
+
+	vault := NewVaultCredentials(path, "passphrase")
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "r1", want: "admin"},
+		{host: "pe1.lab", want: "pe"},
+		{host: "p1.lab", want: "lab"},
+		{host: "r2", want: "guest"},
+	}
+	for _, tt := range tests {
+		got, err := vault.Credentials(context.Background(), tt.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Username != tt.want {
+			t.Errorf("Credentials(%s) = %v, want %s", tt.host, got, tt.want)
+		}
+	}
+
+	if _, err := ReadVault(path, "wrong"); err == nil {
+		t.Error("ReadVault() with a wrong passphrase succeeded")
+	}
+	if err := WriteVault(path, "", entries); err == nil {
+		t.Error("WriteVault() with an empty passphrase succeeded")
+	}
+}
+
+func TestHelperCredentials(t *testing.T) {
+	helper := filepath.Join(t.TempDir(), "helper")
+	script := "#!/bin/sh\n[ \"$1\" = get ] || exit 1\nwhile read line && [ -n \"$line\" ]; do\n  case $line in host=r1) found=1 ;; esac\ndone\n" +
+		"[ -n \"$found\" ] && printf 'username=admin\\npassword=p=ss\\n'\nexit 0\n"
+	if err := os.WriteFile(helper, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewHelperCredentials(helper).Credentials(context.Background(), "r1")
+	if err != nil || !reflect.DeepEqual(got, []Credential{{Username: "admin", Password: "p=ss"}}) {
+		t.Errorf("Credentials(r1) = %v, %v", got, err)
+	}
+	if got, err := NewHelperCredentials(helper).Credentials(context.Background(), "r2"); err != nil || got != nil {
+		t.Errorf("Credentials(r2) = %v, %v, want none", got, err)
+	}
+	if _, err := NewHelperCredentials(helper, "extra").Credentials(context.Background(), "r1"); err == nil {
+		t.Error("Credentials() of a failing helper succeeded")
+	}
+}
+
+// failingProvider returns an error for every host.
+type failingProvider struct{}
+
+func (failingProvider) Credentials(ctx context.Context, host string) ([]Credential, error) {
+	return nil, errors.New("provider down")
+}
+
+func TestTryCredentials(t *testing.T) {
+	c, err := NewConnection("r1",
+		WithCredentials("admin", "tacacs"),
+		WithCredentialProvider(failingProvider{}),
+		WithFallbackCredentials(Credential{"admin", "tacacs"}, Credential{"admin", "local"}, Credential{"root", "root"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tried []string
+	accept := func(password string) func() error {
+		return func() error {
+			tried = append(tried, c.Username+"/"+c.Password)
+			if c.Password != password {
+				return &AuthError{Host: c.Addr, Username: c.Username}
+			}
+			return nil
+		}
+	}
+
+	// Duplicates are tried once and the accepted credential stays on the connection
+	if err := c.tryCredentials(context.Background(), accept("local")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"admin/tacacs", "admin/local"}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("tried %q, want %q", tried, want)
+	}
+	if c.Password != "local" {
+		t.Errorf("password = %q, want the accepted one", c.Password)
+	}
+
+	// When all are rejected the connection keeps its credentials
+	tried = nil
+	if err := c.tryCredentials(context.Background(), accept("none")); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("tryCredentials() error = %v, want an authentication failure", err)
+	}
+	if len(tried) != 3 || c.Password != "local" {
+		t.Errorf("tried %q, password %q", tried, c.Password)
+	}
+
+	// Other failures are not retried with the next credentials
+	tried = nil
+	network := errors.New("connection refused")
+	err = c.tryCredentials(context.Background(), func() error {
+		tried = append(tried, c.Username)
+		return network
+	})
+	if err != network || len(tried) != 1 {
+		t.Errorf("tryCredentials() = %v after %d tries, want the network error after one", err, len(tried))
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	scp "github.com/bramvdbogaerde/go-scp"
-	"github.com/bramvdbogaerde/go-scp/auth"
 	"github.com/pkg/sftp"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
@@ -20,26 +19,127 @@ import (
 type DeviceConnection struct {
 	Connection *SSHConnModel
 	Return     string
+
+	xterm        bool
+	reconnecting bool
+	errorPattern *regexp.Regexp                  // error lines of the device CLI, see CommandError
+	prepare      func(ctx context.Context) error // re-run by Reconnect: prompt discovery and session preparation
+}
+
+// ContextError is returned when the context of an operation is cancelled or expires.
+// It wraps ctx.Err() and keeps the output received before the operation was aborted.
+type ContextError struct {
+	Op     string
+	Output string
+	Err    error
+}
+
+func (e *ContextError) Error() string {
+	return fmt.Sprintf("%s aborted: %v", e.Op, e.Err)
+}
+
+func (e *ContextError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when the device does not produce the awaited pattern in time.
+// Unlike a ContextError the session stays usable. It wraps context.DeadlineExceeded.
+type TimeoutError struct {
+	Op      string
+	Pattern string // the pattern waited on
+	Output  string // output received before the timeout
+	Elapsed time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: timeout after %s, pattern not found: %s", e.Op, e.Elapsed.Round(time.Millisecond), e.Pattern)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Timeout reports true, like the timeout errors of the net package.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// contextError tears down the interactive channel and builds the error returned to the caller.
+func (d *DeviceConnection) contextError(ctx context.Context, op, output string) error {
+	d.Connection.abort(ctx.Err())
+	err := &ContextError{Op: op, Output: output, Err: ctx.Err()}
+	log.Error(err)
+	return err
 }
 
 func (d *DeviceConnection) Connect() error {
-	err := d.Connection.Connect()
+	return d.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect but aborts when ctx is done.
+func (d *DeviceConnection) ConnectContext(ctx context.Context) error {
+	d.xterm = false
+	err := d.Connection.ConnectContext(ctx)
 	if err != nil {
 		log.Errorf("Failed to connect: %v", err)
 		return err
 	}
 	log.Info("Connected successfully")
-	return nil
+	return d.attachConsole(ctx)
 }
 
 func (d *DeviceConnection) ConnectXterm() error {
-	err := d.Connection.ConnectXterm()
+	return d.ConnectXtermContext(context.Background())
+}
+
+// ConnectXtermContext is like ConnectXterm but aborts when ctx is done.
+func (d *DeviceConnection) ConnectXtermContext(ctx context.Context) error {
+	d.xterm = true
+	err := d.Connection.ConnectXtermContext(ctx)
 	if err != nil {
 		log.Errorf("Failed to connect via Xterm: %v", err)
 		return err
 	}
 	log.Info("Connected via Xterm successfully")
-	return nil
+	return d.attachConsole(ctx)
+}
+
+// attachConsole brings a console line to the CLI after connecting, see WithConsole.
+func (d *DeviceConnection) attachConsole(ctx context.Context) error {
+	if !d.Connection.Console {
+		return nil
+	}
+	_, err := d.AttachConsoleContext(ctx)
+	return err
+}
+
+// OpenChannel opens an extra shell channel over the device SSH client, connected the same
+// way as d. The returned DeviceConnection keeps its own output and can run commands while
+// d is busy.
+func (d *DeviceConnection) OpenChannel() (*DeviceConnection, error) {
+	return d.OpenChannelContext(context.Background())
+}
+
+// OpenChannelContext is like OpenChannel but aborts when ctx is done.
+func (d *DeviceConnection) OpenChannelContext(ctx context.Context) (*DeviceConnection, error) {
+	if d.Connection == nil {
+		return nil, errors.New("not connected to device, make sure to call .Connect() first")
+	}
+	channel, err := d.Connection.NewChannel()
+	if err != nil {
+		return nil, err
+	}
+	dc := &DeviceConnection{Connection: channel, Return: d.Return, errorPattern: d.errorPattern}
+	if d.xterm {
+		err = dc.ConnectXtermContext(ctx)
+	} else {
+		err = dc.ConnectContext(ctx)
+	}
+	if err != nil {
+		channel.Disconnect()
+		return nil, err
+	}
+	return dc, nil
 }
 
 func (d *DeviceConnection) Disconnect() {
@@ -61,10 +161,20 @@ func (d *DeviceConnection) SetTimeout(timeout uint8) {
 }
 
 func (d *DeviceConnection) SendCommand(cmd string) (string, error) {
-	return d.SendCommandPattern(cmd, d.Return)
+	return d.SendCommandContext(context.Background(), cmd)
+}
+
+// SendCommandContext is like SendCommand but aborts when ctx is done.
+func (d *DeviceConnection) SendCommandContext(ctx context.Context, cmd string) (string, error) {
+	return d.SendCommandPatternContext(ctx, cmd, d.Return)
 }
 
 func (d *DeviceConnection) FindDevicePrompt(regex string, pattern string) (string, error) {
+	return d.FindDevicePromptContext(context.Background(), regex, pattern)
+}
+
+// FindDevicePromptContext is like FindDevicePrompt but aborts when ctx is done.
+func (d *DeviceConnection) FindDevicePromptContext(ctx context.Context, regex string, pattern string) (string, error) {
 	// Compile the regular expression and check for errors
 	r, err := regexp.Compile(regex)
 	if err != nil {
@@ -75,14 +185,21 @@ func (d *DeviceConnection) FindDevicePrompt(regex string, pattern string) (strin
 	// Read until the specified pattern or read the available output
 	var out string
 	if pattern != "" {
-		out, err = d.ReadUntil(pattern)
+		out, err = d.ReadUntilContext(ctx, pattern)
 		if err != nil {
 			log.Errorf("Failed to read until pattern '%s': %v", pattern, err)
+			var timeoutErr *TimeoutError
+			if errors.As(err, &timeoutErr) {
+				return "", fmt.Errorf("%w: %w", ErrPromptNotFound, err)
+			}
 			return "", err
 		}
 	} else {
-		out, err = d.Connection.Read()
+		out, err = d.Connection.ReadContext(ctx)
 		if err != nil {
+			if ctx.Err() != nil {
+				return "", d.contextError(ctx, "find device prompt", out)
+			}
 			log.Errorf("Failed to read from connection: %v", err)
 			return "", err
 		}
@@ -91,7 +208,7 @@ func (d *DeviceConnection) FindDevicePrompt(regex string, pattern string) (strin
 	// Match the prompt using the regular expression
 	if !r.MatchString(out) {
 		log.Errorf("Failed to find prompt, pattern: '%s', output: '%s'", pattern, out)
-		return "", errors.New("failed to find prompt, pattern: " + pattern + " , output: " + out)
+		return "", fmt.Errorf("%w: regex %s does not match output %q", ErrPromptNotFound, regex, out)
 	}
 
 	// Find and return the matched prompt
@@ -101,50 +218,93 @@ func (d *DeviceConnection) FindDevicePrompt(regex string, pattern string) (strin
 	}
 
 	log.Warnf("Prompt not found in output: '%s'", out)
-	return "", errors.New("prompt not found in output")
+	return "", fmt.Errorf("%w in output %q", ErrPromptNotFound, out)
 }
 
 func (d *DeviceConnection) ReadUntil(pattern string) (string, error) {
-	outputChan := make(chan string)
-	errorChan := make(chan error)
-
-	go func() {
-		buffChan := make(chan string)
-		go readRoutine(d, pattern, buffChan)
-		select {
-		case recv := <-buffChan:
-			outputChan <- recv
-		case <-time.After(4 * time.Second):
-			err := fmt.Errorf("timeout while reading, pattern not found: %s", pattern)
+	return d.ReadUntilContext(context.Background(), pattern)
+}
+
+// ReadUntilContext is like ReadUntil but aborts when ctx is done. The read timeout of the
+// connection still applies; on timeout the session stays usable.
+func (d *DeviceConnection) ReadUntilContext(ctx context.Context, pattern string) (string, error) {
+	result, err := d.expect(ctx, d.readTimeout(ctx), "read until "+pattern, pattern)
+	if err != nil {
+		var ctxErr *ContextError
+		if !errors.As(err, &ctxErr) {
 			log.Error(err)
-			errorChan <- err
 		}
-		close(outputChan)
-		close(errorChan)
-	}()
-
-	select {
-	case result := <-outputChan:
-		return result, nil
-	case err := <-errorChan:
 		return "", err
 	}
+	return result.Output(), nil
 }
 
 func (d *DeviceConnection) SendCommandPattern(cmd string, expectPattern string) (string, error) {
+	return d.SendCommandPatternContext(context.Background(), cmd, expectPattern)
+}
+
+// SendCommandPatternContext is like SendCommandPattern but aborts when ctx is done. When the
+// session is lost during the call the error wraps ErrSessionBroken; with a context from
+// ContextWithRetry the command is sent again after reconnecting instead.
+func (d *DeviceConnection) SendCommandPatternContext(ctx context.Context, cmd string, expectPattern string) (string, error) {
 	if d.Connection == nil {
 		err := errors.New("not connected to device, make sure to call .Connect() first")
 		log.Error(err)
 		return "", err
 	}
 
-	cmd += d.Return
-	d.Connection.Write(cmd)
+	if err := d.ensureConnected(ctx); err != nil {
+		return "", err
+	}
+
+	out, err := d.sendCommandPattern(ctx, cmd, expectPattern)
+	if err == nil || ctx.Err() != nil || !d.Connection.sessionLost() {
+		return out, err
+	}
+	if !d.canRetry(ctx) {
+		return out, fmt.Errorf("%w while sending '%s': %w", ErrSessionBroken, cmd, err)
+	}
+	log.Warnf("Session lost while sending '%s', reconnecting", cmd)
+	if err := d.ReconnectContext(ctx); err != nil {
+		return out, err
+	}
+	return d.sendCommandPattern(ctx, cmd, expectPattern)
+}
+
+func (d *DeviceConnection) sendCommandPattern(ctx context.Context, cmd string, expectPattern string) (string, error) {
+	if ctx.Err() != nil {
+		return "", d.contextError(ctx, "send command "+cmd, "")
+	}
+	d.syncOutput(ctx)
+	if _, err := d.Connection.WriteString(cmd + d.Return); err != nil {
+		return "", err
+	}
+
+	out, err := d.ReadUntilContext(ctx, expectPattern)
+	if err != nil {
+		return "", err
+	}
+	if err := d.commandError(literalPrompt(expectPattern), cmd, out); err != nil {
+		return "", err
+	}
+	return out, nil
+}
 
-	return d.ReadUntil(expectPattern)
+// literalPrompt returns pattern when it is a plain string, such as a discovered prompt, and
+// an empty string when it is a regular expression.
+func literalPrompt(pattern string) string {
+	if regexp.QuoteMeta(pattern) != pattern {
+		return ""
+	}
+	return pattern
 }
 
 func (d *DeviceConnection) SendCommandsSetPattern(cmds []string, expectPattern string) (string, error) {
+	return d.SendCommandsSetPatternContext(context.Background(), cmds, expectPattern)
+}
+
+// SendCommandsSetPatternContext is like SendCommandsSetPattern but aborts when ctx is done.
+func (d *DeviceConnection) SendCommandsSetPatternContext(ctx context.Context, cmds []string, expectPattern string) (string, error) {
 	if d.Connection == nil {
 		err := errors.New("not connected to device, make sure to call .Connect() first")
 		log.Error(err)
@@ -152,9 +312,13 @@ func (d *DeviceConnection) SendCommandsSetPattern(cmds []string, expectPattern s
 	}
 	var results string
 	for _, cmd := range cmds {
-		out, err := d.SendCommandPattern(cmd, expectPattern)
+		out, err := d.SendCommandPatternContext(ctx, cmd, expectPattern)
 		if err != nil {
 			log.Errorf("Error sending command '%s': %v", cmd, err)
+			var ctxErr *ContextError
+			if errors.As(err, &ctxErr) {
+				ctxErr.Output = results + ctxErr.Output
+			}
 			return "", err
 		}
 		results += out
@@ -162,36 +326,6 @@ func (d *DeviceConnection) SendCommandsSetPattern(cmds []string, expectPattern s
 	return results, nil
 }
 
-func readRoutine(d *DeviceConnection, pattern string, buffChan chan<- string) {
-	var result string
-	for {
-		outSlice, err := d.Connection.Read()
-		if err != nil {
-			log.Errorf("Error reading from connection: %v", err)
-			break
-		}
-
-		cleanString := func(str string) string {
-			re := regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
-			return re.ReplaceAllString(str, "")
-		}
-
-		outSlice = cleanString(outSlice)
-		result += outSlice
-
-		r, err := regexp.Compile(pattern)
-		if err != nil {
-			log.Errorf("Failed to compile regex pattern '%s': %v", pattern, err)
-			break
-		}
-
-		if r.MatchString(result) {
-			break
-		}
-	}
-	buffChan <- result
-}
-
 // NewSFTPClient creates a new SFTP client using the existing SSH connection.
 func (d *DeviceConnection) NewSFTPClient() (*sftp.Client, error) {
 	if d.Connection == nil || d.Connection.Client == nil {
@@ -210,19 +344,30 @@ func (d *DeviceConnection) NewSFTPClient() (*sftp.Client, error) {
 
 // RetrieveFile downloads a file from the remote device using SFTP.
 func (d *DeviceConnection) RetrieveFile(remoteFile, localFile string) error {
+	return d.RetrieveFileContext(context.Background(), remoteFile, localFile)
+}
+
+// RetrieveFileContext is like RetrieveFile but aborts when ctx is done.
+func (d *DeviceConnection) RetrieveFileContext(ctx context.Context, remoteFile, localFile string) error {
+	if ctx.Err() != nil {
+		return &ContextError{Op: "retrieve file", Err: ctx.Err()}
+	}
+
 	// Establish SFTP session
 	sftpClient, err := d.NewSFTPClient()
 	if err != nil {
 		log.Infof("Failed to establish SFTP session. Fallback to SFTP with io.ReadAll method..")
-		return d.RetrieveFileReadAll(remoteFile, localFile)
+		return d.RetrieveFileReadAllContext(ctx, remoteFile, localFile)
 	}
 	defer sftpClient.Close()
+	stop := context.AfterFunc(ctx, func() { sftpClient.Close() })
+	defer stop()
 
 	// Open the remote file
 	remoteFileReader, err := sftpClient.Open(remoteFile)
 	if err != nil {
 		log.Errorf("Failed to open remote file '%s': %v", remoteFile, err)
-		return fmt.Errorf("failed to open remote file: %v", err)
+		return transferError("retrieve file", localFile, remoteFile, fmt.Errorf("failed to open remote file: %w", err))
 	}
 	defer remoteFileReader.Close()
 
@@ -231,7 +376,7 @@ func (d *DeviceConnection) RetrieveFile(remoteFile, localFile string) error {
 	if err != nil {
 		log.Infof("Failed to create local file '%s': %v", localFile, err)
 		log.Infof("Fallback to SFTP with io.ReadAll method..")
-		return d.RetrieveFileReadAll(remoteFile, localFile)
+		return d.RetrieveFileReadAllContext(ctx, remoteFile, localFile)
 	}
 	defer localFileWriter.Close()
 
@@ -239,7 +384,7 @@ func (d *DeviceConnection) RetrieveFile(remoteFile, localFile string) error {
 	if _, err := io.Copy(localFileWriter, remoteFileReader); err != nil {
 		log.Errorf("Failed to copy file from '%s' to '%s': %v", remoteFile, localFile, err)
 		log.Infof("Fallback to SFTP with io.ReadAll method..")
-		return d.RetrieveFileReadAll(remoteFile, localFile)
+		return d.RetrieveFileReadAllContext(ctx, remoteFile, localFile)
 	}
 
 	log.Infof("File retrieved successfully from '%s' to '%s'", remoteFile, localFile)
@@ -248,19 +393,30 @@ func (d *DeviceConnection) RetrieveFile(remoteFile, localFile string) error {
 
 // RetrieveFile downloads a file from the remote device using SFTP ReadAll.
 func (d *DeviceConnection) RetrieveFileReadAll(remoteFile, localFile string) error {
+	return d.RetrieveFileReadAllContext(context.Background(), remoteFile, localFile)
+}
+
+// RetrieveFileReadAllContext is like RetrieveFileReadAll but aborts when ctx is done.
+func (d *DeviceConnection) RetrieveFileReadAllContext(ctx context.Context, remoteFile, localFile string) error {
+	if ctx.Err() != nil {
+		return &ContextError{Op: "retrieve file", Err: ctx.Err()}
+	}
+
 	// Establish SFTP session
 	sftpClient, err := d.NewSFTPClient()
 	if err != nil {
 		log.Infof("Failed to establish SFTP session. Fallback to SCP..")
-		return d.RetrieveFileUsingSCP(remoteFile, localFile)
+		return d.RetrieveFileUsingSCPContext(ctx, remoteFile, localFile)
 	}
 	defer sftpClient.Close()
+	stop := context.AfterFunc(ctx, func() { sftpClient.Close() })
+	defer stop()
 
 	// Open the remote file
 	remoteFileReader, err := sftpClient.Open(remoteFile)
 	if err != nil {
 		log.Errorf("Failed to open remote file '%s': %v", remoteFile, err)
-		return fmt.Errorf("failed to open remote file: %v", err)
+		return transferError("retrieve file", localFile, remoteFile, fmt.Errorf("failed to open remote file: %w", err))
 	}
 	defer remoteFileReader.Close()
 
@@ -268,12 +424,14 @@ func (d *DeviceConnection) RetrieveFileReadAll(remoteFile, localFile string) err
 	data, err := io.ReadAll(remoteFileReader)
 	if err != nil {
 		log.Errorf("Failed to read remote file: %v", err)
+		return transferError("retrieve file", localFile, remoteFile, fmt.Errorf("failed to read remote file: %w", err))
 	}
 
 	// Create the local file
 	err = os.WriteFile(localFile, data, 0644)
 	if err != nil {
 		log.Errorf("Failed to write to local file: %v", err)
+		return transferError("retrieve file", localFile, remoteFile, fmt.Errorf("failed to write local file: %w", err))
 	}
 	log.Infof("File retrieved successfully using SFTP ReadAll method from '%s' to '%s'", remoteFile, localFile)
 	return nil
@@ -281,37 +439,36 @@ func (d *DeviceConnection) RetrieveFileReadAll(remoteFile, localFile string) err
 
 // RetrieveFileUsingSCP downloads a file from the remote device using SCP.
 func (d *DeviceConnection) RetrieveFileUsingSCP(remoteFile, localFile string) error {
-	// Create SSH client configuration
-	sshConfig, err := auth.PasswordKey(d.Connection.Username, d.Connection.Password, ssh.InsecureIgnoreHostKey())
-	if err != nil {
-		log.Errorf("Failed to create SSH config: %v", err)
-		return fmt.Errorf("failed to create SSH config: %v", err)
-	}
+	return d.RetrieveFileUsingSCPContext(context.Background(), remoteFile, localFile)
+}
 
-	// Create SCP client
-	client := scp.NewClient(d.Connection.Addr, &sshConfig)
+// RetrieveFileUsingSCPContext is like RetrieveFileUsingSCP but aborts when ctx is done.
+func (d *DeviceConnection) RetrieveFileUsingSCPContext(ctx context.Context, remoteFile, localFile string) error {
+	if ctx.Err() != nil {
+		return &ContextError{Op: "retrieve file via SCP", Err: ctx.Err()}
+	}
 
-	// Connect to the remote server
-	err = client.Connect()
+	// Create SCP client over the device SSH client
+	client, closeClient, err := d.newSCPClient(ctx)
 	if err != nil {
 		log.Errorf("Failed to connect via SCP: %v", err)
-		return fmt.Errorf("failed to connect via SCP: %v", err)
+		return transferError("retrieve file via SCP", localFile, remoteFile, fmt.Errorf("failed to connect via SCP: %w", err))
 	}
-	defer client.Close()
+	defer closeClient()
 
 	// Open the local file for writing
 	localFileWriter, err := os.Create(localFile)
 	if err != nil {
 		log.Errorf("Failed to create local file '%s': %v", localFile, err)
-		return fmt.Errorf("failed to create local file: %v", err)
+		return transferError("retrieve file via SCP", localFile, remoteFile, fmt.Errorf("failed to create local file: %w", err))
 	}
 	defer localFileWriter.Close()
 
 	// Copy the remote file to the local file
-	err = client.CopyFromRemote(context.Background(), localFileWriter, remoteFile)
+	err = client.CopyFromRemote(ctx, localFileWriter, remoteFile)
 	if err != nil {
 		log.Errorf("Failed to copy file via SCP from '%s' to '%s': %v", remoteFile, localFile, err)
-		return fmt.Errorf("failed to copy file via SCP: %v", err)
+		return transferError("retrieve file via SCP", localFile, remoteFile, err)
 	}
 
 	log.Infof("File retrieved successfully via SCP from '%s' to '%s'", remoteFile, localFile)
@@ -320,20 +477,31 @@ func (d *DeviceConnection) RetrieveFileUsingSCP(remoteFile, localFile string) er
 
 // FileTransfer transfers a file to the remote device using SFTP.
 func (d *DeviceConnection) FileTransfer(localFile, remoteFile string) error {
+	return d.FileTransferContext(context.Background(), localFile, remoteFile)
+}
+
+// FileTransferContext is like FileTransfer but aborts when ctx is done.
+func (d *DeviceConnection) FileTransferContext(ctx context.Context, localFile, remoteFile string) error {
+	if ctx.Err() != nil {
+		return &ContextError{Op: "file transfer", Err: ctx.Err()}
+	}
+
 	// Establish SFTP session
 	sftpClient, err := d.NewSFTPClient()
 	if err != nil {
 		log.Infof("Failed to establish SFTP session. Fallback to SCP..")
-		return d.FileTransferUsingSCP(localFile, remoteFile)
+		return d.FileTransferUsingSCPContext(ctx, localFile, remoteFile)
 	}
 	defer sftpClient.Close()
+	stop := context.AfterFunc(ctx, func() { sftpClient.Close() })
+	defer stop()
 
 	// Open the local file
 	localFileReader, err := os.Open(localFile)
 	if err != nil {
 		log.Errorf("Failed to open local file '%s': %v", localFile, err)
 		log.Infof("Fallback to SCP method..")
-		return d.FileTransferUsingSCP(remoteFile, localFile)
+		return d.FileTransferUsingSCPContext(ctx, localFile, remoteFile)
 	}
 	defer localFileReader.Close()
 
@@ -342,7 +510,7 @@ func (d *DeviceConnection) FileTransfer(localFile, remoteFile string) error {
 	if err != nil {
 		log.Errorf("Failed to create remote file '%s': %v", remoteFile, err)
 		log.Infof("Fallback to SCP..")
-		return d.FileTransferUsingSCP(remoteFile, localFile)
+		return d.FileTransferUsingSCPContext(ctx, localFile, remoteFile)
 	}
 	defer remoteFileWriter.Close()
 
@@ -350,7 +518,7 @@ func (d *DeviceConnection) FileTransfer(localFile, remoteFile string) error {
 	if _, err := io.Copy(remoteFileWriter, localFileReader); err != nil {
 		log.Errorf("Failed to copy file from '%s' to '%s': %v", localFile, remoteFile, err)
 		log.Infof("Fallback to SCP..")
-		return d.FileTransferUsingSCP(remoteFile, localFile)
+		return d.FileTransferUsingSCPContext(ctx, localFile, remoteFile)
 	}
 
 	log.Infof("File transferred successfully using SFTP from '%s' to '%s'", localFile, remoteFile)
@@ -359,23 +527,32 @@ func (d *DeviceConnection) FileTransfer(localFile, remoteFile string) error {
 
 // FileTransfer transfers a file to the remote device using SFTP ReadAll.
 func (d *DeviceConnection) FileTransferReadAll(localFile, remoteFile string) error {
-	// Establish SFTP session
-	sftpClient, err := d.NewSFTPClient()
+	return d.FileTransferReadAllContext(context.Background(), localFile, remoteFile)
+}
 
-	log.Info("sftpClient create succesfully")
+// FileTransferReadAllContext is like FileTransferReadAll but aborts when ctx is done.
+func (d *DeviceConnection) FileTransferReadAllContext(ctx context.Context, localFile, remoteFile string) error {
+	if ctx.Err() != nil {
+		return &ContextError{Op: "file transfer", Err: ctx.Err()}
+	}
 
+	// Establish SFTP session
+	sftpClient, err := d.NewSFTPClient()
 	if err != nil {
 		log.Infof("Failed to establish SFTP session. Fallback to SCP..")
-		return d.FileTransferUsingSCP(localFile, remoteFile)
+		return d.FileTransferUsingSCPContext(ctx, localFile, remoteFile)
 	}
+	log.Info("sftpClient created successfully")
 	defer sftpClient.Close()
+	stop := context.AfterFunc(ctx, func() { sftpClient.Close() })
+	defer stop()
 
 	// Open the local file
 	localFileReader, err := os.Open(localFile)
 	if err != nil {
 		log.Errorf("Failed to open local file '%s': %v", localFile, err)
 		log.Infof("Fallback to SCP method..")
-		return d.FileTransferUsingSCP(localFile, remoteFile)
+		return d.FileTransferUsingSCPContext(ctx, localFile, remoteFile)
 	}
 	defer localFileReader.Close()
 
@@ -384,7 +561,7 @@ func (d *DeviceConnection) FileTransferReadAll(localFile, remoteFile string) err
 	if err != nil {
 		log.Errorf("Failed to read local file '%s': %v", localFile, err)
 		log.Infof("Fallback to SCP method..")
-		return d.FileTransferUsingSCP(localFile, remoteFile)
+		return d.FileTransferUsingSCPContext(ctx, localFile, remoteFile)
 	}
 
 	// Create the remote file
@@ -392,7 +569,7 @@ func (d *DeviceConnection) FileTransferReadAll(localFile, remoteFile string) err
 	if err != nil {
 		log.Errorf("Failed to create remote file '%s': %v", remoteFile, err)
 		log.Infof("Fallback to SCP..")
-		return d.FileTransferUsingSCP(localFile, remoteFile)
+		return d.FileTransferUsingSCPContext(ctx, localFile, remoteFile)
 	}
 	defer remoteFileWriter.Close()
 
@@ -400,46 +577,88 @@ func (d *DeviceConnection) FileTransferReadAll(localFile, remoteFile string) err
 	if _, err := remoteFileWriter.Write(localFileContent); err != nil {
 		log.Errorf("Failed to write to remote file '%s': %v", remoteFile, err)
 		log.Infof("Fallback to SCP..")
-		return d.FileTransferUsingSCP(localFile, remoteFile)
+		return d.FileTransferUsingSCPContext(ctx, localFile, remoteFile)
 	}
 
 	log.Infof("File transferred successfully using SFTP from '%s' to '%s'", localFile, remoteFile)
 	return err
 }
 
-// TransferFileUsingSCP uploads a file to the remote device using SCP.
-func (d *DeviceConnection) FileTransferUsingSCP(localFile, remoteFile string) error {
-	// Create SSH client configuration
-	sshConfig, err := auth.PasswordKey(d.Connection.Username, d.Connection.Password, ssh.InsecureIgnoreHostKey())
+// sshClient returns the device SSH client, so that extra channels follow the same jump
+// hosts as the shell session. When the device is not connected a dedicated client is
+// dialled and closed by the returned function.
+func (d *DeviceConnection) sshClient(ctx context.Context) (*ssh.Client, func(), error) {
+	if d.Connection == nil {
+		return nil, nil, errors.New("SSH connection is not established")
+	}
+	if d.Connection.Protocol == "telnet" {
+		return nil, nil, errors.New("operation is not supported over telnet")
+	}
+
+	if d.Connection.Client != nil {
+		return d.Connection.Client, func() {}, nil
+	}
+	client, err := d.Connection.DialContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, func() {
+		client.Close()
+		d.Connection.closeJumpHosts()
+	}, nil
+}
+
+// newSCPClient returns an SCP client running over the device SSH client. The returned
+// function releases the SSH client when it was dialled for the transfer.
+func (d *DeviceConnection) newSCPClient(ctx context.Context) (scp.Client, func(), error) {
+	if d.Connection != nil && d.Connection.Protocol == "telnet" {
+		return scp.Client{}, nil, errors.New("file transfer is not supported over telnet")
+	}
+	sshClient, closeClient, err := d.sshClient(ctx)
+	if err != nil {
+		return scp.Client{}, nil, err
+	}
+
+	client, err := scp.NewClientBySSH(sshClient)
 	if err != nil {
-		log.Errorf("Failed to create SSH config: %v", err)
-		return fmt.Errorf("failed to create SSH config: %v", err)
+		closeClient()
+		return scp.Client{}, nil, err
 	}
+	return client, closeClient, nil
+}
+
+// TransferFileUsingSCP uploads a file to the remote device using SCP.
+func (d *DeviceConnection) FileTransferUsingSCP(localFile, remoteFile string) error {
+	return d.FileTransferUsingSCPContext(context.Background(), localFile, remoteFile)
+}
 
-	// Create SCP client
-	client := scp.NewClient(d.Connection.Addr, &sshConfig)
+// FileTransferUsingSCPContext is like FileTransferUsingSCP but aborts when ctx is done.
+func (d *DeviceConnection) FileTransferUsingSCPContext(ctx context.Context, localFile, remoteFile string) error {
+	if ctx.Err() != nil {
+		return &ContextError{Op: "file transfer via SCP", Err: ctx.Err()}
+	}
 
-	// Connect to the remote server
-	err = client.Connect()
+	// Create SCP client over the device SSH client
+	client, closeClient, err := d.newSCPClient(ctx)
 	if err != nil {
 		log.Errorf("Failed to connect via SCP: %v", err)
-		return fmt.Errorf("failed to connect via SCP: %v", err)
+		return transferError("file transfer via SCP", localFile, remoteFile, fmt.Errorf("failed to connect via SCP: %w", err))
 	}
-	defer client.Close()
+	defer closeClient()
 
 	// Open the local file for reading
 	localFileReader, err := os.Open(localFile)
 	if err != nil {
 		log.Errorf("Failed to open local file '%s': %v", localFile, err)
-		return fmt.Errorf("failed to open local file: %v", err)
+		return transferError("file transfer via SCP", localFile, remoteFile, fmt.Errorf("failed to open local file: %w", err))
 	}
 	defer localFileReader.Close()
 
 	// Copy the local file to the remote file
-	err = client.CopyFromFile(context.Background(), *localFileReader, remoteFile, "0655")
+	err = client.CopyFromFile(ctx, *localFileReader, remoteFile, "0655")
 	if err != nil {
 		log.Errorf("Failed to copy file via SCP from '%s' to '%s': %v", localFile, remoteFile, err)
-		return fmt.Errorf("failed to copy file via SCP: %v", err)
+		return transferError("file transfer via SCP", localFile, remoteFile, err)
 	}
 
 	log.Infof("File transferred successfully via SCP from '%s' to '%s'", localFile, remoteFile)
@@ -0,0 +1,218 @@
+package netmigo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Failure classes for errors.Is. The error types of the package match the class they belong
+// to, so callers can branch on the class and still errors.As the details.
+var (
+	ErrAuthFailed      = errors.New("authentication failed")
+	ErrHostKeyMismatch = errors.New("host key mismatch")
+	ErrPromptNotFound  = errors.New("prompt not found")
+	ErrCommandRejected = errors.New("command rejected by device")
+	ErrCommitFailed    = errors.New("commit failed")
+	ErrTransferFailed  = errors.New("file transfer failed")
+	ErrSessionBroken   = errors.New("session to device is broken")
+)
+
+// AuthError is returned when the device, or a jump host, rejects the login.
+type AuthError struct {
+	Host     string
+	Username string
+	Err      error
+}
+
+func (e *AuthError) Error() string {
+	message := fmt.Sprintf("authentication to %s as %s failed", e.Host, e.Username)
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+func (e *AuthError) Is(target error) bool {
+	return target == ErrAuthFailed
+}
+
+// authError turns an SSH handshake error that rejected the credentials into an AuthError.
+// The SSH package does not type that error, so it is recognised by its message.
+func authError(host, username string, err error) error {
+	if err == nil || !strings.Contains(err.Error(), "unable to authenticate") {
+		return err
+	}
+	return &AuthError{Host: host, Username: username, Err: err}
+}
+
+// CommitError is returned when the device does not commit a configuration. Output holds
+// what the device answered, Message the error line it printed, if any; Err the underlying
+// failure, if any.
+type CommitError struct {
+	Output  string
+	Message string
+	Err     error
+}
+
+func (e *CommitError) Error() string {
+	if e.Err != nil {
+		return "commit failed: " + e.Err.Error()
+	}
+	if e.Message != "" {
+		return "commit failed: " + e.Message
+	}
+	if line := lastLine(e.Output); line != "" {
+		return "commit failed: " + line
+	}
+	return "commit failed"
+}
+
+func (e *CommitError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CommitError) Is(target error) bool {
+	return target == ErrCommitFailed
+}
+
+// commitError wraps err of a commit in a CommitError, leaving context and commit errors as
+// they are.
+func commitError(output string, err error) error {
+	var ctxErr *ContextError
+	var commitErr *CommitError
+	if err == nil || errors.As(err, &ctxErr) || errors.As(err, &commitErr) {
+		return err
+	}
+	return &CommitError{Output: output, Err: err}
+}
+
+// commitFailure returns a CommitError when output contains a match of pattern, the commit
+// failure message of the device.
+func commitFailure(pattern *regexp.Regexp, output string) error {
+	if pattern == nil {
+		return nil
+	}
+	message := pattern.FindString(output)
+	if message == "" {
+		return nil
+	}
+	err := &CommitError{Output: output, Message: strings.TrimSpace(message)}
+	log.Warn(err)
+	return err
+}
+
+// CommandError is returned when the device rejects a command with an error message.
+// Column is the 1-based position in Command of the marker the device printed under the
+// echoed command, or zero when it printed none.
+type CommandError struct {
+	Command string
+	Message string
+	Column  int
+	Output  string
+}
+
+func (e *CommandError) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("command %q rejected at column %d: %s", e.Command, e.Column, e.Message)
+	}
+	return fmt.Sprintf("command %q rejected: %s", e.Command, e.Message)
+}
+
+func (e *CommandError) Is(target error) bool {
+	return target == ErrCommandRejected
+}
+
+// commandError returns a CommandError when output contains an error line of the device,
+// see the errorPattern of the drivers. prompt is the device prompt, if known, which locates
+// the marker when the echo of the command does not repeat it.
+func (d *DeviceConnection) commandError(prompt, command, output string) error {
+	if d.errorPattern == nil {
+		return nil
+	}
+	loc := d.errorPattern.FindStringIndex(output)
+	if loc == nil {
+		return nil
+	}
+	err := &CommandError{
+		Command: command,
+		Message: strings.TrimSpace(output[loc[0]:loc[1]]),
+		Column:  markerColumn(prompt, command, output[:loc[0]]),
+		Output:  output,
+	}
+	log.Warn(err)
+	return err
+}
+
+// markerColumn finds the "^" marker line closest to the error and the echoed command above
+// it, and returns the column of the marker within the command. The marker is aligned with
+// the prompt and command the device printed, so when the echo in output starts with the
+// command, the prompt is assumed before it.
+func markerColumn(prompt, command, output string) int {
+	lines := strings.Split(strings.ReplaceAll(output, "\r", ""), "\n")
+	for i := len(lines) - 1; i > 0; i-- {
+		marker := strings.TrimRight(lines[i], " ")
+		if marker == "" {
+			continue
+		}
+		if strings.Trim(marker, " ^") != "" {
+			return 0
+		}
+		for j := i - 1; j >= 0; j-- {
+			if start := strings.LastIndex(lines[j], command); start >= 0 && command != "" {
+				if start == 0 {
+					start = len(prompt)
+				}
+				column := strings.Index(marker, "^") - start + 1
+				if column < 1 || column > len(command)+1 {
+					return 0
+				}
+				return column
+			}
+		}
+		return 0
+	}
+	return 0
+}
+
+// TransferError is returned when a file cannot be copied to or from the device.
+type TransferError struct {
+	Op     string
+	Local  string
+	Remote string
+	Err    error
+}
+
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("%s failed, local %s, remote %s: %v", e.Op, e.Local, e.Remote, e.Err)
+}
+
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransferError) Is(target error) bool {
+	return target == ErrTransferFailed
+}
+
+// transferError wraps err in a TransferError, leaving context errors as they are.
+func transferError(op, localFile, remoteFile string, err error) error {
+	var ctxErr *ContextError
+	if err == nil || errors.As(err, &ctxErr) {
+		return err
+	}
+	return &TransferError{Op: op, Local: localFile, Remote: remoteFile, Err: err}
+}
+
+// lastLine returns the last non-empty line of output, trimmed.
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,310 @@
+package netmigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// under returns a marker line with "^" at index i.
+func under(i int) string {
+	return strings.Repeat(" ", i) + "^"
+}
+
+func TestMarkerColumn(t *testing.T) {
+	const prompt = "RP/0/RP0/CPU0:R1#"
+	const command = "show interfacs brief"
+	tests := []struct {
+		name   string
+		output string
+		want   int
+	}{
+		{
+			name:   "echo without prompt",
+			output: command + "\r\n" + under(len(prompt)+5) + "\r\n",
+			want:   6,
+		},
+		{
+			name:   "echo with prompt",
+			output: prompt + command + "\r\n" + under(len(prompt)+5) + "\r\n",
+			want:   6,
+		},
+		{
+			name:   "marker after the last character",
+			output: prompt + command + "\r\n" + under(len(prompt)+len(command)) + "\r\n",
+			want:   len(command) + 1,
+		},
+		{
+			name:   "no marker",
+			output: prompt + command + "\r\n",
+		},
+		{
+			name:   "marker past the command",
+			output: prompt + command + "\r\n" + under(len(prompt)+len(command)+5) + "\r\n",
+		},
+		{
+			name:   "marker under the prompt",
+			output: prompt + command + "\r\n" + under(3) + "\r\n",
+		},
+		{
+			name:   "no echo",
+			output: "some output\r\n" + under(5) + "\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := markerColumn(prompt, command, tt.output); got != tt.want {
+				t.Errorf("markerColumn() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     *regexp.Regexp
+		prompt      string
+		command     string
+		output      string
+		wantMessage string
+		wantColumn  int
+	}{
+		{
+			name:        "iosxr invalid input",
+			pattern:     iosxrErrorPattern,
+			prompt:      "RP/0/RP0/CPU0:R1#",
+			command:     "show interfacs brief",
+			output:      "show interfacs brief\r\n" + under(22) + "\r\n% Invalid input detected at '^' marker.\r\nRP/0/RP0/CPU0:R1#",
+			wantMessage: "% Invalid input detected at '^' marker.",
+			wantColumn:  6,
+		},
+		{
+			name:        "iosxr incomplete command",
+			pattern:     iosxrErrorPattern,
+			prompt:      "RP/0/RP0/CPU0:R1#",
+			command:     "show",
+			output:      "show\r\n% Incomplete command.\r\nRP/0/RP0/CPU0:R1#",
+			wantMessage: "% Incomplete command.",
+		},
+		{
+			name:        "junos syntax error",
+			pattern:     junosErrorPattern,
+			prompt:      "admin@r1> ",
+			command:     "show interfaces trse",
+			output:      "show interfaces trse | no-more\r\n" + under(26) + "\r\nsyntax error, expecting <command>.\r\n",
+			wantMessage: "syntax error, expecting <command>.",
+			wantColumn:  17,
+		},
+		{
+			name:        "sros md-cli",
+			pattern:     srosErrorPattern,
+			prompt:      "A:admin@r1# ",
+			command:     "show routr",
+			output:      "A:admin@r1# show routr\n" + under(17) + "\nMINOR: CLI #2069: Operation failed - unknown element 'routr'\n",
+			wantMessage: "MINOR: CLI #2069: Operation failed - unknown element 'routr'",
+			wantColumn:  6,
+		},
+		{
+			name:        "srl",
+			pattern:     srlErrorPattern,
+			prompt:      "A:srl1# ",
+			command:     "show versio",
+			output:      "show versio\r\nParsing error: Unknown token 'versio'. Options are ['version']\r\n",
+			wantMessage: "",
+		},
+		{
+			name:        "srl error line",
+			pattern:     srlErrorPattern,
+			prompt:      "A:srl1# ",
+			command:     "info from state foo",
+			output:      "info from state foo\r\nError: Path '/foo' is not valid\r\n",
+			wantMessage: "Error: Path '/foo' is not valid",
+		},
+		{
+			name:    "no error",
+			pattern: iosxrErrorPattern,
+			prompt:  "RP/0/RP0/CPU0:R1#",
+			command: "show clock",
+			output:  "show clock\r\n10:00:00.000 UTC Mon Jan 1 2024\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DeviceConnection{errorPattern: tt.pattern}
+			err := d.commandError(tt.prompt, tt.command, tt.output)
+			if tt.wantMessage == "" {
+				if err != nil {
+					t.Errorf("commandError() = %v, want nil", err)
+				}
+				return
+			}
+			var cmdErr *CommandError
+			if !errors.As(err, &cmdErr) || !errors.Is(err, ErrCommandRejected) {
+				t.Fatalf("commandError() = %v, want a CommandError", err)
+			}
+			if cmdErr.Message != tt.wantMessage || cmdErr.Column != tt.wantColumn || cmdErr.Command != tt.command {
+				t.Errorf("commandError() = %+v, want message %q at column %d", cmdErr, tt.wantMessage, tt.wantColumn)
+			}
+		})
+	}
+}
+
+func TestCommitFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     *regexp.Regexp
+		output      string
+		wantMessage string
+	}{
+		{
+			name:        "iosxr rejected",
+			pattern:     iosxrCommitFailurePattern,
+			output:      "commit\r\n\r\n% Failed to commit one or more configuration items during a pseudo-atomic operation. All changes made have been reverted. Please issue 'show configuration failed [inheritance]' from this session to view the errors\r\nRP/0/RP0/CPU0:R1(config)#",
+			wantMessage: "% Failed to commit one or more configuration items during a pseudo-atomic operation. All changes made have been reverted. Please issue 'show configuration failed [inheritance]' from this session to view the errors",
+		},
+		{
+			name:    "iosxr committed",
+			pattern: iosxrCommitFailurePattern,
+			output:  "commit\r\nMon Jan  1 10:00:00.000 UTC\r\nRP/0/RP0/CPU0:R1(config)#",
+		},
+		{
+			name:        "junos check-out failed",
+			pattern:     junosCommitFailurePattern,
+			output:      "commit and-quit\r\n[edit interfaces ge-0/0/0 unit 0 family inet]\r\n  'address 10.0.0.1/24'\r\n    Overlapping subnet is configured\r\nerror: configuration check-out failed\r\n\r\n[edit]\r\nadmin@r1# ",
+			wantMessage: "error: configuration check-out failed",
+		},
+		{
+			name:    "junos warning only",
+			pattern: junosCommitFailurePattern,
+			output:  "commit and-quit\r\nwarning: statement has no contents; ignored\r\ncommit complete\r\nExiting configuration mode\r\n",
+		},
+		{
+			name:        "sros validation failed",
+			pattern:     srosCommitFailurePattern,
+			output:      "commit\nMINOR: MGMT_CORE #2201: configure router \"Base\" interface \"to-r2\" - Inconsistent Value error - port must be configured\n\n[ex:/configure]\nA:admin@r1# ",
+			wantMessage: "MINOR: MGMT_CORE #2201: configure router \"Base\" interface \"to-r2\" - Inconsistent Value error - port must be configured",
+		},
+		{
+			name:    "sros committed",
+			pattern: srosCommitFailurePattern,
+			output:  "commit\n\n[ex:/configure]\nA:admin@r1# ",
+		},
+		{
+			name:   "no pattern",
+			output: "% Failed to commit",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := commitFailure(tt.pattern, tt.output)
+			if tt.wantMessage == "" {
+				if err != nil {
+					t.Errorf("commitFailure() = %v, want nil", err)
+				}
+				return
+			}
+			var commitErr *CommitError
+			if !errors.As(err, &commitErr) || !errors.Is(err, ErrCommitFailed) {
+				t.Fatalf("commitFailure() = %v, want a CommitError", err)
+			}
+			if commitErr.Message != tt.wantMessage || commitErr.Output != tt.output {
+				t.Errorf("commitFailure() message = %q, want %q", commitErr.Message, tt.wantMessage)
+			}
+			if err.Error() != "commit failed: "+tt.wantMessage {
+				t.Errorf("Error() = %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	cause := errors.New("cause")
+	tests := []struct {
+		name  string
+		err   error
+		class error
+		cause error
+	}{
+		{name: "auth", err: &AuthError{Host: "r1:22", Username: "admin", Err: cause}, class: ErrAuthFailed, cause: cause},
+		{name: "host key", err: &HostKeyMismatchError{Host: "r1:22"}, class: ErrHostKeyMismatch},
+		{name: "command", err: &CommandError{Command: "show foo", Message: "% Invalid input"}, class: ErrCommandRejected},
+		{name: "rpc", err: &RPCError{Type: "application", Tag: "invalid-value"}, class: ErrCommandRejected},
+		{name: "commit", err: &CommitError{Output: "commit", Err: cause}, class: ErrCommitFailed, cause: cause},
+		{name: "transfer", err: &TransferError{Op: "scp upload", Err: io.ErrUnexpectedEOF}, class: ErrTransferFailed, cause: io.ErrUnexpectedEOF},
+		{name: "timeout", err: &TimeoutError{Op: "send command"}, class: context.DeadlineExceeded},
+		{name: "context", err: &ContextError{Op: "send command", Err: context.Canceled}, class: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped := fmt.Errorf("r1: %w", tt.err)
+			if !errors.Is(wrapped, tt.class) {
+				t.Errorf("errors.Is(%v, %v) = false", wrapped, tt.class)
+			}
+			if tt.cause != nil && !errors.Is(wrapped, tt.cause) {
+				t.Errorf("errors.Is(%v, cause) = false", wrapped)
+			}
+			if tt.err.Error() == "" {
+				t.Error("Error() is empty")
+			}
+		})
+	}
+}
+
+func TestErrorWrappers(t *testing.T) {
+	ctxErr := &ContextError{Op: "commit", Err: context.Canceled}
+	commitErr := &CommitError{Message: "error: commit failed"}
+	cause := io.EOF
+
+	if err := commitError("out", nil); err != nil {
+		t.Errorf("commitError(nil) = %v", err)
+	}
+	if err := commitError("out", ctxErr); err != ctxErr {
+		t.Errorf("commitError() = %v, want the context error as is", err)
+	}
+	if err := commitError("out", commitErr); err != commitErr {
+		t.Errorf("commitError() = %v, want the commit error as is", err)
+	}
+	var got *CommitError
+	if err := commitError("out", cause); !errors.As(err, &got) || got.Output != "out" || got.Err != cause {
+		t.Errorf("commitError() = %#v, want a CommitError around the cause", err)
+	}
+
+	if err := transferError("sftp download", "a", "b", ctxErr); err != ctxErr {
+		t.Errorf("transferError() = %v, want the context error as is", err)
+	}
+	var transferErr *TransferError
+	if err := transferError("sftp download", "a", "b", cause); !errors.As(err, &transferErr) || transferErr.Local != "a" || transferErr.Remote != "b" {
+		t.Errorf("transferError() = %#v, want a TransferError", err)
+	}
+
+	handshake := errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain")
+	var authErr *AuthError
+	if err := authError("r1:22", "admin", handshake); !errors.As(err, &authErr) || authErr.Username != "admin" {
+		t.Errorf("authError() = %v, want an AuthError", err)
+	}
+	if err := authError("r1:22", "admin", io.EOF); err != io.EOF {
+		t.Errorf("authError() = %v, want other errors as is", err)
+	}
+}
+
+func TestCommitErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *CommitError
+		want string
+	}{
+		{err: &CommitError{Err: io.EOF, Message: "ignored"}, want: "commit failed: EOF"},
+		{err: &CommitError{Message: "error: commit failed", Output: "ignored"}, want: "commit failed: error: commit failed"},
+		{err: &CommitError{Output: "commit\r\n% Failed to commit\r\n\r\n"}, want: "commit failed: % Failed to commit"},
+		{err: &CommitError{}, want: "commit failed"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package netmigo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecResult is the outcome of a command run over an exec channel.
+type ExecResult struct {
+	Command    string
+	Stdout     string
+	Stderr     string
+	ExitStatus int
+}
+
+// Exec runs command in its own exec channel, without a PTY or prompt matching, and returns
+// stdout, stderr and the exit status separately. A non-zero exit status is reported in the
+// result, not as an error. The shell session, if any, is left untouched; when the device is
+// not connected a dedicated SSH client is used for the command.
+func (d *DeviceConnection) Exec(command string) (*ExecResult, error) {
+	return d.ExecContext(context.Background(), command)
+}
+
+// ExecContext is like Exec but aborts when ctx is done, returning the partial stdout in a
+// ContextError.
+func (d *DeviceConnection) ExecContext(ctx context.Context, command string) (*ExecResult, error) {
+	if ctx.Err() != nil {
+		return nil, &ContextError{Op: "exec " + command, Err: ctx.Err()}
+	}
+	client, closeClient, err := d.sshClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open exec channel: %w", err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	log.Infof("Executing command: %s", command)
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	err = session.Run(command)
+	if !stop() {
+		err := &ContextError{Op: "exec " + command, Output: stdout.String(), Err: ctx.Err()}
+		log.Error(err)
+		return nil, err
+	}
+
+	result := &ExecResult{
+		Command: command,
+		Stdout:  stdout.String(),
+		Stderr:  stderr.String(),
+	}
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.ExitStatus = exitErr.ExitStatus()
+	default:
+		result.ExitStatus = -1
+		return result, fmt.Errorf("exec %s: %w", command, err)
+	}
+	log.Debugf("Command %s exited with status %d", command, result.ExitStatus)
+	return result, nil
+}
+
+// execOutput runs command over an exec channel for the drivers' "exec" mode and returns
+// stdout, failing when the command exits with a non-zero status.
+func (d *DeviceConnection) execOutput(ctx context.Context, command string) (string, error) {
+	result, err := d.ExecContext(ctx, command)
+	if err != nil {
+		return "", err
+	}
+	if result.ExitStatus != 0 {
+		return result.Stdout, fmt.Errorf("command %q exited with status %d: %s", command, result.ExitStatus, result.Stderr)
+	}
+	return result.Stdout, nil
+}
@@ -0,0 +1,118 @@
+package netmigo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// execServer starts an SSH server running these exec commands:
+//
+//	show version  prints to stdout and stderr and exits with status 0
+//	false         exits with status 3
+//	noexit        closes the channel without an exit status
+//	sleep         prints "partial" and waits for the client to close the channel
+func execServer(t *testing.T) *sshServer {
+	t.Helper()
+	return newSSHServer(t, func(ch ssh.Channel, reqs <-chan *ssh.Request) {
+		defer ch.Close()
+		var command string
+		for req := range reqs {
+			if req.Type != "exec" {
+				req.Reply(false, nil)
+				continue
+			}
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				return
+			}
+			command = payload.Command
+			req.Reply(true, nil)
+			break
+		}
+
+		status := 0
+		switch command {
+		case "show version":
+			io.WriteString(ch, "Version 1.0\n")
+			io.WriteString(ch.Stderr(), "warning: deprecated command\n")
+		case "false":
+			io.WriteString(ch.Stderr(), "command failed\n")
+			status = 3
+		case "noexit":
+			return
+		case "sleep":
+			// The requests end when the client closes the channel
+			io.WriteString(ch, "partial")
+			ssh.DiscardRequests(reqs)
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+	})
+}
+
+func TestExec(t *testing.T) {
+	tests := []struct {
+		command string
+		want    ExecResult
+	}{
+		{
+			command: "show version",
+			want:    ExecResult{Command: "show version", Stdout: "Version 1.0\n", Stderr: "warning: deprecated command\n"},
+		},
+		{
+			command: "false",
+			want:    ExecResult{Command: "false", Stderr: "command failed\n", ExitStatus: 3},
+		},
+	}
+	server := execServer(t)
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			d := &DeviceConnection{Connection: server.connection(t, "secret")}
+			got, err := d.Exec(tt.command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("Exec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecExitMissing(t *testing.T) {
+	d := &DeviceConnection{Connection: execServer(t).connection(t, "secret")}
+	got, err := d.Exec("noexit")
+	var missing *ssh.ExitMissingError
+	if !errors.As(err, &missing) {
+		t.Fatalf("Exec() error = %v, want an ExitMissingError", err)
+	}
+	if got == nil || got.ExitStatus != -1 {
+		t.Errorf("Exec() = %+v, want exit status -1", got)
+	}
+}
+
+func TestExecCancel(t *testing.T) {
+	d := &DeviceConnection{Connection: execServer(t).connection(t, "secret")}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := d.ExecContext(ctx, "sleep")
+	var ctxErr *ContextError
+	if !errors.As(err, &ctxErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ExecContext() error = %v, want a ContextError", err)
+	}
+	if ctxErr.Output != "partial" {
+		t.Errorf("ContextError.Output = %q, want the partial stdout", ctxErr.Output)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ExecContext() returned after %v, want it aborted with the context", elapsed)
+	}
+}
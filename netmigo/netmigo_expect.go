@@ -0,0 +1,163 @@
+package netmigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pagerPrompt matches the pagers of the supported CLIs, such as "--More--", " -- More -- "
+// and "---(more 45%)---". Drivers answer it with a space.
+const pagerPrompt = `(?i)-{2,}\s*\(?more\b[^\n-]*\)?\s*-{2,} ?`
+
+// ExpectResult describes the match that ended an Expect.
+type ExpectResult struct {
+	Index      int    // index of the pattern that matched
+	Pattern    string // the pattern that matched
+	Before     string // output received before the match
+	Match      string
+	Submatches []string // capture groups of the pattern, empty for groups that did not match
+}
+
+// Output returns the output consumed by the Expect, up to the end of the match.
+func (r *ExpectResult) Output() string {
+	return r.Before + r.Match
+}
+
+// Expect waits for any of patterns and reports which one matched, the output before it and
+// the submatches. The output is consumed up to the end of the match; when several patterns
+// match, the earliest match wins. The read timeout of the connection applies; on timeout
+// the result holds the partial output and the error is a TimeoutError.
+func (d *DeviceConnection) Expect(patterns ...string) (*ExpectResult, error) {
+	return d.ExpectContext(context.Background(), patterns...)
+}
+
+// ExpectContext is like Expect but aborts when ctx is done, returning the partial output in
+// a ContextError.
+func (d *DeviceConnection) ExpectContext(ctx context.Context, patterns ...string) (*ExpectResult, error) {
+	return d.expect(ctx, d.readTimeout(ctx), "expect "+strings.Join(patterns, " | "), patterns...)
+}
+
+// expect is the engine behind Expect, ReadUntil and the drivers. On timeout or a read error
+// the result has Index -1 and the partial output in Before; a timeout returns a
+// TimeoutError. A done ctx tears the channel down and returns a ContextError.
+func (d *DeviceConnection) expect(ctx context.Context, timeout time.Duration, op string, patterns ...string) (*ExpectResult, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("expect needs at least one pattern")
+	}
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		r, err := compilePattern(pattern)
+		if err != nil {
+			log.Errorf("Failed to compile regex pattern '%s': %v", pattern, err)
+			return nil, err
+		}
+		res[i] = r
+	}
+	output, err := d.Connection.sessionOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	readCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	out, index, loc, err := output.expect(readCtx, res)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, d.contextError(ctx, op, out)
+		}
+		result := &ExpectResult{Index: -1, Before: out}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return result, &TimeoutError{Op: op, Pattern: strings.Join(patterns, " | "), Output: out, Elapsed: time.Since(start)}
+		}
+		return result, fmt.Errorf("error reading from connection: %w", err)
+	}
+
+	result := &ExpectResult{
+		Index:   index,
+		Pattern: patterns[index],
+		Before:  out[:loc[0]],
+		Match:   out[loc[0]:loc[1]],
+	}
+	for i := 2; i < len(loc); i += 2 {
+		if loc[i] < 0 {
+			result.Submatches = append(result.Submatches, "")
+			continue
+		}
+		result.Submatches = append(result.Submatches, out[loc[i]:loc[i+1]])
+	}
+	return result, nil
+}
+
+// expectAnswer is a question answered automatically while a driver waits for its end marker.
+type expectAnswer struct {
+	pattern  string
+	response string
+}
+
+// expectLines reads until the count-th line containing a match of marker, answering pagers
+// and the given questions, and returns the output up to the end of that line with carriage
+// returns removed. A timeout returns a TimeoutError holding the output of the whole wait; a
+// done ctx tears the channel down and returns a ContextError.
+func (d *DeviceConnection) expectLines(ctx context.Context, timeout time.Duration, op string, marker string, count int, answers ...expectAnswer) (string, error) {
+	patterns := []string{`(?:` + marker + `)[^\n]*\n`, pagerPrompt}
+	for _, answer := range answers {
+		patterns = append(patterns, answer.pattern)
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	var output strings.Builder
+	seen := 0
+	for {
+		result, err := d.expect(ctx, time.Until(deadline), op, patterns...)
+		if err != nil {
+			var ctxErr *ContextError
+			var timeoutErr *TimeoutError
+			switch {
+			case errors.As(err, &ctxErr):
+				ctxErr.Output = output.String() + ctxErr.Output
+				return "", err
+			case errors.As(err, &timeoutErr):
+				timeoutErr.Pattern = marker
+				timeoutErr.Output = normalizeNewlines(output.String() + timeoutErr.Output)
+				timeoutErr.Elapsed = time.Since(start)
+				log.Error(timeoutErr)
+				return "", timeoutErr
+			default:
+				log.Error(err)
+				return "", fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		switch result.Index {
+		case 0:
+			output.WriteString(result.Output())
+			seen++
+			if seen == count {
+				log.Info("Reading completed")
+				return normalizeNewlines(output.String()), nil
+			}
+		case 1:
+			// Drop the pager prompt from the output and ask for the next page.
+			output.WriteString(result.Before)
+			if _, err := d.Connection.WriteString(" "); err != nil {
+				return "", err
+			}
+		default:
+			output.WriteString(result.Output())
+			answer := answers[result.Index-2]
+			log.Infof("Answering %q", result.Match)
+			if _, err := d.Connection.WriteString(answer.response); err != nil {
+				return "", err
+			}
+		}
+	}
+}
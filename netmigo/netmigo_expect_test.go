@@ -0,0 +1,226 @@
+package netmigo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeDevice connects a DeviceConnection to a scripted device. script gets the device side of
+// the connection.
+func pipeDevice(t *testing.T, script func(line net.Conn, lines *bufio.Reader)) *DeviceConnection {
+	t.Helper()
+	client, device := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		device.Close()
+	})
+	go script(device, bufio.NewReader(device))
+	return &DeviceConnection{
+		Connection: &SSHConnModel{
+			Username:    "admin",
+			Password:    "secret",
+			ReadTimeout: time.Second,
+			Reader:      newSessionReader(client),
+			Writer:      client,
+		},
+		Return: "\n",
+	}
+}
+
+func TestPagerPrompt(t *testing.T) {
+	re := regexp.MustCompile(pagerPrompt)
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"interface Gi0/0\r\n --More-- ", "--More-- "},
+		{"line\r\n -- More -- ", "-- More -- "},
+		{"line\r\n---(more 45%)---", "---(more 45%)---"},
+		{"line\r\n---(more)---", "---(more)---"},
+		{"description -- more to come --", "-- more to come --"},
+		{"router#", ""},
+		{"-----------------------", ""},
+		{"description moreover", ""},
+	}
+	for _, tt := range tests {
+		if got := re.FindString(tt.output); got != tt.want {
+			t.Errorf("pager match in %q = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestExpect(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		patterns []string
+		want     *ExpectResult
+		wantErr  bool
+	}{
+		{
+			name:     "earliest match wins",
+			output:   "Destination filename [startup-config]? router#",
+			patterns: []string{`router#`, `filename \[([^\]]+)\]\?`},
+			want: &ExpectResult{
+				Index:      1,
+				Pattern:    `filename \[([^\]]+)\]\?`,
+				Before:     "Destination ",
+				Match:      "filename [startup-config]?",
+				Submatches: []string{"startup-config"},
+			},
+		},
+		{
+			name:     "unmatched group",
+			output:   "Proceed? [confirm]",
+			patterns: []string{`\[(yes|no)\]|\[(confirm)\]`},
+			want: &ExpectResult{
+				Index:      0,
+				Pattern:    `\[(yes|no)\]|\[(confirm)\]`,
+				Before:     "Proceed? ",
+				Match:      "[confirm]",
+				Submatches: []string{"", "confirm"},
+			},
+		},
+		{
+			name:     "timeout",
+			output:   "Building configuration...",
+			patterns: []string{`router#`},
+			want:     &ExpectResult{Index: -1, Before: "Building configuration..."},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := pipeDevice(t, func(line net.Conn, lines *bufio.Reader) {
+				line.Write([]byte(tt.output))
+			})
+			d.Connection.ReadTimeout = 100 * time.Millisecond
+
+			got, err := d.Expect(tt.patterns...)
+			var timeoutErr *TimeoutError
+			if tt.wantErr != errors.As(err, &timeoutErr) {
+				t.Fatalf("Expect() error = %v, want timeout %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expect() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpectLines(t *testing.T) {
+	received := make(chan string, 2)
+	d := pipeDevice(t, func(line net.Conn, lines *bufio.Reader) {
+		line.Write([]byte("show running-config\r\nhostname R1\r\n --More-- "))
+		next, _ := lines.ReadByte()
+		received <- string(next)
+		line.Write([]byte("end\r\nOverwrite? [no]: "))
+		answer, _ := lines.ReadString('\n')
+		received <- answer
+		line.Write([]byte("yes\r\nR1#\r\n"))
+	})
+
+	got, err := d.expectLines(context.Background(), time.Second, "show running-config", `R1#`, 1,
+		expectAnswer{pattern: `Overwrite\? \[no\]: `, response: "yes\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "show running-config\nhostname R1\n end\nOverwrite? [no]: yes\nR1#\n"
+	if got != want {
+		t.Errorf("expectLines() = %q, want %q", got, want)
+	}
+	if next, answer := <-received, <-received; next != " " || answer != "yes\n" {
+		t.Errorf("device received %q and %q, want a space and the answer", next, answer)
+	}
+}
+
+func TestSendCommandContextAborted(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{
+			name: "cancelled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(100*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			want: context.Canceled,
+		},
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 100*time.Millisecond)
+			},
+			want: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := pipeDevice(t, func(line net.Conn, lines *bufio.Reader) {
+				lines.ReadString('\n')
+				line.Write([]byte("show tech-support\r\nBuilding report..."))
+			})
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			_, err := d.SendCommandPatternContext(ctx, "show tech-support", "R1#")
+			var ctxErr *ContextError
+			if !errors.As(err, &ctxErr) || !errors.Is(err, tt.want) {
+				t.Fatalf("SendCommandPatternContext() error = %v, want a ContextError wrapping %v", err, tt.want)
+			}
+			if ctxErr.Output != "show tech-support\r\nBuilding report..." {
+				t.Errorf("ContextError.Output = %q, want the partial output", ctxErr.Output)
+			}
+
+			// The aborted command may still be running, the session is not used again
+			if !d.Connection.sessionLost() {
+				t.Error("session still in use after the abort")
+			}
+			if _, err := d.SendCommandPatternContext(context.Background(), "show clock", "R1#"); !errors.Is(err, ErrSessionBroken) {
+				t.Errorf("SendCommandPatternContext() after the abort error = %v, want ErrSessionBroken", err)
+			}
+		})
+	}
+}
+
+func TestSendCommandTimeout(t *testing.T) {
+	d := pipeDevice(t, func(line net.Conn, lines *bufio.Reader) {
+		lines.ReadString('\n')
+		line.Write([]byte("show tech-support\r\nBuilding report..."))
+		lines.ReadString('\n')
+		line.Write([]byte("\r\nshow clock\r\n10:00:00 UTC\r\nR1#"))
+	})
+	d.Connection.ReadTimeout = 100 * time.Millisecond
+
+	_, err := d.SendCommandPatternContext(context.Background(), "show tech-support", "R1#")
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SendCommandPatternContext() error = %v, want a TimeoutError wrapping context.DeadlineExceeded", err)
+	}
+	if timeoutErr.Output != "show tech-support\r\nBuilding report..." {
+		t.Errorf("TimeoutError.Output = %q, want the partial output", timeoutErr.Output)
+	}
+
+	// The session stays usable
+	if d.Connection.sessionLost() {
+		t.Fatal("session lost after a timeout")
+	}
+	d.Connection.ReadTimeout = time.Second
+	out, err := d.SendCommandPatternContext(context.Background(), "show clock", "R1#")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "10:00:00 UTC") {
+		t.Errorf("SendCommandPatternContext() = %q, want the output of show clock", out)
+	}
+}
@@ -0,0 +1,237 @@
+package netmigo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyPolicy selects how the SSH host key presented by a device is verified.
+type HostKeyPolicy int
+
+const (
+	// HostKeyInsecure accepts any host key. This is the default, kept for compatibility.
+	HostKeyInsecure HostKeyPolicy = iota
+	// HostKeyKnownHosts requires the key to be present in an OpenSSH known_hosts file.
+	HostKeyKnownHosts
+	// HostKeyFingerprint requires the key to match one of the pinned SHA256 fingerprints.
+	HostKeyFingerprint
+	// HostKeyTOFU records the key on first contact and requires it to match afterwards.
+	HostKeyTOFU
+)
+
+// HostKeyMismatchError is returned when a device presents a host key different from the expected one.
+type HostKeyMismatchError struct {
+	Host        string
+	Fingerprint string
+	Expected    []string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: got %s, expected %s", e.Host, e.Fingerprint, strings.Join(e.Expected, ", "))
+}
+
+func (e *HostKeyMismatchError) Is(target error) bool {
+	return target == ErrHostKeyMismatch
+}
+
+// HostKeyUnknownError is returned by the known_hosts policy when the device is not listed.
+type HostKeyUnknownError struct {
+	Host        string
+	Fingerprint string
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	return fmt.Sprintf("host key for %s (%s) not found in known_hosts", e.Host, e.Fingerprint)
+}
+
+// tofuMutex serialises writes to trust-on-first-use stores shared between connections.
+var tofuMutex sync.Mutex
+
+// SetKnownHosts enables strict host key checking against the given known_hosts file.
+func (c *SSHConnModel) SetKnownHosts(path string) {
+	c.HostKeyPolicy = HostKeyKnownHosts
+	c.KnownHostsFile = path
+}
+
+// SetHostKeyFingerprints pins the device to one or more SHA256 fingerprints, as printed by
+// `ssh-keygen -lf` ("SHA256:..."). The "SHA256:" prefix is optional.
+func (c *SSHConnModel) SetHostKeyFingerprints(fingerprints ...string) {
+	c.HostKeyPolicy = HostKeyFingerprint
+	c.HostKeyFingerprints = fingerprints
+}
+
+// SetTrustOnFirstUse records unknown host keys in the given known_hosts style file and
+// verifies them on every following connection.
+func (c *SSHConnModel) SetTrustOnFirstUse(path string) {
+	c.HostKeyPolicy = HostKeyTOFU
+	c.KnownHostsFile = path
+}
+
+// hostKeyCallback returns the ssh.HostKeyCallback for the configured policy.
+func (c *SSHConnModel) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	switch c.HostKeyPolicy {
+	case HostKeyInsecure:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyKnownHosts:
+		return knownHostsCallback(c.KnownHostsFile)
+	case HostKeyFingerprint:
+		return fingerprintCallback(c.HostKeyFingerprints)
+	case HostKeyTOFU:
+		return tofuCallback(c.KnownHostsFile)
+	default:
+		return nil, fmt.Errorf("unsupported host key policy: %d", c.HostKeyPolicy)
+	}
+}
+
+// knownHostsCallback verifies host keys against a known_hosts file, translating the
+// knownhosts errors into HostKeyMismatchError and HostKeyUnknownError.
+func knownHostsCallback(path string) (ssh.HostKeyCallback, error) {
+	if path == "" {
+		return nil, errors.New("known_hosts policy requires a file path")
+	}
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts '%s': %v", path, err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return &HostKeyUnknownError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key)}
+			}
+			return &HostKeyMismatchError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Expected: knownFingerprints(keyErr.Want)}
+		}
+		return err
+	}, nil
+}
+
+// fingerprintCallback verifies host keys against pinned SHA256 fingerprints.
+func fingerprintCallback(fingerprints []string) (ssh.HostKeyCallback, error) {
+	if len(fingerprints) == 0 {
+		return nil, errors.New("fingerprint policy requires at least one fingerprint")
+	}
+	expected := make([]string, len(fingerprints))
+	for i, fp := range fingerprints {
+		expected[i] = "SHA256:" + strings.TrimPrefix(strings.TrimSpace(fp), "SHA256:")
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		got := ssh.FingerprintSHA256(key)
+		for _, fp := range expected {
+			if fp == got {
+				return nil
+			}
+		}
+		return &HostKeyMismatchError{Host: hostname, Fingerprint: got, Expected: expected}
+	}, nil
+}
+
+// tofuCallback accepts and records the first key seen for a host, then behaves like
+// the known_hosts policy for that host.
+func tofuCallback(path string) (ssh.HostKeyCallback, error) {
+	if path == "" {
+		return nil, errors.New("trust-on-first-use policy requires a file path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create directory for '%s': %v", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trust-on-first-use store '%s': %v", path, err)
+	}
+	f.Close()
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		tofuMutex.Lock()
+		defer tofuMutex.Unlock()
+
+		check, err := knownhosts.New(path)
+		if err != nil {
+			return fmt.Errorf("failed to load trust-on-first-use store '%s': %v", path, err)
+		}
+		err = check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return &HostKeyMismatchError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Expected: knownFingerprints(keyErr.Want)}
+		}
+
+		store, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open trust-on-first-use store '%s': %v", path, err)
+		}
+		defer store.Close()
+		if _, err := store.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"); err != nil {
+			return fmt.Errorf("failed to record host key for %s: %v", hostname, err)
+		}
+		log.Warnf("Trusting host key %s for %s on first use", ssh.FingerprintSHA256(key), hostname)
+		return nil
+	}, nil
+}
+
+// preferKnownHostKeys moves the host key algorithms of the key types recorded for addr in
+// the known_hosts file to the front of algorithms, as OpenSSH does. Otherwise a device with
+// several host keys may present one of a type not on file, which the check reports as a
+// mismatch.
+func preferKnownHostKeys(path, addr string, algorithms []string) []string {
+	check, err := knownhosts.New(path)
+	if err != nil {
+		log.Debugf("Not ordering host key algorithms by known_hosts: %v", err)
+		return algorithms
+	}
+	// A key of no real type matches nothing, so the error lists every key known for addr.
+	var keyErr *knownhosts.KeyError
+	if !errors.As(check(addr, &net.TCPAddr{}, probeKey{}), &keyErr) || len(keyErr.Want) == 0 {
+		return algorithms
+	}
+
+	var known []string
+	for _, k := range keyErr.Want {
+		known = append(known, hostKeyAlgorithms(k.Key.Type())...)
+	}
+	preferred := make([]string, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		if contains(known, algorithm) {
+			preferred = append(preferred, algorithm)
+		}
+	}
+	if len(preferred) == 0 {
+		return algorithms
+	}
+	log.Debugf("Preferring host key algorithms %v known for %s", preferred, addr)
+	return extend(preferred, algorithms...)
+}
+
+// hostKeyAlgorithms returns the host key algorithms that present a key of keyType.
+func hostKeyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// probeKey is a public key of no real type, for listing the keys known for a host.
+type probeKey struct{}
+
+func (probeKey) Type() string                        { return "netmigo-probe" }
+func (probeKey) Marshal() []byte                     { return []byte("netmigo-probe") }
+func (probeKey) Verify([]byte, *ssh.Signature) error { return errors.New("probe key cannot verify") }
+
+func knownFingerprints(keys []knownhosts.KnownKey) []string {
+	fingerprints := make([]string, len(keys))
+	for i, k := range keys {
+		fingerprints[i] = ssh.FingerprintSHA256(k.Key)
+	}
+	return fingerprints
+}
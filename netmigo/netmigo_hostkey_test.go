@@ -0,0 +1,158 @@
+package netmigo
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newHostKeys(t *testing.T) (ed, ec, rs ssh.PublicKey) {
+	t.Helper()
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []ssh.PublicKey{}
+	for _, pub := range []interface{}{edPub, &ecKey.PublicKey, &rsaKey.PublicKey} {
+		key, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	return keys[0], keys[1], keys[2]
+}
+
+// writeKnownHosts writes a known_hosts file with one line per host and returns its path.
+func writeKnownHosts(t *testing.T, hosts map[string]ssh.PublicKey) string {
+	t.Helper()
+	var lines []string
+	for host, key := range hosts {
+		lines = append(lines, knownhosts.Line([]string{knownhosts.Normalize(host)}, key))
+	}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPreferKnownHostKeys(t *testing.T) {
+	ed, ec, rs := newHostKeys(t)
+	path := writeKnownHosts(t, map[string]ssh.PublicKey{"r1:22": ec, "r2:2222": rs, "r3:22": ed})
+	profile := algorithmProfiles[AlgorithmsCompat].HostKeys
+
+	tests := []struct {
+		addr      string
+		wantFirst []string
+	}{
+		{addr: "r1:22", wantFirst: []string{ssh.KeyAlgoECDSA256}},
+		{addr: "r2:2222", wantFirst: []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}},
+		{addr: "r3:22", wantFirst: []string{ssh.KeyAlgoED25519}},
+		{addr: "r2:22", wantFirst: profile[:1]},
+		{addr: "unknown:22", wantFirst: profile[:1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got := preferKnownHostKeys(path, tt.addr, profile)
+			if !reflect.DeepEqual(got[:len(tt.wantFirst)], tt.wantFirst) {
+				t.Errorf("preferKnownHostKeys() starts with %v, want %v", got[:len(tt.wantFirst)], tt.wantFirst)
+			}
+			if len(got) != len(profile) {
+				t.Errorf("preferKnownHostKeys() = %v, want a reordering of %v", got, profile)
+			}
+		})
+	}
+
+	if got := preferKnownHostKeys(filepath.Join(t.TempDir(), "missing"), "r1:22", profile); !reflect.DeepEqual(got, profile) {
+		t.Errorf("preferKnownHostKeys() without a file = %v, want the profile", got)
+	}
+}
+
+func TestHostKeyCallbacks(t *testing.T) {
+	ed, ec, _ := newHostKeys(t)
+	path := writeKnownHosts(t, map[string]ssh.PublicKey{"r1:22": ed})
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+
+	knownHosts, err := knownHostsCallback(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := fingerprintCallback([]string{strings.TrimPrefix(ssh.FingerprintSHA256(ed), "SHA256:")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		callback ssh.HostKeyCallback
+		host     string
+		key      ssh.PublicKey
+		wantErr  interface{}
+	}{
+		{name: "known_hosts match", callback: knownHosts, host: "r1:22", key: ed},
+		{name: "known_hosts mismatch", callback: knownHosts, host: "r1:22", key: ec, wantErr: &HostKeyMismatchError{}},
+		{name: "known_hosts unknown", callback: knownHosts, host: "r2:22", key: ed, wantErr: &HostKeyUnknownError{}},
+		{name: "fingerprint match", callback: fingerprint, host: "r1:22", key: ed},
+		{name: "fingerprint mismatch", callback: fingerprint, host: "r1:22", key: ec, wantErr: &HostKeyMismatchError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.callback(tt.host, remote, tt.key)
+			switch tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("callback() error = %v", err)
+				}
+			case *HostKeyMismatchError:
+				if !errors.Is(err, ErrHostKeyMismatch) {
+					t.Errorf("callback() error = %v, want a mismatch", err)
+				}
+			case *HostKeyUnknownError:
+				var unknown *HostKeyUnknownError
+				if !errors.As(err, &unknown) {
+					t.Errorf("callback() error = %v, want an unknown host", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTOFUCallback(t *testing.T) {
+	ed, ec, _ := newHostKeys(t)
+	path := filepath.Join(t.TempDir(), "tofu", "known_hosts")
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	check, err := tofuCallback(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := check("r1:22", remote, ed); err != nil {
+		t.Fatalf("first contact error = %v", err)
+	}
+	if err := check("r1:22", remote, ed); err != nil {
+		t.Errorf("recorded key error = %v", err)
+	}
+	if err := check("r1:22", remote, ec); !errors.Is(err, ErrHostKeyMismatch) {
+		t.Errorf("changed key error = %v, want a mismatch", err)
+	}
+}
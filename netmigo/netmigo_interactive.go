@@ -0,0 +1,200 @@
+package netmigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// questionPattern matches output that looks like the device waits for an answer: a trailing
+// question mark or a confirmation choice such as "[confirm]", "[yes,no]" or "(y/n)".
+const questionPattern = `(?i)(\?|\[confirm\]|\[y(es)?[,/]n(o)?\]|\(y(es)?/n(o)?\)|\[(yes|no)\]:?|\((yes|no)\))\s*$`
+
+// questionSettle is how long the output must stay quiet after a question-like line before
+// it is reported as an unexpected question.
+const questionSettle = 500 * time.Millisecond
+
+// InteractiveStep is a question of an interactive dialog and its answer.
+type InteractiveStep struct {
+	Expect       string        // pattern of the question
+	Response     string        // sent when Expect matches, followed by the device return
+	HideResponse bool          // keep Response out of the logs, for passwords
+	Timeout      time.Duration // how long to wait for the question after the previous answer, zero for the read timeout
+}
+
+// Interaction is a command that asks follow-up questions. With Ordered, the questions must
+// come in the order of Steps; otherwise each step is answered at most once, whichever comes
+// first. The dialog ends when End matches; steps not asked by then are skipped. A step is
+// only answered within its own Timeout, and the dialog times out once neither End nor any
+// step can come in time.
+type Interaction struct {
+	Command string
+	Steps   []InteractiveStep
+	Ordered bool
+	End     string        // pattern that ends the dialog, usually the device prompt
+	Timeout time.Duration // how long to wait for End, zero for the read timeout
+}
+
+// UnexpectedQuestionError is returned when the device asks a question that no step expects.
+// The question is left unanswered.
+type UnexpectedQuestionError struct {
+	Command    string
+	Question   string
+	Transcript string
+}
+
+func (e *UnexpectedQuestionError) Error() string {
+	return fmt.Sprintf("unexpected question from device during %q: %q", e.Command, e.Question)
+}
+
+// SendInteractive sends the command of interaction, answers its questions and returns the
+// transcript of the dialog. The transcript is also returned with errors.
+func (d *DeviceConnection) SendInteractive(interaction Interaction) (string, error) {
+	return d.SendInteractiveContext(context.Background(), interaction)
+}
+
+// SendInteractiveContext is like SendInteractive but aborts when ctx is done, returning the
+// partial transcript in a ContextError.
+func (d *DeviceConnection) SendInteractiveContext(ctx context.Context, interaction Interaction) (string, error) {
+	if err := d.ensureConnected(ctx); err != nil {
+		return "", err
+	}
+	if interaction.End == "" {
+		return "", errors.New("interactive dialog needs an End pattern")
+	}
+	op := "interactive " + interaction.Command
+	readTimeout := d.readTimeout(ctx)
+
+	d.syncOutput(ctx)
+	log.Infof("Sending interactive command: %s", interaction.Command)
+	if _, err := d.Connection.WriteString(interaction.Command + d.Return); err != nil {
+		return "", err
+	}
+
+	endTimeout := interaction.Timeout
+	if endTimeout == 0 {
+		endTimeout = readTimeout
+	}
+	answered := make([]bool, len(interaction.Steps))
+	expired := make([]bool, len(interaction.Steps))
+	next := 0           // next step of an ordered dialog
+	since := time.Now() // timeouts count from the command or the last answer
+	var transcript strings.Builder
+	for {
+		// Wait for End and the steps that may come now, each until its own timeout. The
+		// wait gives up once the last of them has passed.
+		patterns := []string{interaction.End}
+		var pending []int
+		wait, latest := time.Time{}, since.Add(endTimeout)
+		if time.Now().Before(latest) {
+			wait = latest
+		}
+		for i, step := range interaction.Steps {
+			if answered[i] || expired[i] || (interaction.Ordered && i != next) {
+				continue
+			}
+			stepTimeout := step.Timeout
+			if stepTimeout == 0 {
+				stepTimeout = readTimeout
+			}
+			deadline := since.Add(stepTimeout)
+			if !time.Now().Before(deadline) {
+				log.Debugf("Interactive command %s: stopped waiting for %q", interaction.Command, step.Expect)
+				expired[i] = true
+				continue
+			}
+			pending = append(pending, i)
+			patterns = append(patterns, step.Expect)
+			if wait.IsZero() || deadline.Before(wait) {
+				wait = deadline
+			}
+			if deadline.After(latest) {
+				latest = deadline
+			}
+		}
+		patterns = append(patterns, questionPattern)
+		if wait.IsZero() {
+			wait = latest
+		}
+
+		result, err := d.expect(ctx, time.Until(wait), op, patterns...)
+		if err != nil {
+			var ctxErr *ContextError
+			if errors.As(err, &ctxErr) {
+				ctxErr.Output = transcript.String() + ctxErr.Output
+				return "", err
+			}
+			var timeoutErr *TimeoutError
+			if errors.As(err, &timeoutErr) && time.Now().Before(latest) {
+				// Only some of the patterns timed out; search the output again for the rest.
+				if output, err := d.Connection.sessionOutput(); err == nil {
+					output.unread(result.Before)
+					continue
+				}
+			}
+			transcript.WriteString(result.Before)
+			if timeoutErr != nil {
+				timeoutErr.Output = normalizeNewlines(transcript.String())
+				timeoutErr.Elapsed = time.Since(since)
+			} else {
+				err = fmt.Errorf("%s: %w", op, err)
+			}
+			log.Error(err)
+			return normalizeNewlines(transcript.String()), err
+		}
+		transcript.WriteString(result.Output())
+
+		switch {
+		case result.Index == 0:
+			log.Infof("Interactive command %s completed", interaction.Command)
+			return normalizeNewlines(transcript.String()), nil
+
+		case result.Index <= len(pending):
+			i := pending[result.Index-1]
+			step := interaction.Steps[i]
+			answered[i] = true
+			next = i + 1
+			since = time.Now()
+			if step.HideResponse {
+				log.Infof("Answering %q with a hidden response", result.Match)
+			} else {
+				log.Infof("Answering %q with %q", result.Match, step.Response)
+			}
+			if _, err := d.Connection.WriteString(step.Response + d.Return); err != nil {
+				return normalizeNewlines(transcript.String()), err
+			}
+
+		default:
+			// Looks like a question; it is unexpected if the device then waits for an answer.
+			settle, err := d.expect(ctx, questionSettle, op, `[\s\S]`)
+			if err == nil {
+				transcript.WriteString(settle.Output())
+				continue
+			}
+			var ctxErr *ContextError
+			if errors.As(err, &ctxErr) {
+				ctxErr.Output = transcript.String() + ctxErr.Output
+				return "", err
+			}
+			question := transcript.String()
+			if i := strings.LastIndex(question, "\n"); i >= 0 {
+				question = question[i+1:]
+			}
+			err = &UnexpectedQuestionError{
+				Command:    interaction.Command,
+				Question:   strings.TrimSpace(question),
+				Transcript: normalizeNewlines(transcript.String()),
+			}
+			log.Error(err)
+			return normalizeNewlines(transcript.String()), err
+		}
+	}
+}
+
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
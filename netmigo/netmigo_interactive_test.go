@@ -0,0 +1,122 @@
+package netmigo
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendInteractive(t *testing.T) {
+	tests := []struct {
+		name        string
+		interaction Interaction
+		delay       time.Duration // before each reply
+		replies     []string      // one for each line received
+		want        []string      // lines received
+		wantErr     error
+	}{
+		{
+			name: "ordered questions answered",
+			interaction: Interaction{
+				Command: "copy run disk0:",
+				Steps: []InteractiveStep{
+					{Expect: `Destination filename \[[^\]]*\]\?`, Response: ""},
+					{Expect: `\[confirm\]`, Response: "y"},
+				},
+				Ordered: true,
+				End:     `R1#`,
+			},
+			replies: []string{"Destination filename [run]? ", "Copy? [confirm]", "\r\ncopied\r\nR1#"},
+			want:    []string{"copy run disk0:", "", "y"},
+		},
+		{
+			name: "step not asked in time is skipped",
+			interaction: Interaction{
+				Command: "reload",
+				Steps:   []InteractiveStep{{Expect: `\[confirm\]`, Response: "y", Timeout: 100 * time.Millisecond}},
+				End:     `R1#`,
+				Timeout: 2 * time.Second,
+			},
+			delay:   300 * time.Millisecond,
+			replies: []string{"\r\nR1#"},
+			want:    []string{"reload"},
+		},
+		{
+			name: "late question is not answered",
+			interaction: Interaction{
+				Command: "reload",
+				Steps:   []InteractiveStep{{Expect: `\[confirm\]`, Response: "y", Timeout: 100 * time.Millisecond}},
+				End:     `R1#`,
+				Timeout: 2 * time.Second,
+			},
+			delay:   300 * time.Millisecond,
+			replies: []string{"Proceed with reload? [confirm]"},
+			want:    []string{"reload"},
+			wantErr: &UnexpectedQuestionError{},
+		},
+		{
+			name: "step timeout ends the wait",
+			interaction: Interaction{
+				Command: "reload",
+				Steps:   []InteractiveStep{{Expect: `\[confirm\]`, Response: "y", Timeout: 200 * time.Millisecond}},
+				End:     `R1#`,
+				Timeout: 100 * time.Millisecond,
+			},
+			want:    []string{"reload"},
+			wantErr: &TimeoutError{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan string, 10)
+			d := pipeDevice(t, func(line net.Conn, lines *bufio.Reader) {
+				for i := 0; ; i++ {
+					text, err := lines.ReadString('\n')
+					if err != nil {
+						return
+					}
+					received <- strings.TrimSpace(text)
+					if i < len(tt.replies) {
+						time.Sleep(tt.delay)
+						line.Write([]byte(tt.replies[i]))
+					}
+				}
+			})
+
+			start := time.Now()
+			_, err := d.SendInteractive(tt.interaction)
+			var questionErr *UnexpectedQuestionError
+			var timeoutErr *TimeoutError
+			switch tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("SendInteractive() error = %v", err)
+				}
+			case *UnexpectedQuestionError:
+				if !errors.As(err, &questionErr) {
+					t.Fatalf("SendInteractive() error = %v, want an unexpected question", err)
+				}
+			case *TimeoutError:
+				if !errors.As(err, &timeoutErr) {
+					t.Fatalf("SendInteractive() error = %v, want a timeout", err)
+				}
+				// The read timeout of the line is a second; the dialog set shorter ones
+				if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+					t.Errorf("SendInteractive() timed out after %v", elapsed)
+				}
+			}
+
+			var got []string
+			for len(received) > 0 {
+				got = append(got, <-received)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("device received %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package netmigo
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// AddJumpHost appends a bastion to the chain used to reach the device. Jump hosts are
+// traversed in the order they were added, each one with its own credentials and host key policy.
+func (c *SSHConnModel) AddJumpHost(jump *SSHConnModel) {
+	c.JumpHosts = append(c.JumpHosts, jump)
+}
+
+// Dial opens an SSH client to the device, tunnelling through the configured jump hosts.
+// The jump host clients are tracked by the connection and closed by Disconnect.
+func (c *SSHConnModel) Dial() (*ssh.Client, error) {
+	return c.DialContext(context.Background())
+}
+
+// DialContext is like Dial but aborts the TCP connect and the handshakes when ctx is done.
+// When the device rejects the credentials, the next ones from the credential providers are tried.
+func (c *SSHConnModel) DialContext(ctx context.Context) (*ssh.Client, error) {
+	var client *ssh.Client
+	err := c.tryCredentials(ctx, func() error {
+		var err error
+		client, err = c.dialChain(ctx)
+		return err
+	})
+	return client, err
+}
+
+// dialChain dials the jump hosts, if any, and the device.
+func (c *SSHConnModel) dialChain(ctx context.Context) (*ssh.Client, error) {
+	sshConfig, err := c.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(c.JumpHosts) == 0 {
+		return c.dialSSH(ctx, c.Addr, sshConfig)
+	}
+
+	var previous *ssh.Client
+	for i, jump := range c.JumpHosts {
+		// Each jump host logs in with its own credentials and credential providers
+		var client *ssh.Client
+		err := jump.tryCredentials(ctx, func() error {
+			jumpConfig, err := jump.ClientConfig()
+			if err != nil {
+				return err
+			}
+			if previous == nil {
+				client, err = c.dialSSH(ctx, jump.Addr, jumpConfig)
+			} else {
+				client, err = dialThrough(ctx, previous, jump.Addr, jumpConfig)
+			}
+			return err
+		})
+		if err != nil {
+			c.closeJumpHosts()
+			return nil, fmt.Errorf("jump host %d (%s): %w", i+1, jump.Addr, err)
+		}
+
+		log.Infof("Connected to jump host %s", jump.Addr)
+		c.jumpClients = append(c.jumpClients, client)
+		previous = client
+	}
+
+	client, err := dialThrough(ctx, previous, c.Addr, sshConfig)
+	if err != nil {
+		c.closeJumpHosts()
+		return nil, err
+	}
+	return client, nil
+}
+
+// dialSSH opens an SSH client to addr over a TCP connection from the connection dialer.
+func (c *SSHConnModel) dialSSH(ctx context.Context, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := c.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return newClientConn(ctx, conn, addr, sshConfig)
+}
+
+// dialThrough opens an SSH client to addr over a direct-tcpip channel of an existing client.
+func dialThrough(ctx context.Context, via *ssh.Client, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := via.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tunnel to %s: %w", addr, err)
+	}
+	return newClientConn(ctx, conn, addr, sshConfig)
+}
+
+// newClientConn runs the SSH handshake over conn, closing conn if ctx is done meanwhile.
+func newClientConn(ctx context.Context, conn net.Conn, addr string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if !stop() {
+		if err == nil {
+			clientConn.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, authError(addr, sshConfig.User, negotiationError(addr, err))
+	}
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// closeJumpHosts closes the bastion clients, innermost first, and the ssh-agent connections
+// the jump hosts opened for their handshakes.
+func (c *SSHConnModel) closeJumpHosts() {
+	for i := len(c.jumpClients) - 1; i >= 0; i-- {
+		c.jumpClients[i].Close()
+	}
+	c.jumpClients = nil
+	for _, jump := range c.JumpHosts {
+		jump.closeAgent()
+	}
+}
@@ -0,0 +1,410 @@
+package netmigo
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshServer is an in-process SSH server for the transport tests. It accepts the password
+// "secret", forwards direct-tcpip channels and hands session channels to session.
+type sshServer struct {
+	addr    string
+	config  *ssh.ServerConfig
+	session func(ch ssh.Channel, reqs <-chan *ssh.Request)
+
+	mu        sync.Mutex
+	conns     []net.Conn
+	logins    int
+	forwarded []string // targets of the direct-tcpip channels
+}
+
+func newSSHServer(t *testing.T, session func(ch ssh.Channel, reqs <-chan *ssh.Request)) *sshServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sshServer{session: session}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.addr = listener.Addr().String()
+	t.Cleanup(func() {
+		listener.Close()
+		s.closeConnections()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *sshServer) serve(conn net.Conn) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer serverConn.Close()
+	s.mu.Lock()
+	s.logins++
+	s.mu.Unlock()
+
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		switch {
+		case newChannel.ChannelType() == "direct-tcpip":
+			go s.forward(newChannel)
+		case newChannel.ChannelType() == "session" && s.session != nil:
+			ch, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go s.session(ch, requests)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// forward connects a direct-tcpip channel to its target.
+func (s *sshServer) forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	addr := net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port)))
+	s.mu.Lock()
+	s.forwarded = append(s.forwarded, addr)
+	s.mu.Unlock()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(ch, conn)
+		ch.Close()
+	}()
+	io.Copy(conn, ch)
+	conn.Close()
+}
+
+// connection returns a connection to the server logging in with password.
+func (s *sshServer) connection(t *testing.T, password string, opts ...Option) *SSHConnModel {
+	t.Helper()
+	host, port, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{WithCredentials("admin", password), WithPort(uint16(p))}, opts...)
+	c, err := NewConnection(host, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// closeConnections drops the TCP connections of all clients.
+func (s *sshServer) closeConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *sshServer) state() (logins int, forwarded []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins, append([]string(nil), s.forwarded...)
+}
+
+// stalledServer accepts TCP connections and never answers, like a device hanging in the
+// SSH handshake.
+func stalledServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns []net.Conn
+	var mu sync.Mutex
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestJumpHostChain(t *testing.T) {
+	jump1, jump2, device := newSSHServer(t, nil), newSSHServer(t, nil), newSSHServer(t, nil)
+	c := device.connection(t, "secret",
+		WithJumpHost(jump1.connection(t, "secret")),
+		WithJumpHost(jump2.connection(t, "secret")),
+	)
+
+	client, err := c.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer c.closeJumpHosts()
+
+	if logins, forwarded := jump1.state(); logins != 1 || len(forwarded) != 1 || forwarded[0] != jump2.addr {
+		t.Errorf("jump host 1: %d logins, forwarded to %q, want one login forwarding to %s", logins, forwarded, jump2.addr)
+	}
+	if logins, forwarded := jump2.state(); logins != 1 || len(forwarded) != 1 || forwarded[0] != device.addr {
+		t.Errorf("jump host 2: %d logins, forwarded to %q, want one login forwarding to %s", logins, forwarded, device.addr)
+	}
+	if logins, _ := device.state(); logins != 1 {
+		t.Errorf("device: %d logins, want 1", logins)
+	}
+	if len(c.jumpClients) != 2 {
+		t.Errorf("%d jump host clients tracked, want 2", len(c.jumpClients))
+	}
+}
+
+func TestJumpHostAuthFailure(t *testing.T) {
+	jump1, jump2, device := newSSHServer(t, nil), newSSHServer(t, nil), newSSHServer(t, nil)
+	c := device.connection(t, "secret",
+		WithJumpHost(jump1.connection(t, "secret")),
+		WithJumpHost(jump2.connection(t, "wrong")),
+	)
+
+	_, err := c.Dial()
+	var authErr *AuthError
+	if !errors.As(err, &authErr) || !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Dial() error = %v, want an AuthError", err)
+	}
+	if authErr.Host != jump2.addr {
+		t.Errorf("AuthError.Host = %s, want the jump host %s", authErr.Host, jump2.addr)
+	}
+	if c.isAuthFailure(err) {
+		t.Error("a jump host failure must not make the device credentials be retried")
+	}
+	if logins, _ := device.state(); logins != 0 || c.jumpClients != nil {
+		t.Errorf("device logins %d, jump host clients %d, want none after the failure", logins, len(c.jumpClients))
+	}
+}
+
+func TestJumpHostCredentialProvider(t *testing.T) {
+	jump, device := newSSHServer(t, nil), newSSHServer(t, nil)
+	c := device.connection(t, "secret",
+		WithJumpHost(jump.connection(t, "wrong", WithFallbackCredentials(Credential{Username: "admin", Password: "secret"}))),
+	)
+
+	client, err := c.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer c.closeJumpHosts()
+	if logins, forwarded := jump.state(); logins != 1 || len(forwarded) != 1 {
+		t.Errorf("jump host: %d logins, forwarded to %q, want the fallback credentials to log in", logins, forwarded)
+	}
+}
+
+func TestJumpHostCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		stalled string // "jump" or "device"
+	}{
+		{name: "jump host handshake", stalled: "jump"},
+		{name: "device handshake through the jump host", stalled: "device"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jump, device := newSSHServer(t, nil), newSSHServer(t, nil)
+			jumpConn, deviceAddr := jump.connection(t, "secret"), device.addr
+			if tt.stalled == "jump" {
+				jumpConn.Addr = stalledServer(t)
+			} else {
+				deviceAddr = stalledServer(t)
+			}
+			c := device.connection(t, "secret", WithJumpHost(jumpConn))
+			c.Addr = deviceAddr
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := c.DialContext(ctx)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("DialContext() error = %v, want the context error", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("DialContext() returned after %v, want it aborted with the context", elapsed)
+			}
+			if c.jumpClients != nil {
+				t.Errorf("%d jump host clients left open", len(c.jumpClients))
+			}
+		})
+	}
+}
+
+// closeRecorder is a Dialer whose connections report, when closed, whether the client
+// tunnelled through them was closed already.
+type closeRecorder struct {
+	inner      func() *ssh.Client
+	innerFirst atomic.Bool
+	closed     atomic.Bool
+}
+
+func (d *closeRecorder) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &recordedConn{Conn: conn, dialer: d}, nil
+}
+
+type recordedConn struct {
+	net.Conn
+	dialer *closeRecorder
+	once   sync.Once
+}
+
+func (c *recordedConn) Close() error {
+	c.once.Do(func() {
+		// A closed client fails the request; an open one still gets the reply over this
+		// connection.
+		_, _, err := c.dialer.inner().SendRequest("probe@netmigo", true, nil)
+		c.dialer.innerFirst.Store(err != nil)
+		c.dialer.closed.Store(true)
+	})
+	return c.Conn.Close()
+}
+
+func TestJumpHostDisconnect(t *testing.T) {
+	jump1, jump2 := newSSHServer(t, nil), newSSHServer(t, nil)
+	device := newSSHServer(t, func(ch ssh.Channel, reqs <-chan *ssh.Request) {
+		for req := range reqs {
+			req.Reply(req.Type == "pty-req" || req.Type == "shell", nil)
+		}
+	})
+
+	// The jump hosts get their handshake keys from an ssh-agent
+	socket := t.TempDir() + "/agent.sock"
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var agentConns atomic.Int32
+	go func() {
+		keyring := agent.NewKeyring()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			agentConns.Add(1)
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				agentConns.Add(-1)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	recorder := &closeRecorder{}
+	c := device.connection(t, "secret",
+		WithDialer(recorder),
+		WithJumpHost(jump1.connection(t, "secret", WithAgent())),
+		WithJumpHost(jump2.connection(t, "secret", WithAgent())),
+	)
+
+	for i := 0; i < 2; i++ {
+		// Reconnecting opens the agent again and must not leak the previous connections
+		var inner *ssh.Client
+		recorder.inner = func() *ssh.Client { return inner }
+		if err := c.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		inner = c.jumpClients[1]
+		if n := agentConns.Load(); n != 2 {
+			t.Errorf("%d ssh-agent connections while connected, want one per jump host", n)
+		}
+
+		c.Disconnect()
+		if !recorder.closed.Load() || !recorder.innerFirst.Load() {
+			t.Error("the outer jump host was closed before the inner one")
+		}
+		recorder.closed.Store(false)
+		deadline := time.Now().Add(time.Second)
+		for agentConns.Load() != 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := agentConns.Load(); n != 0 {
+			t.Fatalf("%d ssh-agent connections left open after Disconnect", n)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package netmigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// telnetNOP is sent as keepalive on telnet sessions.
+const telnetNOP = 241
+
+// WithKeepalive sends a keepalive every interval and declares the session broken after
+// maxMissed consecutive unanswered keepalives.
+func WithKeepalive(interval time.Duration, maxMissed int) Option {
+	return func(c *SSHConnModel) error {
+		if interval <= 0 {
+			return errors.New("keepalive interval must be positive")
+		}
+		if maxMissed < 1 {
+			maxMissed = 1
+		}
+		c.KeepaliveInterval = interval
+		c.KeepaliveMaxMissed = maxMissed
+		return nil
+	}
+}
+
+// WithReconnect lets the device drivers reconnect a broken session up to attempts times
+// before retrying the pending command.
+func WithReconnect(attempts int) Option {
+	return func(c *SSHConnModel) error {
+		if attempts < 0 {
+			return errors.New("reconnect attempts must not be negative")
+		}
+		c.ReconnectAttempts = attempts
+		return nil
+	}
+}
+
+// Alive reports whether the session is connected and has not been detected as broken.
+func (c *SSHConnModel) Alive() bool {
+	if c.Client == nil && c.telnet == nil {
+		return false
+	}
+	return !c.sessionLost()
+}
+
+// sessionLost reports whether the session was detected as broken or its output stream
+// ended while the session is open, as when the device closes the shell channel over a live
+// SSH connection. The stream is checked on the current reader, so that a reader left from
+// an earlier session cannot mark a new one.
+func (c *SSHConnModel) sessionLost() bool {
+	if c.broken.Load() {
+		return true
+	}
+	if c.session == nil && c.telnet == nil {
+		return false
+	}
+	r, ok := c.Reader.(*sessionReader)
+	return ok && r.ended()
+}
+
+// markBroken records that the session is no longer usable.
+func (c *SSHConnModel) markBroken(reason error) {
+	if c.broken.CompareAndSwap(false, true) {
+		log.Warnf("Session to %s is broken: %v", c.Addr, reason)
+	}
+}
+
+// startKeepalive watches the new session: the SSH client is monitored for closure and,
+// when KeepaliveInterval is set, keepalive@openssh.com requests (or telnet NOPs) are sent.
+func (c *SSHConnModel) startKeepalive() {
+	c.stopKeepalive()
+	c.broken.Store(false)
+
+	stop := make(chan struct{})
+	c.keepaliveStop = stop
+
+	if client := c.Client; client != nil {
+		go func() {
+			err := client.Wait()
+			select {
+			case <-stop:
+			default:
+				if err == nil {
+					err = errors.New("connection closed by remote host")
+				}
+				c.markBroken(err)
+			}
+		}()
+	}
+
+	if c.KeepaliveInterval <= 0 {
+		return
+	}
+
+	client, telnet := c.Client, c.telnet
+	interval, maxMissed := c.KeepaliveInterval, c.KeepaliveMaxMissed
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		missed := 0
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			var err error
+			if client != nil {
+				err = sendKeepalive(client, interval)
+			} else {
+				err = telnet.writeRaw([]byte{telnetIAC, telnetNOP})
+			}
+			if err == nil {
+				missed = 0
+				continue
+			}
+
+			missed++
+			log.Warnf("Keepalive to %s failed (%d/%d): %v", c.Addr, missed, maxMissed, err)
+			if missed >= maxMissed {
+				c.markBroken(fmt.Errorf("%d keepalives unanswered", missed))
+				if client != nil {
+					client.Close()
+				} else {
+					telnet.Close()
+				}
+				return
+			}
+		}
+	}()
+}
+
+// stopKeepalive stops the keepalive and session monitor goroutines.
+func (c *SSHConnModel) stopKeepalive() {
+	if c.keepaliveStop != nil {
+		close(c.keepaliveStop)
+		c.keepaliveStop = nil
+	}
+}
+
+// sendKeepalive sends a keepalive@openssh.com global request and waits up to timeout
+// for the answer. A refusal still proves the peer is alive.
+func sendKeepalive(client *ssh.Client, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return errors.New("keepalive timed out")
+	}
+}
+
+// Reconnect closes the current session, connects again the same way as the last Connect or
+// ConnectXterm call, attaches the console line again, see WithConsole, and re-runs the
+// platform preparation (prompt discovery, paging, ...).
+func (d *DeviceConnection) Reconnect() error {
+	return d.ReconnectContext(context.Background())
+}
+
+// ReconnectContext is like Reconnect but aborts when ctx is done.
+func (d *DeviceConnection) ReconnectContext(ctx context.Context) error {
+	if d.Connection == nil {
+		return errors.New("not connected to device, make sure to call .Connect() first")
+	}
+	d.reconnecting = true
+	defer func() { d.reconnecting = false }()
+
+	attempts := d.Connection.ReconnectAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		d.Connection.Disconnect()
+
+		log.Infof("Reconnecting to %s (attempt %d/%d)", d.Connection.Addr, attempt, attempts)
+		if d.xterm {
+			err = d.Connection.ConnectXtermContext(ctx)
+		} else {
+			err = d.Connection.ConnectContext(ctx)
+		}
+		if err == nil {
+			err = d.attachConsole(ctx)
+		}
+		if err == nil && d.prepare != nil {
+			err = d.prepare(ctx)
+		}
+		if err == nil {
+			log.Info("Reconnected successfully")
+			return nil
+		}
+
+		log.Warnf("Reconnect attempt %d failed: %v", attempt, err)
+		if ctx.Err() != nil {
+			return d.reconnectContextError(ctx, err)
+		}
+		if attempt < attempts {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-ctx.Done():
+				return d.reconnectContextError(ctx, err)
+			}
+		}
+	}
+	return fmt.Errorf("failed to reconnect after %d attempt(s): %w", attempts, err)
+}
+
+// reconnectContextError returns the ContextError of a reconnect aborted by ctx; err is the
+// failure of the last attempt, passed on when it is a ContextError already.
+func (d *DeviceConnection) reconnectContextError(ctx context.Context, err error) error {
+	var ctxErr *ContextError
+	if errors.As(err, &ctxErr) {
+		return err
+	}
+	return d.contextError(ctx, "reconnect", "")
+}
+
+// ensureConnected reconnects a lost session, when reconnecting is enabled. Within a
+// configuration transaction the session is not replaced, the caller restarts the transaction.
+func (d *DeviceConnection) ensureConnected(ctx context.Context) error {
+	if d.Connection == nil || d.reconnecting || !d.Connection.sessionLost() {
+		return nil
+	}
+	if d.Connection.ReconnectAttempts == 0 {
+		return fmt.Errorf("%w and reconnect is disabled", ErrSessionBroken)
+	}
+	if callRetry(ctx) == retryNever {
+		return fmt.Errorf("%w during a configuration transaction", ErrSessionBroken)
+	}
+	return d.ReconnectContext(ctx)
+}
+
+// canRetry reports whether a command that failed because the session was lost may be sent
+// again after a reconnect: reconnecting is enabled and ctx marks the call as safe to repeat.
+func (d *DeviceConnection) canRetry(ctx context.Context) bool {
+	return d.Connection != nil && d.Connection.ReconnectAttempts > 0 && !d.reconnecting &&
+		d.Connection.sessionLost() && callRetry(ctx) == retryCommand
+}
+
+// retryPolicy tells what a call may do when the session is lost.
+type retryPolicy int
+
+const (
+	retryBefore  retryPolicy = iota // reconnect a lost session before sending, the default
+	retryCommand                    // also send the command again when the session is lost during the call
+	retryNever                      // keep the session of a configuration transaction
+)
+
+type retryKey struct{}
+
+// ContextWithRetry returns a context that lets the calls made with it send their command
+// again, after reconnecting, when the session is lost during the call. Only use it for
+// commands that are safe to repeat, such as show commands; configuration calls never
+// repeat a command.
+func ContextWithRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, retryCommand)
+}
+
+// transactionContext returns a context for the steps of a configuration transaction: a lost
+// session is neither reconnected nor a command sent again, the error wraps ErrSessionBroken.
+func transactionContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryKey{}, retryNever)
+}
+
+// callRetry returns the retry policy of ctx.
+func callRetry(ctx context.Context) retryPolicy {
+	policy, _ := ctx.Value(retryKey{}).(retryPolicy)
+	return policy
+}
@@ -0,0 +1,264 @@
+package netmigo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeepaliveOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		option        Option
+		wantInterval  time.Duration
+		wantMaxMissed int
+		wantAttempts  int
+		wantErr       bool
+	}{
+		{name: "keepalive", option: WithKeepalive(10*time.Second, 3), wantInterval: 10 * time.Second, wantMaxMissed: 3},
+		{name: "at least one missed", option: WithKeepalive(time.Second, 0), wantInterval: time.Second, wantMaxMissed: 1},
+		{name: "zero interval", option: WithKeepalive(0, 3), wantErr: true},
+		{name: "reconnect", option: WithReconnect(2), wantAttempts: 2},
+		{name: "negative attempts", option: WithReconnect(-1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &SSHConnModel{}
+			if err := tt.option(c); (err != nil) != tt.wantErr {
+				t.Fatalf("option error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if c.KeepaliveInterval != tt.wantInterval || c.KeepaliveMaxMissed != tt.wantMaxMissed || c.ReconnectAttempts != tt.wantAttempts {
+				t.Errorf("got interval %v, max missed %d, attempts %d", c.KeepaliveInterval, c.KeepaliveMaxMissed, c.ReconnectAttempts)
+			}
+		})
+	}
+}
+
+func TestEnsureConnected(t *testing.T) {
+	tests := []struct {
+		name         string
+		broken       bool
+		ended        bool // output stream ended with the session still open
+		attempts     int
+		reconnecting bool
+		ctx          func(context.Context) context.Context
+		wantRetry    bool
+		wantErr      error
+	}{
+		{name: "healthy", attempts: 1},
+		{name: "broken without reconnect", broken: true, wantErr: ErrSessionBroken},
+		{name: "stream ended without reconnect", ended: true, wantErr: ErrSessionBroken},
+		{name: "broken while reconnecting", broken: true, attempts: 1, reconnecting: true},
+		{name: "broken with reconnect", broken: true, attempts: 1, wantErr: context.Canceled},
+		{name: "stream ended with reconnect", ended: true, attempts: 1, wantErr: context.Canceled},
+		{name: "broken with retry", broken: true, attempts: 1, ctx: ContextWithRetry, wantRetry: true, wantErr: context.Canceled},
+		{name: "broken in a transaction", broken: true, attempts: 1, ctx: transactionContext, wantErr: ErrSessionBroken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, _ := net.Pipe()
+			c := &SSHConnModel{ReconnectAttempts: tt.attempts, telnet: &telnetConn{conn: conn}, Reader: &sessionReader{}}
+			c.broken.Store(tt.broken)
+			if tt.ended {
+				c.Reader = &sessionReader{err: io.EOF}
+			}
+			d := &DeviceConnection{Connection: c, reconnecting: tt.reconnecting}
+
+			// A cancelled context stops a reconnect before it dials.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if tt.ctx != nil {
+				ctx = tt.ctx(ctx)
+			}
+			if got := d.canRetry(ctx); got != tt.wantRetry {
+				t.Errorf("canRetry() = %v, want %v", got, tt.wantRetry)
+			}
+			err := d.ensureConnected(ctx)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("ensureConnected() error = %v, want %v", err, tt.wantErr)
+			}
+			var ctxErr *ContextError
+			if errors.Is(tt.wantErr, context.Canceled) && !errors.As(err, &ctxErr) {
+				t.Errorf("ensureConnected() error = %v, want a ContextError", err)
+			}
+		})
+	}
+}
+
+// shellServer starts an SSH server with a shell that echoes each command followed by
+// "output of <command>" and the prompt "R1#". On the first session, the shell closes its
+// channel, or the whole connection, when it receives drop, if not empty.
+func shellServer(t *testing.T, drop, how string) (*sshServer, func() [][]string) {
+	t.Helper()
+	var server *sshServer
+	var mu sync.Mutex
+	var sessions [][]string // commands received, per session
+	server = newSSHServer(t, func(ch ssh.Channel, reqs <-chan *ssh.Request) {
+		defer ch.Close()
+		for req := range reqs {
+			req.Reply(req.Type == "pty-req" || req.Type == "shell", nil)
+			if req.Type == "shell" {
+				break
+			}
+		}
+		go ssh.DiscardRequests(reqs)
+
+		mu.Lock()
+		sessions = append(sessions, nil)
+		session := len(sessions) - 1
+		mu.Unlock()
+		lines := bufio.NewReader(ch)
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimSpace(line)
+			mu.Lock()
+			sessions[session] = append(sessions[session], command)
+			mu.Unlock()
+			if session == 0 && drop != "" && command == drop {
+				if how == "connection" {
+					server.closeConnections()
+				}
+				return
+			}
+			fmt.Fprintf(ch, "%s\r\noutput of %s\r\nR1#", command, command)
+		}
+	})
+	received := func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([][]string(nil), sessions...)
+	}
+	return server, received
+}
+
+// shellDevice connects a DeviceConnection to the shell of server, reconnecting once.
+func shellDevice(t *testing.T, server *sshServer) *DeviceConnection {
+	t.Helper()
+	d := &DeviceConnection{Connection: server.connection(t, "secret", WithReconnect(1)), Return: "\n"}
+	if err := d.Connection.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Connection.Disconnect)
+	return d
+}
+
+func TestSessionLoss(t *testing.T) {
+	tests := []struct {
+		name     string
+		how      string // "channel" or "connection"
+		ctx      func(context.Context) context.Context
+		want     [][]string
+		wantLost bool
+	}{
+		{
+			name: "channel closed, command retried",
+			how:  "channel",
+			ctx:  ContextWithRetry,
+			want: [][]string{{"show version"}, {"show version"}},
+		},
+		{
+			name: "connection closed, command retried",
+			how:  "connection",
+			ctx:  ContextWithRetry,
+			want: [][]string{{"show version"}, {"show version"}},
+		},
+		{
+			name:     "channel closed, command not repeated",
+			how:      "channel",
+			want:     [][]string{{"show version"}, {"show clock"}},
+			wantLost: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, received := shellServer(t, "show version", tt.how)
+			d := shellDevice(t, server)
+
+			ctx := context.Background()
+			if tt.ctx != nil {
+				ctx = tt.ctx(ctx)
+			}
+			out, err := d.SendCommandPatternContext(ctx, "show version", "R1#")
+			if tt.wantLost {
+				if !errors.Is(err, ErrSessionBroken) {
+					t.Fatalf("SendCommandPatternContext() error = %v, want ErrSessionBroken", err)
+				}
+				if d.Connection.Alive() {
+					t.Error("Alive() = true after the session was lost")
+				}
+				// The next call reconnects before sending
+				out, err = d.SendCommandPatternContext(ctx, "show clock", "R1#")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out, "output of ") {
+				t.Errorf("output = %q, want the command output", out)
+			}
+			if got := received(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sessions received %q, want %q", got, tt.want)
+			}
+			if logins, _ := server.state(); logins != 2 {
+				t.Errorf("%d logins, want a reconnect", logins)
+			}
+		})
+	}
+}
+
+func TestSendConfigSessionLoss(t *testing.T) {
+	server, received := shellServer(t, "commit", "channel")
+	d := shellDevice(t, server)
+
+	// Even with retries allowed, neither a configuration line nor the commit is repeated
+	// on a new session, nor is the session replaced during the transaction
+	_, err := sendConfig(ContextWithRetry(context.Background()), d, "R1#", []string{"configure terminal", "hostname R1"},
+		[]string{"abort"}, nil, "commit", "end")
+	if !errors.Is(err, ErrSessionBroken) {
+		t.Fatalf("sendConfig() error = %v, want ErrSessionBroken", err)
+	}
+	want := [][]string{{"configure terminal", "hostname R1", "commit"}}
+	if got := received(); !reflect.DeepEqual(got, want) {
+		t.Errorf("sessions received %q, want %q", got, want)
+	}
+	if logins, _ := server.state(); logins != 1 {
+		t.Errorf("%d logins, want no reconnect during the transaction", logins)
+	}
+
+	// The caller restarts the transaction on a new session
+	if _, err := sendConfig(context.Background(), d, "R1#", []string{"configure terminal", "hostname R1"},
+		[]string{"abort"}, nil, "commit", "end"); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, []string{"configure terminal", "hostname R1", "commit", "end"})
+	if got := received(); !reflect.DeepEqual(got, want) {
+		t.Errorf("sessions received %q, want %q", got, want)
+	}
+}
+
+func TestAlive(t *testing.T) {
+	c := &SSHConnModel{}
+	if c.Alive() {
+		t.Error("Alive() = true before connecting")
+	}
+	c.telnet = &telnetConn{}
+	if !c.Alive() {
+		t.Error("Alive() = false on a connected session")
+	}
+	c.markBroken(errors.New("keepalive unanswered"))
+	if c.Alive() {
+		t.Error("Alive() = true on a broken session")
+	}
+}
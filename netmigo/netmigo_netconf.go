@@ -0,0 +1,511 @@
+package netmigo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// NETCONF base capabilities and framing markers (RFC 6241, RFC 6242).
+const (
+	NetconfBase10 = "urn:ietf:params:netconf:base:1.0"
+	NetconfBase11 = "urn:ietf:params:netconf:base:1.1"
+
+	netconfNamespace = "urn:ietf:params:xml:ns:netconf:base:1.0"
+	netconfEOM       = "]]>]]>"
+)
+
+// NetconfSession is a NETCONF session running in the "netconf" SSH subsystem. RPCs are
+// serialized, a session runs one at a time.
+type NetconfSession struct {
+	SessionID    string
+	Capabilities []string // advertised by the device
+
+	mu          sync.Mutex
+	session     *ssh.Session
+	closeClient func()
+	writer      io.WriteCloser
+	reader      *bufio.Reader
+	chunked     bool // base:1.1 chunked framing, negotiated in the hello exchange
+	messageID   uint64
+}
+
+// RPCError is an rpc-error returned by the device. Info holds the raw content of the
+// error-info element.
+type RPCError struct {
+	Type     string
+	Tag      string
+	Severity string
+	AppTag   string
+	Path     string
+	Message  string
+	Info     string
+}
+
+func (e *RPCError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = e.Tag
+	}
+	if e.Path != "" {
+		return fmt.Sprintf("netconf %s error %s: %s (path %s)", e.Type, e.Tag, message, e.Path)
+	}
+	return fmt.Sprintf("netconf %s error %s: %s", e.Type, e.Tag, message)
+}
+
+func (e *RPCError) Is(target error) bool {
+	return target == ErrCommandRejected
+}
+
+// RPCReply is a parsed rpc-reply. Data holds the content of the data element for get
+// and get-config; Raw the whole reply.
+type RPCReply struct {
+	MessageID string
+	OK        bool
+	Data      string
+	Errors    []RPCError
+	Raw       string
+}
+
+type netconfReply struct {
+	XMLName   xml.Name  `xml:"rpc-reply"`
+	MessageID string    `xml:"message-id,attr"`
+	OK        *struct{} `xml:"ok"`
+	Data      *innerXML `xml:"data"`
+	Errors    []struct {
+		Type     string   `xml:"error-type"`
+		Tag      string   `xml:"error-tag"`
+		Severity string   `xml:"error-severity"`
+		AppTag   string   `xml:"error-app-tag"`
+		Path     string   `xml:"error-path"`
+		Message  string   `xml:"error-message"`
+		Info     innerXML `xml:"error-info"`
+	} `xml:"rpc-error"`
+}
+
+type innerXML struct {
+	Content string `xml:",innerxml"`
+}
+
+type netconfHello struct {
+	XMLName      xml.Name `xml:"hello"`
+	Capabilities []string `xml:"capabilities>capability"`
+	SessionID    string   `xml:"session-id"`
+}
+
+// NewNetconfSession opens the "netconf" subsystem on the device SSH client and exchanges
+// hellos. Devices that only serve NETCONF on port 830 need a connection built with
+// WithPort(830).
+func (d *DeviceConnection) NewNetconfSession() (*NetconfSession, error) {
+	return d.NewNetconfSessionContext(context.Background())
+}
+
+// NewNetconfSessionContext is like NewNetconfSession but aborts when ctx is done.
+func (d *DeviceConnection) NewNetconfSessionContext(ctx context.Context) (*NetconfSession, error) {
+	client, closeClient, err := d.sshClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		closeClient()
+		return nil, fmt.Errorf("failed to open netconf channel: %w", err)
+	}
+	writer, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		closeClient()
+		return nil, err
+	}
+	reader, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		closeClient()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("netconf"); err != nil {
+		session.Close()
+		closeClient()
+		return nil, fmt.Errorf("failed to start netconf subsystem: %w", err)
+	}
+
+	s := &NetconfSession{
+		session:     session,
+		closeClient: closeClient,
+		writer:      writer,
+		reader:      bufio.NewReader(reader),
+	}
+	if err := s.hello(ctx); err != nil {
+		s.close()
+		return nil, err
+	}
+	log.Infof("NETCONF session %s established, chunked framing: %t", s.SessionID, s.chunked)
+	return s, nil
+}
+
+// hello sends the client capabilities and reads those of the device.
+func (s *NetconfSession) hello(ctx context.Context) error {
+	hello := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<hello xmlns="` + netconfNamespace + `"><capabilities>` +
+		`<capability>` + NetconfBase10 + `</capability>` +
+		`<capability>` + NetconfBase11 + `</capability>` +
+		`</capabilities></hello>`
+
+	var message string
+	err := s.withContext(ctx, "netconf hello", func() error {
+		if err := s.writeMessage(hello); err != nil {
+			return err
+		}
+		var err error
+		message, err = s.readMessage()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var serverHello netconfHello
+	if err := xml.Unmarshal([]byte(message), &serverHello); err != nil {
+		return fmt.Errorf("failed to parse netconf hello: %w", err)
+	}
+	s.SessionID = serverHello.SessionID
+	for _, capability := range serverHello.Capabilities {
+		s.Capabilities = append(s.Capabilities, strings.TrimSpace(capability))
+	}
+	if !s.HasCapability(NetconfBase10) && !s.HasCapability(NetconfBase11) {
+		return errors.New("device does not advertise a netconf base capability")
+	}
+	// Framing switches after the hello exchange when both sides speak base:1.1.
+	s.chunked = s.HasCapability(NetconfBase11)
+	return nil
+}
+
+// HasCapability reports whether the device advertised a capability starting with prefix,
+// which allows matching URIs regardless of their parameters.
+func (s *NetconfSession) HasCapability(prefix string) bool {
+	for _, capability := range s.Capabilities {
+		if strings.HasPrefix(capability, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RPC sends operation, the XML content of an rpc element, and returns the parsed reply.
+// rpc-errors of severity "error" are returned as *RPCError values, joined when there are
+// several; the reply is returned alongside.
+func (s *NetconfSession) RPC(operation string) (*RPCReply, error) {
+	return s.RPCContext(context.Background(), operation)
+}
+
+// RPCContext is like RPC but aborts when ctx is done. The session is closed in that case,
+// since the device may still send the reply.
+func (s *NetconfSession) RPCContext(ctx context.Context, operation string) (*RPCReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.session == nil {
+		return nil, errors.New("netconf session is closed")
+	}
+	s.messageID++
+	messageID := strconv.FormatUint(s.messageID, 10)
+	request := `<rpc message-id="` + messageID + `" xmlns="` + netconfNamespace + `">` + operation + `</rpc>`
+
+	var message string
+	err := s.withContext(ctx, "netconf rpc", func() error {
+		if err := s.writeMessage(request); err != nil {
+			return err
+		}
+		var err error
+		message, err = s.readMessage()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed netconfReply
+	if err := xml.Unmarshal([]byte(message), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse netconf reply: %w", err)
+	}
+	if parsed.MessageID != "" && parsed.MessageID != messageID {
+		return nil, fmt.Errorf("netconf reply for message %s, expected %s", parsed.MessageID, messageID)
+	}
+
+	reply := &RPCReply{
+		MessageID: parsed.MessageID,
+		OK:        parsed.OK != nil,
+		Raw:       message,
+	}
+	if parsed.Data != nil {
+		reply.Data = parsed.Data.Content
+	}
+	for _, e := range parsed.Errors {
+		reply.Errors = append(reply.Errors, RPCError{
+			Type:     strings.TrimSpace(e.Type),
+			Tag:      strings.TrimSpace(e.Tag),
+			Severity: strings.TrimSpace(e.Severity),
+			AppTag:   strings.TrimSpace(e.AppTag),
+			Path:     strings.TrimSpace(e.Path),
+			Message:  strings.TrimSpace(e.Message),
+			Info:     e.Info.Content,
+		})
+	}
+
+	var errs []error
+	for i := range reply.Errors {
+		if reply.Errors[i].Severity == "warning" {
+			log.Warn(&reply.Errors[i])
+			continue
+		}
+		errs = append(errs, &reply.Errors[i])
+	}
+	return reply, errors.Join(errs...)
+}
+
+// withContext runs a blocking exchange, closing the session if ctx is done meanwhile.
+func (s *NetconfSession) withContext(ctx context.Context, op string, exchange func() error) error {
+	if ctx.Err() != nil {
+		return &ContextError{Op: op, Err: ctx.Err()}
+	}
+	session := s.session
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	err := exchange()
+	if !stop() {
+		s.close()
+		err := &ContextError{Op: op, Err: ctx.Err()}
+		log.Error(err)
+		return err
+	}
+	return err
+}
+
+// writeMessage frames and sends one message.
+func (s *NetconfSession) writeMessage(message string) error {
+	var err error
+	if s.chunked {
+		_, err = fmt.Fprintf(s.writer, "\n#%d\n%s\n##\n", len(message), message)
+	} else {
+		_, err = io.WriteString(s.writer, message+netconfEOM)
+	}
+	return err
+}
+
+// readMessage reads one framed message.
+func (s *NetconfSession) readMessage() (string, error) {
+	if s.chunked {
+		return s.readChunked()
+	}
+	return s.readEOM()
+}
+
+// readEOM reads a base:1.0 message, terminated by "]]>]]>".
+func (s *NetconfSession) readEOM() (string, error) {
+	var message bytes.Buffer
+	for {
+		part, err := s.reader.ReadString('>')
+		message.WriteString(part)
+		if bytes.HasSuffix(message.Bytes(), []byte(netconfEOM)) {
+			return strings.TrimSpace(strings.TrimSuffix(message.String(), netconfEOM)), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("netconf read failed: %w", err)
+		}
+	}
+}
+
+// readChunked reads a base:1.1 message: chunks of "\n#<size>\n<data>" ended by "\n##\n".
+func (s *NetconfSession) readChunked() (string, error) {
+	var message bytes.Buffer
+	for {
+		header, err := s.reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("netconf read failed: %w", err)
+		}
+		if strings.TrimSpace(header) == "" {
+			// Line feed opening the chunk header.
+			continue
+		}
+		if !strings.HasPrefix(header, "#") {
+			return "", fmt.Errorf("netconf framing error: unexpected %q", header)
+		}
+
+		header = strings.TrimSpace(header[1:])
+		if header == "#" {
+			return message.String(), nil
+		}
+		size, err := strconv.ParseUint(header, 10, 32)
+		if err != nil || size == 0 {
+			return "", fmt.Errorf("netconf framing error: invalid chunk size %q", header)
+		}
+		if _, err := io.CopyN(&message, s.reader, int64(size)); err != nil {
+			return "", fmt.Errorf("netconf read failed: %w", err)
+		}
+	}
+}
+
+// Close ends the session with close-session and releases the channel.
+func (s *NetconfSession) Close() error {
+	_, err := s.RPC("<close-session/>")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+	return err
+}
+
+func (s *NetconfSession) close() {
+	if s.session != nil {
+		s.session.Close()
+		s.session = nil
+		s.closeClient()
+	}
+}
+
+// datastore renders a datastore name ("running", "candidate", "startup") as an element.
+func datastore(name string) string {
+	return "<" + name + "/>"
+}
+
+// Get retrieves state and configuration data, optionally restricted by a subtree filter.
+func (s *NetconfSession) Get(filter string) (string, error) {
+	return s.GetContext(context.Background(), filter)
+}
+
+// GetContext is like Get but aborts when ctx is done.
+func (s *NetconfSession) GetContext(ctx context.Context, filter string) (string, error) {
+	reply, err := s.RPCContext(ctx, "<get>"+subtreeFilter(filter)+"</get>")
+	if err != nil {
+		return "", err
+	}
+	return reply.Data, nil
+}
+
+// GetConfig retrieves the configuration of the source datastore, optionally restricted by
+// a subtree filter.
+func (s *NetconfSession) GetConfig(source, filter string) (string, error) {
+	return s.GetConfigContext(context.Background(), source, filter)
+}
+
+// GetConfigContext is like GetConfig but aborts when ctx is done.
+func (s *NetconfSession) GetConfigContext(ctx context.Context, source, filter string) (string, error) {
+	reply, err := s.RPCContext(ctx, "<get-config><source>"+datastore(source)+"</source>"+subtreeFilter(filter)+"</get-config>")
+	if err != nil {
+		return "", err
+	}
+	return reply.Data, nil
+}
+
+func subtreeFilter(filter string) string {
+	if filter == "" {
+		return ""
+	}
+	return `<filter type="subtree">` + filter + `</filter>`
+}
+
+// EditConfig loads config, the content of the config element, into the target datastore.
+// defaultOperation ("merge", "replace", "none") may be empty to keep the device default.
+func (s *NetconfSession) EditConfig(target, defaultOperation, config string) error {
+	return s.EditConfigContext(context.Background(), target, defaultOperation, config)
+}
+
+// EditConfigContext is like EditConfig but aborts when ctx is done.
+func (s *NetconfSession) EditConfigContext(ctx context.Context, target, defaultOperation, config string) error {
+	operation := "<edit-config><target>" + datastore(target) + "</target>"
+	if defaultOperation != "" {
+		operation += "<default-operation>" + defaultOperation + "</default-operation>"
+	}
+	operation += "<config>" + config + "</config></edit-config>"
+	_, err := s.RPCContext(ctx, operation)
+	return err
+}
+
+// Lock locks the target datastore.
+func (s *NetconfSession) Lock(target string) error {
+	return s.LockContext(context.Background(), target)
+}
+
+// LockContext is like Lock but aborts when ctx is done.
+func (s *NetconfSession) LockContext(ctx context.Context, target string) error {
+	_, err := s.RPCContext(ctx, "<lock><target>"+datastore(target)+"</target></lock>")
+	return err
+}
+
+// Unlock releases a lock taken with Lock.
+func (s *NetconfSession) Unlock(target string) error {
+	return s.UnlockContext(context.Background(), target)
+}
+
+// UnlockContext is like Unlock but aborts when ctx is done.
+func (s *NetconfSession) UnlockContext(ctx context.Context, target string) error {
+	_, err := s.RPCContext(ctx, "<unlock><target>"+datastore(target)+"</target></unlock>")
+	return err
+}
+
+// Validate validates the source datastore, usually "candidate".
+func (s *NetconfSession) Validate(source string) error {
+	return s.ValidateContext(context.Background(), source)
+}
+
+// ValidateContext is like Validate but aborts when ctx is done.
+func (s *NetconfSession) ValidateContext(ctx context.Context, source string) error {
+	_, err := s.RPCContext(ctx, "<validate><source>"+datastore(source)+"</source></validate>")
+	return err
+}
+
+// Commit commits the candidate datastore. It also confirms a pending confirmed commit.
+func (s *NetconfSession) Commit() error {
+	return s.CommitContext(context.Background())
+}
+
+// CommitContext is like Commit but aborts when ctx is done.
+func (s *NetconfSession) CommitContext(ctx context.Context) error {
+	return s.commit(ctx, "<commit/>")
+}
+
+// ConfirmedCommit commits the candidate datastore and rolls it back unless a confirming
+// Commit follows within timeout.
+func (s *NetconfSession) ConfirmedCommit(timeout time.Duration) error {
+	return s.ConfirmedCommitContext(context.Background(), timeout)
+}
+
+// ConfirmedCommitContext is like ConfirmedCommit but aborts when ctx is done.
+func (s *NetconfSession) ConfirmedCommitContext(ctx context.Context, timeout time.Duration) error {
+	operation := "<commit><confirmed/>"
+	if timeout > 0 {
+		operation += "<confirm-timeout>" + strconv.Itoa(int(timeout.Seconds())) + "</confirm-timeout>"
+	}
+	operation += "</commit>"
+	return s.commit(ctx, operation)
+}
+
+// commit runs a commit operation, returning rpc-errors in a CommitError.
+func (s *NetconfSession) commit(ctx context.Context, operation string) error {
+	reply, err := s.RPCContext(ctx, operation)
+	if err != nil && reply != nil {
+		return &CommitError{Output: reply.Raw, Err: err}
+	}
+	return err
+}
+
+// DiscardChanges reverts the candidate datastore to the running configuration.
+func (s *NetconfSession) DiscardChanges() error {
+	return s.DiscardChangesContext(context.Background())
+}
+
+// DiscardChangesContext is like DiscardChanges but aborts when ctx is done.
+func (s *NetconfSession) DiscardChangesContext(ctx context.Context) error {
+	_, err := s.RPCContext(ctx, "<discard-changes/>")
+	return err
+}
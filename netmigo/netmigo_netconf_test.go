@@ -0,0 +1,216 @@
+package netmigo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNetconfReadEOM(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		want    string
+		wantErr bool
+	}{
+		{name: "message", stream: "<hello/>]]>]]>", want: "<hello/>"},
+		{name: "markers inside content", stream: "<a>]]></a>\n<b>]]>]</b>]]>]]>", want: "<a>]]></a>\n<b>]]>]</b>"},
+		{name: "surrounding white space", stream: "\n  <ok/>\n]]>]]>", want: "<ok/>"},
+		{name: "next message stays buffered", stream: "<a/>]]>]]><b/>]]>]]>", want: "<a/>"},
+		{name: "truncated", stream: "<rpc-reply>]]>", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &NetconfSession{reader: bufio.NewReader(strings.NewReader(tt.stream))}
+			got, err := s.readMessage()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetconfReadChunked(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		want    string
+		wantErr bool
+	}{
+		{name: "one chunk", stream: "\n#8\n<hello/>\n##\n", want: "<hello/>"},
+		{name: "several chunks", stream: "\n#4\n<rpc\n#6\n-reply\n#3\n/>\n\n##\n", want: "<rpc-reply/>\n"},
+		{name: "chunk with framing characters", stream: "\n#8\n\n##\n#3\n<\n##\n", want: "\n##\n#3\n<"},
+		{name: "zero size", stream: "\n#0\n\n##\n", wantErr: true},
+		{name: "invalid size", stream: "\n#x\n", wantErr: true},
+		{name: "missing header", stream: "<ok/>\n", wantErr: true},
+		{name: "short chunk", stream: "\n#10\n<ok/>", wantErr: true},
+		{name: "missing end of chunks", stream: "\n#5\n<ok/>", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &NetconfSession{reader: bufio.NewReader(strings.NewReader(tt.stream)), chunked: true}
+			got, err := s.readMessage()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// nopWriteCloser adds a no-op Close to a writer.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestNetconfWriteMessage(t *testing.T) {
+	for _, chunked := range []bool{false, true} {
+		var out bytes.Buffer
+		s := &NetconfSession{writer: nopWriteCloser{&out}, chunked: chunked}
+		if err := s.writeMessage("<get/>"); err != nil {
+			t.Fatal(err)
+		}
+		want := "<get/>]]>]]>"
+		if chunked {
+			want = "\n#6\n<get/>\n##\n"
+		}
+		if out.String() != want {
+			t.Errorf("writeMessage() chunked %t wrote %q, want %q", chunked, out.String(), want)
+		}
+
+		// What is written reads back as the same message
+		s.reader = bufio.NewReader(&out)
+		if got, err := s.readMessage(); err != nil || got != "<get/>" {
+			t.Errorf("readMessage() chunked %t = %q, %v", chunked, got, err)
+		}
+	}
+}
+
+var netconfMessageID = regexp.MustCompile(`message-id="([^"]*)"`)
+
+// netconfDevice returns a session talking to a device that answers the hello with
+// capabilities and each rpc with reply, whose "%ID%" is replaced by the message-id.
+func netconfDevice(t *testing.T, capabilities []string, reply func(rpc string) string) *NetconfSession {
+	t.Helper()
+	toDevice, clientOut := io.Pipe()
+	clientIn, fromDevice := io.Pipe()
+	t.Cleanup(func() {
+		clientOut.Close()
+		fromDevice.Close()
+	})
+
+	device := &NetconfSession{writer: fromDevice, reader: bufio.NewReader(toDevice)}
+	go func() {
+		if _, err := device.readMessage(); err != nil {
+			return
+		}
+		hello := "<hello xmlns=\"" + netconfNamespace + "\"><capabilities>"
+		for _, capability := range capabilities {
+			hello += "<capability>" + capability + "</capability>"
+		}
+		device.writeMessage(hello + "</capabilities><session-id>42</session-id></hello>")
+		device.chunked = contains(capabilities, NetconfBase11)
+		for {
+			rpc, err := device.readMessage()
+			if err != nil {
+				return
+			}
+			id := netconfMessageID.FindStringSubmatch(rpc)[1]
+			device.writeMessage(strings.ReplaceAll(reply(rpc), "%ID%", id))
+		}
+	}()
+
+	// The session is never closed, so the zero SSH session is not used
+	return &NetconfSession{session: &ssh.Session{}, writer: clientOut, reader: bufio.NewReader(clientIn)}
+}
+
+func TestNetconfHello(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities []string
+		wantChunked  bool
+		wantErr      bool
+	}{
+		{name: "base 1.1", capabilities: []string{NetconfBase10, NetconfBase11, "urn:ietf:params:netconf:capability:candidate:1.0"}, wantChunked: true},
+		{name: "base 1.0", capabilities: []string{NetconfBase10}},
+		{name: "no base", capabilities: []string{"urn:ietf:params:netconf:capability:candidate:1.0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := netconfDevice(t, tt.capabilities, func(string) string {
+				return `<rpc-reply message-id="%ID%"><ok/></rpc-reply>`
+			})
+			err := s.hello(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hello() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if s.chunked != tt.wantChunked || s.SessionID != "42" {
+				t.Errorf("session %s chunked %t, want 42 chunked %t", s.SessionID, s.chunked, tt.wantChunked)
+			}
+			// The first rpc uses the negotiated framing
+			if err := s.Lock("candidate"); err != nil {
+				t.Errorf("Lock() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestNetconfRPC(t *testing.T) {
+	s := netconfDevice(t, []string{NetconfBase11}, func(rpc string) string {
+		switch {
+		case strings.Contains(rpc, "<get-config>"):
+			return `<rpc-reply message-id="%ID%" xmlns="` + netconfNamespace + `"><data><system><host-name>r1</host-name></system></data></rpc-reply>`
+		case strings.Contains(rpc, "<commit/>"):
+			return `<rpc-reply message-id="%ID%"><rpc-error><error-type>application</error-type><error-tag>operation-failed</error-tag>` +
+				`<error-severity>error</error-severity><error-path>/system</error-path><error-message>missing mandatory leaf</error-message>` +
+				`</rpc-error></rpc-reply>`
+		case strings.Contains(rpc, "<validate>"):
+			return `<rpc-reply message-id="%ID%"><rpc-error><error-type>application</error-type><error-tag>operation-failed</error-tag>` +
+				`<error-severity>warning</error-severity><error-message>deprecated leaf</error-message></rpc-error><ok/></rpc-reply>`
+		default:
+			return `<rpc-reply message-id="999"><ok/></rpc-reply>`
+		}
+	})
+	if err := s.hello(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := s.GetConfig("running", "<system/>")
+	if err != nil || data != "<system><host-name>r1</host-name></system>" {
+		t.Errorf("GetConfig() = %q, %v", data, err)
+	}
+
+	// Warnings are logged, not returned
+	if err := s.Validate("candidate"); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	err = s.Commit()
+	var commitErr *CommitError
+	var rpcErr *RPCError
+	if !errors.As(err, &commitErr) || !errors.As(err, &rpcErr) || !errors.Is(err, ErrCommitFailed) {
+		t.Fatalf("Commit() error = %v, want a CommitError holding the rpc-error", err)
+	}
+	if rpcErr.Tag != "operation-failed" || rpcErr.Path != "/system" || rpcErr.Message != "missing mandatory leaf" {
+		t.Errorf("rpc-error = %+v", rpcErr)
+	}
+
+	if err := s.DiscardChanges(); err == nil || !strings.Contains(err.Error(), "message 999") {
+		t.Errorf("DiscardChanges() error = %v, want the message-id mismatch", err)
+	}
+}
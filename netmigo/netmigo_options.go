@@ -0,0 +1,285 @@
+package netmigo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option configures an SSHConnModel built by NewConnection.
+type Option func(*SSHConnModel) error
+
+// Terminal describes the pseudo terminal requested for the interactive shell.
+type Terminal struct {
+	Type   string
+	Width  int
+	Height int
+}
+
+// Default terminals used by Connect and ConnectXterm when no Terminal is configured.
+var (
+	defaultTerminal      = Terminal{Type: "vt100", Width: 200, Height: 0}
+	defaultXtermTerminal = Terminal{Type: "xterm", Width: 100, Height: 80}
+)
+
+// defaultPorts maps each supported protocol to the port used when none is given.
+var defaultPorts = map[string]uint16{
+	"ssh":    22,
+	"telnet": 23,
+}
+
+// NewConnection builds a connection to host from functional options. Host may be a
+// hostname, an IPv4 address or an IPv6 literal, with or without brackets.
+//
+//	conn, err := NewConnection("2001:db8::1",
+//		WithCredentials("admin", "admin"),
+//		WithPort(830),
+//		WithConnectTimeout(10*time.Second),
+//	)
+//
+// With WithSSHConfig, host may also be an alias of an OpenSSH client configuration file.
+func NewConnection(host string, opts ...Option) (*SSHConnModel, error) {
+	c := &SSHConnModel{
+		Timeout:  6, // Default timeout is 6 seconds
+		Protocol: "ssh",
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.sshConfig != nil {
+		resolved, err := c.applySSHConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		host = resolved
+	}
+
+	port, ok := defaultPorts[c.Protocol]
+	if !ok {
+		return nil, errors.New("unsupported protocol: " + c.Protocol)
+	}
+	if c.port != 0 {
+		port = c.port
+	}
+	c.Addr = JoinHostPort(host, port)
+	return c, nil
+}
+
+// JoinHostPort builds a "host:port" address, bracketing IPv6 literals.
+func JoinHostPort(host string, port uint16) string {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// WithPort sets the TCP port. The protocol default (22 for ssh, 23 for telnet) is used otherwise.
+func WithPort(port uint16) Option {
+	return func(c *SSHConnModel) error {
+		c.port = port
+		return nil
+	}
+}
+
+// WithProtocol selects the transport, "ssh" or "telnet".
+func WithProtocol(protocol string) Option {
+	return func(c *SSHConnModel) error {
+		c.Protocol = protocol
+		return nil
+	}
+}
+
+// WithCredentials sets the username and password.
+func WithCredentials(username, password string) Option {
+	return func(c *SSHConnModel) error {
+		c.Username = username
+		c.Password = password
+		return nil
+	}
+}
+
+// WithPrivateKey adds a private key file, optionally protected by a passphrase.
+func WithPrivateKey(path, passphrase string) Option {
+	return func(c *SSHConnModel) error {
+		c.AddPrivateKey(path, passphrase)
+		return nil
+	}
+}
+
+// WithCertificate adds a private key file together with its OpenSSH user certificate.
+func WithCertificate(keyPath, certPath, passphrase string) Option {
+	return func(c *SSHConnModel) error {
+		c.AddCertificate(keyPath, certPath, passphrase)
+		return nil
+	}
+}
+
+// WithAgent offers the keys held by the ssh-agent on SSH_AUTH_SOCK.
+func WithAgent() Option {
+	return func(c *SSHConnModel) error {
+		c.EnableAgent()
+		return nil
+	}
+}
+
+// WithKnownHosts enables strict host key checking against a known_hosts file.
+func WithKnownHosts(path string) Option {
+	return func(c *SSHConnModel) error {
+		c.SetKnownHosts(path)
+		return nil
+	}
+}
+
+// WithHostKeyFingerprints pins the device host key to SHA256 fingerprints.
+func WithHostKeyFingerprints(fingerprints ...string) Option {
+	return func(c *SSHConnModel) error {
+		c.SetHostKeyFingerprints(fingerprints...)
+		return nil
+	}
+}
+
+// WithTrustOnFirstUse records the device host key on first contact.
+func WithTrustOnFirstUse(path string) Option {
+	return func(c *SSHConnModel) error {
+		c.SetTrustOnFirstUse(path)
+		return nil
+	}
+}
+
+// WithJumpHost appends a bastion to the jump host chain.
+func WithJumpHost(jump *SSHConnModel) Option {
+	return func(c *SSHConnModel) error {
+		if jump == nil {
+			return errors.New("jump host must not be nil")
+		}
+		c.AddJumpHost(jump)
+		return nil
+	}
+}
+
+// WithConnectTimeout bounds the TCP connect and SSH handshake.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(c *SSHConnModel) error {
+		if timeout <= 0 {
+			return errors.New("connect timeout must be positive")
+		}
+		c.ConnectTimeout = timeout
+		return nil
+	}
+}
+
+// WithReadTimeout bounds every wait for a prompt or pattern.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *SSHConnModel) error {
+		if timeout <= 0 {
+			return errors.New("read timeout must be positive")
+		}
+		c.ReadTimeout = timeout
+		return nil
+	}
+}
+
+// WithCommandTimeout sets the timeout used by the vendor SendCommand methods when they
+// are called with a zero timeout.
+func WithCommandTimeout(timeout time.Duration) Option {
+	return func(c *SSHConnModel) error {
+		if timeout <= 0 {
+			return errors.New("command timeout must be positive")
+		}
+		c.CommandTimeout = timeout
+		return nil
+	}
+}
+
+// WithTerminal sets the pseudo terminal type and size used by Connect and ConnectXterm.
+func WithTerminal(termType string, width, height int) Option {
+	return func(c *SSHConnModel) error {
+		if termType == "" {
+			return errors.New("terminal type must not be empty")
+		}
+		if width < 0 || height < 0 {
+			return errors.New("terminal size must not be negative")
+		}
+		c.Terminal = &Terminal{Type: termType, Width: width, Height: height}
+		return nil
+	}
+}
+
+// WithEcho enables or disables the remote echo of the pseudo terminal. Connect echoes
+// and ConnectXterm does not, unless this option is given.
+func WithEcho(echo bool) Option {
+	return func(c *SSHConnModel) error {
+		c.echo = &echo
+		return nil
+	}
+}
+
+// connectTimeout returns the timeout for the TCP connect and handshake.
+func (c *SSHConnModel) connectTimeout() time.Duration {
+	if c.ConnectTimeout > 0 {
+		return c.ConnectTimeout
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// readTimeout returns the timeout for a single wait on a prompt or pattern.
+func (c *SSHConnModel) readTimeout() time.Duration {
+	if c.ReadTimeout > 0 {
+		return c.ReadTimeout
+	}
+	return 4 * time.Second
+}
+
+// commandTimeout returns the default timeout for a vendor command.
+func (c *SSHConnModel) commandTimeout() time.Duration {
+	if c.CommandTimeout > 0 {
+		return c.CommandTimeout
+	}
+	return 10 * time.Second
+}
+
+type callTimeoutKey struct{}
+
+// ContextWithCallTimeout returns a context that overrides the read and command timeouts of
+// the connection for the calls made with it. Unlike a context deadline, it ends a single
+// wait with a TimeoutError and leaves the session usable.
+func ContextWithCallTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, callTimeoutKey{}, timeout)
+}
+
+// readTimeout returns the per call timeout of ctx, or the read timeout of the connection.
+func (d *DeviceConnection) readTimeout(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(callTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+		return timeout
+	}
+	return d.Connection.readTimeout()
+}
+
+// commandTimeout returns the per call timeout of ctx, or the command timeout of the
+// connection.
+func (d *DeviceConnection) commandTimeout(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(callTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+		return timeout
+	}
+	return d.Connection.commandTimeout()
+}
+
+// terminal returns the configured terminal, or fallback when none is set.
+func (c *SSHConnModel) terminal(fallback Terminal) Terminal {
+	if c.Terminal != nil {
+		return *c.Terminal
+	}
+	return fallback
+}
+
+// echoMode returns the configured echo mode, or fallback when none is set.
+func (c *SSHConnModel) echoMode(fallback bool) bool {
+	if c.echo != nil {
+		return *c.echo
+	}
+	return fallback
+}
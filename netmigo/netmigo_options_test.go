@@ -0,0 +1,83 @@
+package netmigo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewConnection(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		opts     []Option
+		wantAddr string
+		wantErr  bool
+	}{
+		{name: "hostname", host: "r1", wantAddr: "r1:22"},
+		{name: "ipv4 with port", host: "192.0.2.1", opts: []Option{WithPort(830)}, wantAddr: "192.0.2.1:830"},
+		{name: "ipv6", host: "2001:db8::1", wantAddr: "[2001:db8::1]:22"},
+		{name: "bracketed ipv6", host: "[2001:db8::1]", opts: []Option{WithPort(65535)}, wantAddr: "[2001:db8::1]:65535"},
+		{name: "telnet default port", host: "ts1", opts: []Option{WithProtocol("telnet")}, wantAddr: "ts1:23"},
+		{name: "telnet with port", host: "ts1", opts: []Option{WithProtocol("telnet"), WithPort(2003)}, wantAddr: "ts1:2003"},
+		{name: "unknown protocol", host: "r1", opts: []Option{WithProtocol("rsh")}, wantErr: true},
+		{name: "zero read timeout", host: "r1", opts: []Option{WithReadTimeout(0)}, wantErr: true},
+		{name: "negative connect timeout", host: "r1", opts: []Option{WithConnectTimeout(-time.Second)}, wantErr: true},
+		{name: "zero command timeout", host: "r1", opts: []Option{WithCommandTimeout(0)}, wantErr: true},
+		{name: "empty terminal type", host: "r1", opts: []Option{WithTerminal("", 80, 24)}, wantErr: true},
+		{name: "negative terminal size", host: "r1", opts: []Option{WithTerminal("xterm", -1, 24)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewConnection(tt.host, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewConnection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && c.Addr != tt.wantAddr {
+				t.Errorf("Addr = %s, want %s", c.Addr, tt.wantAddr)
+			}
+		})
+	}
+}
+
+func TestConnectionDefaults(t *testing.T) {
+	c, err := NewConnection("r1", WithCredentials("admin", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.connectTimeout() != 6*time.Second || c.readTimeout() != 4*time.Second || c.commandTimeout() != 10*time.Second {
+		t.Errorf("timeouts = %v, %v, %v, want 6s, 4s, 10s", c.connectTimeout(), c.readTimeout(), c.commandTimeout())
+	}
+	if c.terminal(defaultTerminal) != defaultTerminal || !c.echoMode(true) {
+		t.Errorf("terminal = %+v, echo %t, want the fallbacks", c.terminal(defaultTerminal), c.echoMode(true))
+	}
+
+	c, err = NewConnection("r1",
+		WithConnectTimeout(time.Second),
+		WithReadTimeout(2*time.Second),
+		WithCommandTimeout(3*time.Second),
+		WithTerminal("xterm", 132, 0),
+		WithEcho(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.connectTimeout() != time.Second || c.readTimeout() != 2*time.Second || c.commandTimeout() != 3*time.Second {
+		t.Errorf("timeouts = %v, %v, %v, want 1s, 2s, 3s", c.connectTimeout(), c.readTimeout(), c.commandTimeout())
+	}
+	if want := (Terminal{Type: "xterm", Width: 132}); c.terminal(defaultTerminal) != want || c.echoMode(true) {
+		t.Errorf("terminal = %+v, echo %t, want %+v without echo", c.terminal(defaultTerminal), c.echoMode(true), want)
+	}
+}
+
+func TestContextWithCallTimeout(t *testing.T) {
+	d := &DeviceConnection{Connection: &SSHConnModel{ReadTimeout: 2 * time.Second, CommandTimeout: 20 * time.Second}}
+	ctx := context.Background()
+	if d.readTimeout(ctx) != 2*time.Second || d.commandTimeout(ctx) != 20*time.Second {
+		t.Errorf("timeouts = %v, %v, want the connection ones", d.readTimeout(ctx), d.commandTimeout(ctx))
+	}
+	ctx = ContextWithCallTimeout(ctx, 90*time.Second)
+	if d.readTimeout(ctx) != 90*time.Second || d.commandTimeout(ctx) != 90*time.Second {
+		t.Errorf("timeouts = %v, %v, want the call timeout", d.readTimeout(ctx), d.commandTimeout(ctx))
+	}
+}
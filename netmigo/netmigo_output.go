@@ -0,0 +1,65 @@
+package netmigo
+
+import (
+	"regexp"
+	"strings"
+)
+
+// CommandResult is the output of a command. Output has the echoed command and the prompts
+// that followed it removed; Raw keeps the text as read from the device.
+type CommandResult struct {
+	Command string
+	Output  string
+	Raw     string
+}
+
+// echoPattern matches the echo of command at the start of a line, possibly after a prompt.
+// Terminals wrap long commands, so a line break, or the blank some of them print in its
+// place, may appear between any two characters.
+// Commands vary, so the pattern is not cached like the read patterns.
+func echoPattern(command string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString(`(?m)^[^\n]*?`)
+	for i, r := range command {
+		if i > 0 {
+			pattern.WriteString(`[ \t]*\n?`)
+		}
+		pattern.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	pattern.WriteString(`[ \t]*(?:\n|$)`)
+	return regexp.Compile(pattern.String())
+}
+
+// trimOutput removes from raw everything up to and including the echo of command, and the
+// trailing lines that are empty or contain one of prompts. Without an echo, as on terminals
+// that do not echo, only the trailing prompts are removed.
+func trimOutput(raw, command string, prompts ...string) string {
+	output := strings.ReplaceAll(normalizeNewlines(raw), "\r", "")
+	if strings.TrimSpace(command) != "" {
+		if re, err := echoPattern(command); err == nil {
+			if loc := re.FindStringIndex(output); loc != nil {
+				output = output[loc[1]:]
+			}
+		}
+	}
+
+	lines := strings.Split(output, "\n")
+	end := len(lines)
+	for end > 0 && isPromptLine(lines[end-1], prompts) {
+		end--
+	}
+	return strings.Join(lines[:end], "\n")
+}
+
+func isPromptLine(line string, prompts []string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true
+	}
+	for _, prompt := range prompts {
+		if prompt = strings.TrimSpace(prompt); prompt != "" && strings.Contains(line, prompt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package netmigo
+
+import "testing"
+
+func TestTrimOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		command string
+		prompts []string
+		want    string
+	}{
+		{
+			name:    "echo after prompt",
+			raw:     "RP/0/RP0/CPU0:R1#show clock\r\nFri Jan 10 10:00:00 UTC\r\nRP/0/RP0/CPU0:R1#\r\nRP/0/RP0/CPU0:R1#",
+			command: "show clock",
+			prompts: []string{"RP/0/RP0/CPU0:R1#"},
+			want:    "Fri Jan 10 10:00:00 UTC",
+		},
+		{
+			name:    "junos pipe in echo",
+			raw:     "show version | no-more \r\nHostname: vmx\r\nModel: vmx\r\n\r\nadmin@vmx>",
+			command: "show version | no-more",
+			prompts: []string{"admin@vmx>"},
+			want:    "Hostname: vmx\nModel: vmx",
+		},
+		{
+			name:    "wrapped echo",
+			raw:     "A:admin@sr1# show router inter\r\nface\r\nsystem  Up\r\nA:admin@sr1#",
+			command: "show router interface",
+			prompts: []string{"A:admin@sr1#"},
+			want:    "system  Up",
+		},
+		{
+			name:    "no echo",
+			raw:     "line one\nline two\n\nadmin@host>",
+			command: "show something",
+			prompts: []string{"admin@host>"},
+			want:    "line one\nline two",
+		},
+		{
+			name:    "output containing the command",
+			raw:     "admin@vmx> show log\nshow log entry\nadmin@vmx>",
+			command: "show log",
+			prompts: []string{"admin@vmx>"},
+			want:    "show log entry",
+		},
+		{
+			name:    "srl status line",
+			raw:     "A:srl# info\r\n    system {\r\n    }\r\n--{ running }--[  ]--\r\nA:srl#",
+			command: "info",
+			prompts: []string{"A:srl#", srlStatusLine},
+			want:    "    system {\n    }",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimOutput(tt.raw, tt.command, tt.prompts...); got != tt.want {
+				t.Errorf("trimOutput() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSROSContextLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{`[/]`, true},
+		{`[ex:/configure router "Base"]`, true},
+		{`(ex)[/configure router "Base"]`, true},
+		{`(gl)[/configure]`, true},
+		{`(pr)[/configure]`, true},
+		{`(ro)[/]`, true},
+		{`(xx)[/configure]`, false},
+		{`port 1/1/1 [up]`, false},
+		{`A:admin@r1#`, false},
+	}
+	for _, tt := range tests {
+		if got := srosContextLine.MatchString(tt.line); got != tt.want {
+			t.Errorf("context line match of %q = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
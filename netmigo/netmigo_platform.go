@@ -0,0 +1,255 @@
+package netmigo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Device types of the built-in drivers.
+const (
+	DeviceTypeIOSXR = "cisco_iosxr"
+	DeviceTypeJUNOS = "juniper_junos"
+	DeviceTypeSROS  = "nokia_sros"
+	DeviceTypeSRL   = "nokia_srl"
+)
+
+// Device is the vendor independent view of a driver. SendCommand runs an operational
+// command, SendConfig applies and commits configuration commands, GetConfig returns the
+// running configuration.
+type Device interface {
+	Connect(ctx context.Context) error
+	SendCommand(ctx context.Context, command string) (string, error)
+	SendConfig(ctx context.Context, commands []string) (string, error)
+	GetConfig(ctx context.Context) (string, error)
+	FileTransfer(ctx context.Context, localFile, remoteFile string) error
+	Disconnect()
+}
+
+// DeviceFactory builds a Device on an unconnected connection.
+type DeviceFactory func(connection *SSHConnModel) (Device, error)
+
+var (
+	platformsMu sync.RWMutex
+	platforms   = map[string]DeviceFactory{
+		DeviceTypeIOSXR: func(connection *SSHConnModel) (Device, error) {
+			iosxr, err := NewIOSXRDeviceConnection(connection, DeviceTypeIOSXR)
+			return &iosxrDevice{iosxr}, err
+		},
+		DeviceTypeJUNOS: func(connection *SSHConnModel) (Device, error) {
+			junos, err := NewJUNOSDeviceConnection(connection, DeviceTypeJUNOS)
+			return &junosDevice{junos}, err
+		},
+		DeviceTypeSROS: func(connection *SSHConnModel) (Device, error) {
+			sros, err := NewSROSDeviceConnection(connection, DeviceTypeSROS)
+			return &srosDevice{sros}, err
+		},
+		DeviceTypeSRL: func(connection *SSHConnModel) (Device, error) {
+			srl, err := NewSRLDeviceConnection(connection, DeviceTypeSRL)
+			return &srlDevice{srl}, err
+		},
+	}
+)
+
+// RegisterPlatform registers factory for deviceType, replacing any previous one.
+func RegisterPlatform(deviceType string, factory DeviceFactory) {
+	platformsMu.Lock()
+	defer platformsMu.Unlock()
+	platforms[deviceType] = factory
+}
+
+// Platforms returns the registered device types, sorted.
+func Platforms() []string {
+	platformsMu.RLock()
+	defer platformsMu.RUnlock()
+	deviceTypes := make([]string, 0, len(platforms))
+	for deviceType := range platforms {
+		deviceTypes = append(deviceTypes, deviceType)
+	}
+	sort.Strings(deviceTypes)
+	return deviceTypes
+}
+
+// NewDevice builds the driver registered for deviceType on a connection to host configured
+// by opts. It does not connect.
+func NewDevice(deviceType string, host string, opts ...Option) (Device, error) {
+	platformsMu.RLock()
+	factory, ok := platforms[deviceType]
+	platformsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported device type %q, supported: %s", deviceType, strings.Join(Platforms(), ", "))
+	}
+
+	connection, err := NewConnection(host, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return factory(connection)
+}
+
+// ConnectHandler builds the driver for deviceType like NewDevice and connects it.
+func ConnectHandler(ctx context.Context, deviceType string, host string, opts ...Option) (Device, error) {
+	device, err := NewDevice(deviceType, host, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := device.Connect(ctx); err != nil {
+		device.Disconnect()
+		return nil, err
+	}
+	return device, nil
+}
+
+// configPromptPattern matches prompt and its configuration mode variants, such as
+// "RP/0/RP0/CPU0:R1(config-if)#" for "RP/0/RP0/CPU0:R1#".
+func configPromptPattern(prompt string) string {
+	base := strings.TrimRight(prompt, "#>% ")
+	return regexp.QuoteMeta(base) + `(\([\w\-]+\))?[#>%]`
+}
+
+// sendConfig sends commands, entering configuration mode, then the commit commands. A
+// failure of the commit commands, or output of them matching failure, is returned as a
+// CommitError. On any failure the abort commands discard the changes and leave
+// configuration mode. A session lost on the way is not replaced: the error wraps
+// ErrSessionBroken and the caller restarts the whole transaction.
+func sendConfig(ctx context.Context, d *DeviceConnection, prompt string, commands []string, abort []string, failure *regexp.Regexp, commit ...string) (string, error) {
+	if err := d.ensureConnected(ctx); err != nil {
+		return "", err
+	}
+	ctx = transactionContext(ctx)
+	output, err := d.SendCommandsSetPatternContext(ctx, commands, prompt)
+	if err != nil {
+		abortConfig(ctx, d, prompt, abort)
+		return "", err
+	}
+	for _, cmd := range commit {
+		out, err := d.SendCommandPatternContext(ctx, cmd, prompt)
+		output += out
+		if err == nil {
+			err = commitFailure(failure, out)
+		}
+		if err != nil {
+			abortConfig(ctx, d, prompt, abort)
+			return "", commitError(output, err)
+		}
+	}
+	return output, nil
+}
+
+// abortConfig sends the commands discarding the changes and leaving configuration mode after
+// a failure. Their own errors are only logged, the caller reports the failure that led here.
+// Nothing is sent when the session was lost, the changes went with it.
+func abortConfig(ctx context.Context, d *DeviceConnection, prompt string, abort []string) {
+	if ctx.Err() != nil || d.Connection.sessionLost() {
+		return
+	}
+	if _, err := d.SendCommandsSetPatternContext(ctx, abort, prompt); err != nil {
+		log.Warnf("Failed to leave configuration mode: %v", err)
+	}
+}
+
+type iosxrDevice struct {
+	*IOSXRDeviceConnection
+}
+
+func (d *iosxrDevice) Connect(ctx context.Context) error {
+	return d.ConnectContext(ctx)
+}
+
+func (d *iosxrDevice) SendCommand(ctx context.Context, command string) (string, error) {
+	return d.SendCommandContext(ctx, command, "running", 0)
+}
+
+func (d *iosxrDevice) SendConfig(ctx context.Context, commands []string) (string, error) {
+	return sendConfig(ctx, &d.DeviceConnection, configPromptPattern(d.Prompt), append([]string{"configure terminal"}, commands...), []string{"abort"}, iosxrCommitFailurePattern, "commit", "end")
+}
+
+func (d *iosxrDevice) GetConfig(ctx context.Context) (string, error) {
+	return d.SendCommandContext(ctx, "show running-config", "running", 0)
+}
+
+func (d *iosxrDevice) FileTransfer(ctx context.Context, localFile, remoteFile string) error {
+	return d.FileTransferContext(ctx, localFile, remoteFile)
+}
+
+type junosDevice struct {
+	*JUNOSDeviceConnection
+}
+
+func (d *junosDevice) Connect(ctx context.Context) error {
+	return d.ConnectContext(ctx)
+}
+
+func (d *junosDevice) SendCommand(ctx context.Context, command string) (string, error) {
+	return d.SendCommandContext(ctx, command, "running", 0)
+}
+
+func (d *junosDevice) SendConfig(ctx context.Context, commands []string) (string, error) {
+	return sendConfig(ctx, &d.DeviceConnection, configPromptPattern(d.Prompt), append([]string{"configure"}, commands...), []string{"rollback 0", "exit configuration-mode"}, junosCommitFailurePattern, "commit and-quit")
+}
+
+func (d *junosDevice) GetConfig(ctx context.Context) (string, error) {
+	return d.SendCommandContext(ctx, "show configuration", "running", 0)
+}
+
+func (d *junosDevice) FileTransfer(ctx context.Context, localFile, remoteFile string) error {
+	return d.FileTransferContext(ctx, localFile, remoteFile)
+}
+
+type srosDevice struct {
+	*SROSDeviceConnection
+}
+
+func (d *srosDevice) Connect(ctx context.Context) error {
+	return d.ConnectContext(ctx)
+}
+
+func (d *srosDevice) SendCommand(ctx context.Context, command string) (string, error) {
+	result, err := d.SendCommandResultContext(ctx, command)
+	if err != nil {
+		return "", err
+	}
+	return result.Output, nil
+}
+
+func (d *srosDevice) SendConfig(ctx context.Context, commands []string) (string, error) {
+	return d.SendConfigSetContext(ctx, commands)
+}
+
+func (d *srosDevice) GetConfig(ctx context.Context) (string, error) {
+	return d.SendCommand(ContextWithRetry(ctx), "admin show configuration")
+}
+
+func (d *srosDevice) FileTransfer(ctx context.Context, localFile, remoteFile string) error {
+	return d.FileTransferContext(ctx, localFile, remoteFile)
+}
+
+type srlDevice struct {
+	*SRLDeviceConnection
+}
+
+func (d *srlDevice) Connect(ctx context.Context) error {
+	return d.ConnectContext(ctx)
+}
+
+func (d *srlDevice) SendCommand(ctx context.Context, command string) (string, error) {
+	return d.SendCommandContext(ctx, command, "running", 0)
+}
+
+func (d *srlDevice) SendConfig(ctx context.Context, commands []string) (string, error) {
+	// A rejected commit prints an error line, which fails the commit command already.
+	return sendConfig(ctx, &d.DeviceConnection, configPromptPattern(d.Prompt), append([]string{"enter candidate"}, commands...), []string{"discard now"}, nil, "commit now")
+}
+
+func (d *srlDevice) GetConfig(ctx context.Context) (string, error) {
+	return d.SendCommandContext(ctx, "info from running /", "running", 0)
+}
+
+func (d *srlDevice) FileTransfer(ctx context.Context, localFile, remoteFile string) error {
+	return d.FileTransferContext(ctx, localFile, remoteFile)
+}
@@ -0,0 +1,169 @@
+package netmigo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestConfigPromptPattern(t *testing.T) {
+	tests := []struct {
+		prompt string
+		line   string
+		want   bool
+	}{
+		{prompt: "RP/0/RP0/CPU0:R1#", line: "RP/0/RP0/CPU0:R1#", want: true},
+		{prompt: "RP/0/RP0/CPU0:R1#", line: "RP/0/RP0/CPU0:R1(config)#", want: true},
+		{prompt: "RP/0/RP0/CPU0:R1#", line: "RP/0/RP0/CPU0:R1(config-if)#", want: true},
+		{prompt: "RP/0/RP0/CPU0:R1#", line: "RP/0/RP0/CPU0:R2(config)#", want: false},
+		{prompt: "admin@r1> ", line: "admin@r1# ", want: true},
+		{prompt: "admin@r1> ", line: "admin@r1(config)#", want: true},
+		{prompt: "A:admin@r1# ", line: "A:admin@r1#", want: true},
+	}
+	for _, tt := range tests {
+		re := regexp.MustCompile(configPromptPattern(tt.prompt))
+		if got := re.MatchString(tt.line); got != tt.want {
+			t.Errorf("configPromptPattern(%q) matches %q = %v, want %v", tt.prompt, tt.line, got, tt.want)
+		}
+	}
+}
+
+// fakeDevice is a Device that records the calls made to it.
+type fakeDevice struct {
+	connection *SSHConnModel
+	calls      []string
+}
+
+func (f *fakeDevice) Connect(ctx context.Context) error {
+	f.calls = append(f.calls, "connect")
+	return nil
+}
+
+func (f *fakeDevice) SendCommand(ctx context.Context, command string) (string, error) {
+	return command, nil
+}
+
+func (f *fakeDevice) SendConfig(ctx context.Context, commands []string) (string, error) {
+	return strings.Join(commands, "\n"), nil
+}
+
+func (f *fakeDevice) GetConfig(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (f *fakeDevice) FileTransfer(ctx context.Context, localFile, remoteFile string) error {
+	return nil
+}
+
+func (f *fakeDevice) Disconnect() {
+	f.calls = append(f.calls, "disconnect")
+}
+
+func TestPlatformRegistry(t *testing.T) {
+	const deviceType = "test_fake"
+	RegisterPlatform(deviceType, func(connection *SSHConnModel) (Device, error) {
+		return &fakeDevice{connection: connection}, nil
+	})
+	t.Cleanup(func() {
+		platformsMu.Lock()
+		delete(platforms, deviceType)
+		platformsMu.Unlock()
+	})
+
+	want := []string{DeviceTypeIOSXR, DeviceTypeJUNOS, DeviceTypeSROS, DeviceTypeSRL, deviceType}
+	got := Platforms()
+	for _, deviceType := range want {
+		found := false
+		for _, registered := range got {
+			found = found || registered == deviceType
+		}
+		if !found {
+			t.Errorf("Platforms() = %v, missing %s", got, deviceType)
+		}
+	}
+
+	device, err := ConnectHandler(context.Background(), deviceType, "2001:db8::1", WithPort(2222), WithCredentials("admin", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := device.(*fakeDevice)
+	if fake.connection.Addr != "[2001:db8::1]:2222" || fake.connection.Username != "admin" {
+		t.Errorf("connection to %s as %s, want the options applied", fake.connection.Addr, fake.connection.Username)
+	}
+	if len(fake.calls) != 1 || fake.calls[0] != "connect" {
+		t.Errorf("calls = %v, want connect", fake.calls)
+	}
+
+	if _, err := NewDevice("cisco_nxos", "r1"); err == nil || !strings.Contains(err.Error(), DeviceTypeIOSXR) {
+		t.Errorf("NewDevice() of an unknown type error = %v, want the supported types listed", err)
+	}
+	for _, deviceType := range []string{DeviceTypeIOSXR, DeviceTypeJUNOS, DeviceTypeSROS, DeviceTypeSRL} {
+		if _, err := NewDevice(deviceType, "r1", WithCredentials("admin", "secret")); err != nil {
+			t.Errorf("NewDevice(%s) error = %v", deviceType, err)
+		}
+	}
+}
+
+func TestSendConfig(t *testing.T) {
+	const prompt = "RP/0/RP0/CPU0:R1#"
+	tests := []struct {
+		name     string
+		replies  map[string]string // output of a command before the prompt
+		want     []string          // lines received
+		wantErr  error
+		wantText string
+	}{
+		{
+			name: "committed",
+			want: []string{"configure terminal", "hostname R1", "commit", "end"},
+		},
+		{
+			name:     "commit rejected",
+			replies:  map[string]string{"commit": "% Failed to commit one or more configuration items. Please issue 'show configuration failed'\r\n"},
+			want:     []string{"configure terminal", "hostname R1", "commit", "abort"},
+			wantErr:  ErrCommitFailed,
+			wantText: "% Failed to commit one or more configuration items",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan string, 10)
+			d := pipeDevice(t, func(line net.Conn, lines *bufio.Reader) {
+				mode := ""
+				for {
+					text, err := lines.ReadString('\n')
+					if err != nil {
+						return
+					}
+					command := strings.TrimSpace(text)
+					received <- command
+					switch command {
+					case "configure terminal":
+						mode = "(config)"
+					case "end", "abort":
+						mode = ""
+					}
+					line.Write([]byte(command + "\r\n" + tt.replies[command] + "RP/0/RP0/CPU0:R1" + mode + "#"))
+				}
+			})
+
+			_, err := sendConfig(context.Background(), d, configPromptPattern(prompt), []string{"configure terminal", "hostname R1"},
+				[]string{"abort"}, iosxrCommitFailurePattern, "commit", "end")
+			if !errors.Is(err, tt.wantErr) || (err != nil && !strings.Contains(err.Error(), tt.wantText)) {
+				t.Fatalf("sendConfig() error = %v, want %v", err, tt.wantErr)
+			}
+			var got []string
+			for len(received) > 0 {
+				got = append(got, <-received)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("device received %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
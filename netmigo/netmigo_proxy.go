@@ -0,0 +1,307 @@
+package netmigo
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Dialer opens the TCP connection to the device, or to the first jump host. *net.Dialer
+// satisfies it; the proxy dialers below tunnel the connection through a proxy server.
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// WithDialer sets the dialer used for the shell session, SFTP and SCP.
+func WithDialer(dialer Dialer) Option {
+	return func(c *SSHConnModel) error {
+		if dialer == nil {
+			return errors.New("dialer must not be nil")
+		}
+		c.Dialer = dialer
+		return nil
+	}
+}
+
+// WithSOCKS5Proxy reaches the device through a SOCKS5 proxy. Username and password may be
+// empty when the proxy does not require authentication.
+func WithSOCKS5Proxy(proxyAddr, username, password string) Option {
+	return WithDialer(NewSOCKS5Dialer(proxyAddr, username, password, nil))
+}
+
+// WithHTTPProxy reaches the device through an HTTP proxy supporting the CONNECT method.
+// Username and password, if given, are sent as Basic proxy authorization.
+func WithHTTPProxy(proxyAddr, username, password string) Option {
+	return WithDialer(NewHTTPConnectDialer(proxyAddr, username, password, nil))
+}
+
+// dial opens a TCP connection to addr through the configured dialer, bounded by the
+// connect timeout.
+func (c *SSHConnModel) dial(ctx context.Context, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.connectTimeout())
+	defer cancel()
+
+	return forwardDialer(c.Dialer).DialContext(ctx, "tcp", addr)
+}
+
+// proxyHandshake runs handshake on conn, aborting it when ctx is done or its deadline passes.
+func proxyHandshake(ctx context.Context, conn net.Conn, handshake func() error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	err := handshake()
+	if !stop() {
+		return ctx.Err()
+	}
+	return err
+}
+
+// SOCKS5Dialer connects through a SOCKS5 proxy (RFC 1928), with optional username and
+// password authentication (RFC 1929). The target host name is resolved by the proxy.
+type SOCKS5Dialer struct {
+	ProxyAddr string
+	Username  string
+	Password  string
+	Forward   Dialer // reaches the proxy, a plain net.Dialer when nil
+}
+
+// NewSOCKS5Dialer returns a dialer tunnelling through the SOCKS5 proxy at proxyAddr.
+func NewSOCKS5Dialer(proxyAddr, username, password string, forward Dialer) *SOCKS5Dialer {
+	return &SOCKS5Dialer{ProxyAddr: proxyAddr, Username: username, Password: password, Forward: forward}
+}
+
+// SOCKS5 protocol constants.
+const (
+	socks5Version      = 5
+	socks5AuthNone     = 0
+	socks5AuthPassword = 2
+	socks5AuthNoAccept = 0xff
+	socks5Connect      = 1
+	socks5AddrIPv4     = 1
+	socks5AddrDomain   = 3
+	socks5AddrIPv6     = 4
+)
+
+// socks5Errors describes the reply codes of a failed CONNECT request.
+var socks5Errors = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// DialContext connects to addr through the proxy.
+func (d *SOCKS5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := forwardDialer(d.Forward).DialContext(ctx, network, d.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach SOCKS5 proxy %s: %w", d.ProxyAddr, err)
+	}
+	err = proxyHandshake(ctx, conn, func() error { return d.handshake(conn, addr) })
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 proxy %s: %w", d.ProxyAddr, err)
+	}
+	return conn, nil
+}
+
+func (d *SOCKS5Dialer) handshake(conn net.Conn, addr string) error {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port in %s", addr)
+	}
+
+	methods := []byte{socks5AuthNone}
+	if d.Username != "" {
+		methods = []byte{socks5AuthPassword, socks5AuthNone}
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected protocol version %d", reply[0])
+	}
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	case socks5AuthNoAccept:
+		return errors.New("no acceptable authentication method")
+	default:
+		return fmt.Errorf("unsupported authentication method %d", reply[1])
+	}
+
+	request := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(append(request, socks5AddrIPv4), ip4...)
+		} else {
+			request = append(append(request, socks5AddrIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		request = append(append(request, socks5AddrDomain, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if message, ok := socks5Errors[header[1]]; ok {
+			return fmt.Errorf("connect to %s failed: %s", addr, message)
+		}
+		return fmt.Errorf("connect to %s failed: reply code %d", addr, header[1])
+	}
+
+	// Skip the bound address and port.
+	var skip int
+	switch header[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len + 2
+	case socks5AddrIPv6:
+		skip = net.IPv6len + 2
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("unexpected address type %d in reply", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+func (d *SOCKS5Dialer) authenticate(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return errors.New("username or password too long")
+	}
+	request := []byte{1, byte(len(d.Username))}
+	request = append(request, d.Username...)
+	request = append(request, byte(len(d.Password)))
+	request = append(request, d.Password...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
+
+// HTTPConnectDialer connects through an HTTP proxy with the CONNECT method.
+type HTTPConnectDialer struct {
+	ProxyAddr string
+	Username  string
+	Password  string
+	Forward   Dialer // reaches the proxy, a plain net.Dialer when nil
+}
+
+// NewHTTPConnectDialer returns a dialer tunnelling through the HTTP proxy at proxyAddr.
+func NewHTTPConnectDialer(proxyAddr, username, password string, forward Dialer) *HTTPConnectDialer {
+	return &HTTPConnectDialer{ProxyAddr: proxyAddr, Username: username, Password: password, Forward: forward}
+}
+
+// DialContext connects to addr through the proxy.
+func (d *HTTPConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := forwardDialer(d.Forward).DialContext(ctx, network, d.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach HTTP proxy %s: %w", d.ProxyAddr, err)
+	}
+
+	var tunnel net.Conn
+	err = proxyHandshake(ctx, conn, func() error {
+		var err error
+		tunnel, err = d.handshake(conn, addr)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP proxy %s: %w", d.ProxyAddr, err)
+	}
+	return tunnel, nil
+}
+
+func (d *HTTPConnectDialer) handshake(conn net.Conn, addr string) (net.Conn, error) {
+	request := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if d.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.Username + ":" + d.Password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	request += "\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("connect to %s failed: %s", addr, response.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		// The device spoke first and its data was read along with the response.
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn hands out the bytes buffered while reading the proxy response before
+// reading from the connection again.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// forwardDialer returns dialer, or a plain net.Dialer when it is nil.
+func forwardDialer(dialer Dialer) Dialer {
+	if dialer == nil {
+		return &net.Dialer{}
+	}
+	return dialer
+}
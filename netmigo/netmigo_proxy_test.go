@@ -0,0 +1,216 @@
+package netmigo
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const proxyBanner = "SSH-2.0-device\r\n"
+
+// serveProxy accepts one connection on a local listener and runs serve on it. It returns
+// the listener address and the target the proxy was asked for.
+func serveProxy(t *testing.T, serve func(conn net.Conn) string) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	target := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		target <- serve(conn)
+		io.Copy(io.Discard, conn)
+	}()
+	return l.Addr().String(), target
+}
+
+// socks5Proxy serves the SOCKS5 protocol: password authentication when username is set,
+// then a CONNECT answered with reply and, on success, the banner of the device.
+func socks5Proxy(username, password string, reply byte) func(conn net.Conn) string {
+	return func(conn net.Conn) string {
+		header := make([]byte, 2)
+		io.ReadFull(conn, header)
+		methods := make([]byte, header[1])
+		io.ReadFull(conn, methods)
+		if username == "" {
+			conn.Write([]byte{socks5Version, socks5AuthNone})
+		} else {
+			conn.Write([]byte{socks5Version, socks5AuthPassword})
+			auth := bufio.NewReader(conn)
+			auth.ReadByte()
+			n, _ := auth.ReadByte()
+			user := make([]byte, n)
+			io.ReadFull(auth, user)
+			n, _ = auth.ReadByte()
+			pass := make([]byte, n)
+			io.ReadFull(auth, pass)
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				return ""
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		request := make([]byte, 4)
+		io.ReadFull(conn, request)
+		var host string
+		switch request[3] {
+		case socks5AddrIPv4:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case socks5AddrIPv6:
+			ip := make([]byte, net.IPv6len)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case socks5AddrDomain:
+			n := make([]byte, 1)
+			io.ReadFull(conn, n)
+			name := make([]byte, n[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+		target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+		conn.Write([]byte{socks5Version, reply, 0, socks5AddrDomain, 5, 'p', 'r', 'o', 'x', 'y', 0, 0})
+		if reply == 0 {
+			conn.Write([]byte(proxyBanner))
+		}
+		return target
+	}
+}
+
+func TestSOCKS5Dialer(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     string
+		username string
+		password string // sent by the dialer, the proxy expects "secret"
+		reply    byte
+		wantErr  string
+	}{
+		{name: "domain", addr: "r1.lab:22"},
+		{name: "ipv4 with password", addr: "192.0.2.1:830", username: "ops", password: "secret"},
+		{name: "ipv6", addr: "[2001:db8::1]:22"},
+		{name: "wrong password", addr: "r1.lab:22", username: "ops", password: "wrong", wantErr: "authentication failed"},
+		{name: "refused", addr: "r1.lab:22", reply: 5, wantErr: "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, target := serveProxy(t, socks5Proxy(tt.username, "secret", tt.reply))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := NewSOCKS5Dialer(proxy, tt.username, tt.password, nil).DialContext(ctx, "tcp", tt.addr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DialContext() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			banner, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || banner != proxyBanner {
+				t.Errorf("read %q, %v through the tunnel, want the device banner", banner, err)
+			}
+			if got := <-target; got != tt.addr {
+				t.Errorf("proxy asked for %s, want %s", got, tt.addr)
+			}
+		})
+	}
+}
+
+// httpProxy answers a CONNECT with 200 and the device banner in the same write, or with
+// 407 when the Basic credentials do not match username and password.
+func httpProxy(username, password string) func(conn net.Conn) string {
+	return func(conn net.Conn) string {
+		request, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || request.Method != http.MethodConnect {
+			return ""
+		}
+		if username != "" {
+			user, pass, ok := (&http.Request{Header: http.Header{"Authorization": request.Header["Proxy-Authorization"]}}).BasicAuth()
+			if !ok || user != username || pass != password {
+				conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n"))
+				return request.Host
+			}
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n" + proxyBanner))
+		return request.Host
+	}
+}
+
+func TestHTTPConnectDialer(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string // sent by the dialer, the proxy expects "secret"
+		wantErr  bool
+	}{
+		{name: "no authentication"},
+		{name: "basic authentication", username: "ops", password: "secret"},
+		{name: "rejected", username: "ops", password: "wrong", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, target := serveProxy(t, httpProxy(tt.username, "secret"))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := NewHTTPConnectDialer(proxy, tt.username, tt.password, nil).DialContext(ctx, "tcp", "[2001:db8::1]:22")
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "407") {
+					t.Fatalf("DialContext() error = %v, want the 407 status", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			// The banner came with the response and must not be lost
+			banner, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil || banner != proxyBanner {
+				t.Errorf("read %q, %v through the tunnel, want the device banner", banner, err)
+			}
+			if got := <-target; got != "[2001:db8::1]:22" {
+				t.Errorf("proxy asked for %s", got)
+			}
+		})
+	}
+}
+
+func TestProxyHandshakeContext(t *testing.T) {
+	// A proxy that accepts the connection but never answers
+	proxy, _ := serveProxy(t, func(conn net.Conn) string {
+		io.Copy(io.Discard, conn)
+		return ""
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := NewSOCKS5Dialer(proxy, "", "", nil).DialContext(ctx, "tcp", "r1:22"); err == nil {
+		t.Fatal("DialContext() through a silent proxy succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("DialContext() gave up after %v", elapsed)
+	}
+}
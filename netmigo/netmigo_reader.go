@@ -0,0 +1,385 @@
+package netmigo
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// matchLookback is how far back, in bytes, a pattern search restarts when new output arrives.
+// Prompts and confirmations are short, so output older than this is not searched again.
+const matchLookback = 4096
+
+var (
+	// ansiEscapeRegexp matches terminal escape sequences: CSI (colours, cursor, bracketed
+	// paste, ...), charset selection and keypad modes.
+	ansiEscapeRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]|\x1b[()][A-Za-z0-9]|\x1b[=>]`)
+	// ansiPartialRegexp matches an escape sequence cut at the end of a chunk.
+	ansiPartialRegexp = regexp.MustCompile(`\x1b(\[[0-9;?]*|[()])?$`)
+
+	patternCache = newRegexpCache(patternCacheSize)
+)
+
+// patternCacheSize bounds the compiled read patterns kept for the following commands. The
+// drivers use a handful; the rest of the room is for the patterns of callers.
+const patternCacheSize = 256
+
+// compilePattern compiles a read pattern once and caches it for the following commands.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if r := patternCache.get(pattern); r != nil {
+		return r, nil
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patternCache.put(pattern, r)
+	return r, nil
+}
+
+// regexpCache keeps the most recently used compiled patterns, up to size.
+type regexpCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // of *regexpCacheEntry, most recently used first
+}
+
+type regexpCacheEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newRegexpCache(size int) *regexpCache {
+	return &regexpCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *regexpCache) get(pattern string) *regexp.Regexp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[pattern]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*regexpCacheEntry).re
+}
+
+func (c *regexpCache) put(pattern string, re *regexp.Regexp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[pattern] = c.order.PushFront(&regexpCacheEntry{pattern: pattern, re: re})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*regexpCacheEntry).pattern)
+	}
+}
+
+// sessionReader owns the session output stream. A single long-lived goroutine pumps the
+// stream, with terminal escape sequences removed, into a growable buffer. Consumers take
+// bytes or pattern matches from the buffer; whatever they do not consume stays
+// buffered for the next command. Consumers are expected to take turns, not to run concurrently.
+type sessionReader struct {
+	mu     sync.Mutex
+	buf    []byte
+	err    error          // set once the stream ended
+	notify chan struct{}  // signalled when data arrives or the stream ends
+	owed   *regexp.Regexp // prompt the last command has still to print, see sync
+}
+
+func newSessionReader(src io.Reader) *sessionReader {
+	r := &sessionReader{
+		notify: make(chan struct{}, 1),
+	}
+	go r.pump(src)
+	return r
+}
+
+func (r *sessionReader) pump(src io.Reader) {
+	buff := make([]byte, 32*1024)
+	var carry []byte
+	for {
+		n, err := src.Read(buff)
+		if n > 0 {
+			data := append(carry, buff[:n]...)
+			cut := len(data)
+			if loc := ansiPartialRegexp.FindIndex(data); loc != nil {
+				cut = loc[0]
+			}
+			clean := ansiEscapeRegexp.ReplaceAll(data[:cut], nil)
+			carry = append([]byte(nil), data[cut:]...)
+
+			r.mu.Lock()
+			r.buf = append(r.buf, clean...)
+			r.mu.Unlock()
+			r.signal()
+		}
+		if err != nil {
+			r.mu.Lock()
+			r.buf = append(r.buf, carry...)
+			r.err = err
+			r.mu.Unlock()
+			r.signal()
+			return
+		}
+	}
+}
+
+func (r *sessionReader) signal() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *sessionReader) wait(ctx context.Context) error {
+	select {
+	case <-r.notify:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Read implements io.Reader.
+func (r *sessionReader) Read(p []byte) (int, error) {
+	return r.ReadContext(context.Background(), p)
+}
+
+// ReadContext returns the buffered output, waiting for some if the buffer is empty.
+func (r *sessionReader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	for {
+		r.mu.Lock()
+		if len(r.buf) > 0 {
+			n := copy(p, r.buf)
+			r.buf = r.buf[n:]
+			r.mu.Unlock()
+			return n, nil
+		}
+		err := r.err
+		r.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		if err := r.wait(ctx); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readUntil consumes and returns the output up to the end of the first match of re. When ctx
+// is done or the stream ends first, the output received so far is consumed and returned with
+// the error.
+func (r *sessionReader) readUntil(ctx context.Context, re *regexp.Regexp) (string, error) {
+	out, _, _, err := r.expect(ctx, []*regexp.Regexp{re})
+	return out, err
+}
+
+// expect consumes and returns the output up to the end of the earliest match of any of res,
+// the index of the pattern that matched and its submatch indices within the output. A match
+// starting earlier wins, ties go to the pattern listed first. When ctx is done or the stream
+// ends first, the output received so far is consumed and returned with the error.
+func (r *sessionReader) expect(ctx context.Context, res []*regexp.Regexp) (string, int, []int, error) {
+	searchFrom := 0
+	for {
+		r.mu.Lock()
+		index, loc := -1, []int(nil)
+		for i, re := range res {
+			if l := re.FindSubmatchIndex(r.buf[searchFrom:]); l != nil && (loc == nil || l[0] < loc[0]) {
+				index, loc = i, l
+			}
+		}
+		if loc != nil {
+			for i := range loc {
+				if loc[i] >= 0 {
+					loc[i] += searchFrom
+				}
+			}
+			out := string(r.buf[:loc[1]])
+			r.buf = r.buf[loc[1]:]
+			r.mu.Unlock()
+			return out, index, loc, nil
+		}
+		if r.err != nil {
+			out, err := string(r.buf), r.err
+			r.buf = r.buf[:0]
+			r.mu.Unlock()
+			return out, -1, nil, err
+		}
+		if len(r.buf) > matchLookback {
+			// Restart from a line start so that multi-line anchors keep their meaning.
+			searchFrom = bytes.LastIndexByte(r.buf[:len(r.buf)-matchLookback], '\n') + 1
+		}
+		r.mu.Unlock()
+
+		if err := r.wait(ctx); err != nil {
+			r.mu.Lock()
+			out := string(r.buf)
+			r.buf = r.buf[:0]
+			r.mu.Unlock()
+			return out, -1, nil, err
+		}
+	}
+}
+
+// quiet reports whether no output arrives within d. Output that does arrive stays buffered.
+func (r *sessionReader) quiet(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		arrived := len(r.buf) > 0
+		r.mu.Unlock()
+		if arrived {
+			return false
+		}
+		select {
+		case <-r.notify:
+			// The signal may be left over from output consumed already, look again.
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return true
+		}
+	}
+}
+
+// owe records that the command just read has still to print a prompt matching re, such as
+// the one answering the extra return the drivers send after a command.
+func (r *sessionReader) owe(re *regexp.Regexp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.owed = re
+}
+
+// sync readies the buffer for the next command and returns the output it consumed: the
+// output up to the prompt the previous command owes, waited for up to timeout, and whatever
+// followed it unasked, such as log messages.
+func (r *sessionReader) sync(ctx context.Context, timeout time.Duration) (string, error) {
+	r.mu.Lock()
+	owed := r.owed
+	r.owed = nil
+	r.mu.Unlock()
+
+	var out string
+	if owed != nil {
+		readCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		var err error
+		if out, _, _, err = r.expect(readCtx, []*regexp.Regexp{owed}); err != nil && ctx.Err() == nil {
+			err = fmt.Errorf("prompt of the previous command not seen: %w", err)
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+	return out + r.discard(), nil
+}
+
+// ended reports whether the stream ended, as when the device closed the channel.
+func (r *sessionReader) ended() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err != nil
+}
+
+// discard drops the output buffered so far and returns it.
+func (r *sessionReader) discard() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := string(r.buf)
+	r.buf = r.buf[:0]
+	return out
+}
+
+// unread puts output consumed by a wait that gave up back in front of the buffer, for the
+// next wait to search again.
+func (r *sessionReader) unread(out string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf = append([]byte(out), r.buf...)
+}
+
+// contextReader binds a sessionReader to a context, for consumers that take an io.Reader.
+type contextReader struct {
+	ctx    context.Context
+	reader *sessionReader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	return r.reader.ReadContext(r.ctx, p)
+}
+
+// ReaderContext returns a reader over the session output whose reads return ctx.Err()
+// once ctx is done.
+func (c *SSHConnModel) ReaderContext(ctx context.Context) io.Reader {
+	if r, ok := c.Reader.(*sessionReader); ok {
+		return contextReader{ctx: ctx, reader: r}
+	}
+	return c.Reader
+}
+
+// ReadContext returns the output buffered so far, waiting for some if there is none.
+func (c *SSHConnModel) ReadContext(ctx context.Context) (string, error) {
+	buff := make([]byte, 32*1024)
+	n, err := c.ReaderContext(ctx).Read(buff)
+	return string(buff[:n]), err
+}
+
+// sessionOutput returns the session reader, or an error if the connection has no session.
+func (c *SSHConnModel) sessionOutput() (*sessionReader, error) {
+	r, ok := c.Reader.(*sessionReader)
+	if !ok {
+		return nil, errors.New("not connected to device, make sure to call .Connect() first")
+	}
+	return r, nil
+}
+
+// syncOutput consumes the rest of the previous command's output, up to the prompt it owes
+// and any unasked output after it, so that it cannot satisfy the next command's end marker.
+// A prompt that does not come in time is logged; the next command goes ahead regardless.
+func (d *DeviceConnection) syncOutput(ctx context.Context) {
+	r, ok := d.Connection.Reader.(*sessionReader)
+	if !ok {
+		return
+	}
+	stale, err := r.sync(ctx, d.readTimeout(ctx))
+	if err != nil {
+		log.Warn(err)
+	}
+	if stale != "" {
+		log.Debugf("Consumed output of the previous command: %q", stale)
+	}
+}
+
+// owePrompt records that the command just read has still to print a prompt matching
+// pattern, for syncOutput to wait for before the next command.
+func (d *DeviceConnection) owePrompt(pattern string) {
+	r, ok := d.Connection.Reader.(*sessionReader)
+	if !ok {
+		return
+	}
+	re, err := compilePattern(pattern)
+	if err != nil {
+		log.Errorf("Failed to compile regex pattern '%s': %v", pattern, err)
+		return
+	}
+	r.owe(re)
+}
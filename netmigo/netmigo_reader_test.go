@@ -0,0 +1,280 @@
+package netmigo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkReader returns its chunks one per Read, then io.EOF.
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	if r.chunks[0] = r.chunks[0][n:]; r.chunks[0] == "" {
+		r.chunks = r.chunks[1:]
+	}
+	return n, nil
+}
+
+// settle waits until the pump of r has buffered output, or reached the end of the stream.
+func settle(r *sessionReader) {
+	for i := 0; i < 100; i++ {
+		r.mu.Lock()
+		done := r.err != nil || len(r.buf) > 0
+		r.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSessionReaderExpect(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunks    []string
+		patterns  []string
+		wantOut   string
+		wantIndex int
+		wantRest  string
+	}{
+		{
+			name:      "escape sequences removed",
+			chunks:    []string{"\x1b[1mshow\x1b[0m clock\r\n10:00\r\nR1#"},
+			patterns:  []string{`R1#`},
+			wantOut:   "show clock\r\n10:00\r\nR1#",
+			wantIndex: 0,
+		},
+		{
+			name:      "escape sequence split across chunks",
+			chunks:    []string{"line\x1b[", "32mgreen\x1b", "[0m\r\nR1#"},
+			patterns:  []string{`R1#`},
+			wantOut:   "linegreen\r\nR1#",
+			wantIndex: 0,
+		},
+		{
+			name:      "earliest match wins",
+			chunks:    []string{"Password: R1#"},
+			patterns:  []string{`R1#`, `Password:`},
+			wantOut:   "Password:",
+			wantIndex: 1,
+			wantRest:  " R1#",
+		},
+		{
+			name:      "tie goes to the first pattern",
+			chunks:    []string{"R1#"},
+			patterns:  []string{`R1#`, `#`},
+			wantOut:   "R1#",
+			wantIndex: 0,
+		},
+		{
+			name:      "rest stays buffered",
+			chunks:    []string{"out\r\nR1#\r\nR1#"},
+			patterns:  []string{`R1#`},
+			wantOut:   "out\r\nR1#",
+			wantIndex: 0,
+			wantRest:  "\r\nR1#",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSessionReader(&chunkReader{chunks: append([]string(nil), tt.chunks...)})
+			res := make([]*regexp.Regexp, len(tt.patterns))
+			for i, pattern := range tt.patterns {
+				res[i] = regexp.MustCompile(pattern)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			out, index, _, err := r.expect(ctx, res)
+			if err != nil {
+				t.Fatalf("expect() error = %v", err)
+			}
+			if out != tt.wantOut || index != tt.wantIndex {
+				t.Errorf("expect() = %q, %d, want %q, %d", out, index, tt.wantOut, tt.wantIndex)
+			}
+			settle(r)
+			if rest := r.discard(); rest != tt.wantRest {
+				t.Errorf("rest = %q, want %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestSessionReaderSync(t *testing.T) {
+	src, device := io.Pipe()
+	r := newSessionReader(src)
+	defer device.Close()
+
+	// The first prompt of "show clock\n\n" ends the command, the second one comes late
+	go device.Write([]byte("show clock\r\n10:00\r\nR1#\r\n"))
+	ctx := context.Background()
+	if _, err := r.readUntil(ctx, regexp.MustCompile(`R1#[^\n]*\n`)); err != nil {
+		t.Fatal(err)
+	}
+	r.owe(regexp.MustCompile(`R1#`))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		device.Write([]byte("R1#"))
+	}()
+	stale, err := r.sync(ctx, time.Second)
+	if err != nil || stale != "R1#" {
+		t.Fatalf("sync() = %q, %v, want the late prompt", stale, err)
+	}
+
+	// Nothing owed: whatever is buffered is dropped without waiting
+	go device.Write([]byte("%LOG-5-CONFIG_I: configured\r\n"))
+	settle(r)
+	start := time.Now()
+	if stale, err := r.sync(ctx, time.Second); err != nil || !strings.Contains(stale, "CONFIG_I") {
+		t.Errorf("sync() = %q, %v, want the log message", stale, err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("sync() without an owed prompt took %v", elapsed)
+	}
+
+	// An owed prompt that never comes is reported once the timeout expires
+	r.owe(regexp.MustCompile(`R1#`))
+	if _, err := r.sync(ctx, 50*time.Millisecond); err == nil {
+		t.Error("sync() without the owed prompt succeeded")
+	}
+}
+
+func TestSessionReaderQuiet(t *testing.T) {
+	src, device := io.Pipe()
+	r := newSessionReader(src)
+	defer device.Close()
+	ctx := context.Background()
+
+	if !r.quiet(ctx, 20*time.Millisecond) {
+		t.Error("quiet() = false on a silent stream")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		device.Write([]byte("more"))
+	}()
+	if r.quiet(ctx, time.Second) {
+		t.Error("quiet() = true while output arrives")
+	}
+	if out := r.discard(); out != "more" {
+		t.Errorf("buffered = %q, want the output that arrived", out)
+	}
+}
+
+func TestRegexpCache(t *testing.T) {
+	c := newRegexpCache(2)
+	a, b, d := regexp.MustCompile("a"), regexp.MustCompile("b"), regexp.MustCompile("d")
+	c.put("a", a)
+	c.put("b", b)
+	if c.get("a") != a {
+		t.Fatal("get(a) missed")
+	}
+	// "b" is now the least recently used and makes room for "d"
+	c.put("d", d)
+	if c.get("b") != nil {
+		t.Error("get(b) hit after eviction")
+	}
+	if c.get("a") != a || c.get("d") != d {
+		t.Error("recently used patterns evicted")
+	}
+	if n := c.order.Len(); n != 2 {
+		t.Errorf("cache holds %d patterns, want 2", n)
+	}
+}
+
+// runningConfig returns a "show running-config" of about size bytes, coloured like some
+// terminals do, followed by the prompt.
+func runningConfig(size int) []byte {
+	var b bytes.Buffer
+	b.WriteString("show running-config\r\n")
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "interface \x1b[1mGigabitEthernet0/0/0/%d\x1b[0m\r\n", i)
+		fmt.Fprintf(&b, " description \x1b[32mlink %d\x1b[0m\r\n ipv4 address 10.0.%d.%d 255.255.255.0\r\n!\r\n", i, i/256%256, i%256)
+	}
+	b.WriteString("end\r\n\r\nRP/0/RP0/CPU0:R1#")
+	return b.Bytes()
+}
+
+// legacyReadUntil is the read path the session reader replaced: two bytes per read, the
+// escape sequences removed and the pattern compiled and matched over all output each time.
+func legacyReadUntil(src io.Reader, pattern string) (string, error) {
+	var result string
+	buff := make([]byte, 2)
+	for {
+		n, err := src.Read(buff)
+		if err != nil {
+			return result, err
+		}
+		re := regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+		result += re.ReplaceAllString(string(buff[:n]), "")
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return result, err
+		}
+		if r.MatchString(result) {
+			return result, nil
+		}
+	}
+}
+
+func BenchmarkReadRunningConfig(b *testing.B) {
+	const prompt = `RP/0/RP0/CPU0:R1#`
+	for _, size := range []int{16 << 10, 64 << 10} {
+		stream := runningConfig(size)
+
+		b.Run(fmt.Sprintf("legacy/%dKiB", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(stream)))
+			for i := 0; i < b.N; i++ {
+				if _, err := legacyReadUntil(bytes.NewReader(stream), prompt); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("session/%dKiB", size>>10), func(b *testing.B) {
+			b.SetBytes(int64(len(stream)))
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				re, err := compilePattern(prompt)
+				if err != nil {
+					b.Fatal(err)
+				}
+				r := newSessionReader(bytes.NewReader(stream))
+				if _, err := r.readUntil(ctx, re); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkExpectPatterns(b *testing.B) {
+	stream := runningConfig(256 << 10)
+	patterns := []string{`RP/0/RP0/CPU0:R1#[^\n]*\n`, pagerPrompt, `(?i)overwrite\?\s*\[(no|yes)\]:?`}
+	b.SetBytes(int64(len(stream)))
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		res := make([]*regexp.Regexp, len(patterns))
+		for j, pattern := range patterns {
+			re, err := compilePattern(pattern)
+			if err != nil {
+				b.Fatal(err)
+			}
+			res[j] = re
+		}
+		r := newSessionReader(bytes.NewReader(append(stream, "\r\n"...)))
+		if _, _, _, err := r.expect(ctx, res); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
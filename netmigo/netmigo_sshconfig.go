@@ -0,0 +1,362 @@
+package netmigo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxIncludeDepth bounds nested Include directives, as OpenSSH does.
+const maxIncludeDepth = 16
+
+// systemSSHConfig is the system-wide client configuration, whose relative Include paths
+// OpenSSH resolves against its own directory rather than ~/.ssh.
+const systemSSHConfig = "/etc/ssh/ssh_config"
+
+// sshConfig holds the entries of an OpenSSH client configuration file, in file order
+// with Include directives expanded.
+type sshConfig struct {
+	entries    []sshConfigEntry
+	includeDir string // directory of relative Include paths
+}
+
+// sshConfigEntry is one "Keyword value" line and the Host patterns it applies to.
+// A nil pattern list means the line appeared before any Host block and applies to every host.
+type sshConfigEntry struct {
+	patterns []string
+	key      string // lower case
+	value    string
+}
+
+// WithSSHConfig resolves the host given to NewConnection as an alias of an OpenSSH client
+// configuration file and fills in HostName, User, Port, IdentityFile and ProxyJump from the
+// matching Host blocks. Settings given through other options win over the file. An empty
+// path reads ~/.ssh/config, which is then allowed to be missing.
+func WithSSHConfig(path string) Option {
+	return func(c *SSHConnModel) error {
+		optional := false
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return fmt.Errorf("failed to locate ssh config: %w", err)
+			}
+			path = filepath.Join(home, ".ssh", "config")
+			optional = true
+		}
+
+		config, err := loadSSHConfig(path)
+		if errors.Is(err, os.ErrNotExist) && optional {
+			log.Debugf("No ssh config at %s", path)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		c.sshConfig = config
+		return nil
+	}
+}
+
+// loadSSHConfig parses an OpenSSH client configuration file. Like "ssh -F", any file but
+// the system configuration is read as a user configuration.
+func loadSSHConfig(path string) (*sshConfig, error) {
+	config := &sshConfig{includeDir: filepath.Dir(systemSSHConfig)}
+	if filepath.Clean(path) != systemSSHConfig {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate ssh config includes: %w", err)
+		}
+		config.includeDir = filepath.Join(home, ".ssh")
+	}
+	if err := config.parseFile(path, nil, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseFile appends the entries of path. Lines before the first Host keyword inherit
+// patterns, the Host block the file was included from.
+func (s *sshConfig) parseFile(path string, patterns []string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("ssh config %s: too many nested includes", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open ssh config: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		key, value, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("ssh config %s line %d: %w", path, lineNumber, err)
+		}
+
+		switch key {
+		case "":
+			continue
+		case "host":
+			patterns = strings.Fields(value)
+		case "match":
+			// Match criteria are not evaluated, the block never applies.
+			log.Debugf("ssh config %s line %d: Match blocks are not supported, ignoring", path, lineNumber)
+			patterns = []string{}
+		case "include":
+			for _, pattern := range strings.Fields(value) {
+				if err := s.include(path, pattern, patterns, depth); err != nil {
+					return err
+				}
+			}
+		default:
+			s.entries = append(s.entries, sshConfigEntry{patterns: patterns, key: key, value: value})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read ssh config %s: %w", path, err)
+	}
+	return nil
+}
+
+// include parses every file matched by an Include pattern. As in OpenSSH, relative patterns
+// are taken from ~/.ssh for a user configuration and /etc/ssh for the system one, whichever
+// file includes them.
+func (s *sshConfig) include(from, pattern string, patterns []string, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(s.includeDir, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("ssh config %s: bad Include pattern %q: %w", from, pattern, err)
+	}
+	for _, match := range matches {
+		if err := s.parseFile(match, patterns, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitSSHConfigLine returns the lower-cased keyword and the unquoted value of a line.
+// Keyword and value are separated by white space or a single "=".
+func splitSSHConfigLine(line string) (string, string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", nil
+	}
+
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return "", "", fmt.Errorf("missing value for %s", line)
+	}
+	key := strings.ToLower(line[:i])
+	value := strings.TrimSpace(line[i:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+
+	if strings.HasPrefix(value, `"`) {
+		end := strings.Index(value[1:], `"`)
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated quote in %s", line)
+		}
+		value = value[1 : end+1]
+	}
+	if value == "" {
+		return "", "", fmt.Errorf("missing value for %s", key)
+	}
+	return key, value, nil
+}
+
+// lookup returns the settings that apply to alias. As with OpenSSH the first value
+// obtained for a keyword wins, except IdentityFile which accumulates.
+func (s *sshConfig) lookup(alias string) map[string][]string {
+	settings := map[string][]string{}
+	for _, entry := range s.entries {
+		if entry.patterns != nil && !matchHostPatterns(entry.patterns, alias) {
+			continue
+		}
+		if _, ok := settings[entry.key]; ok && entry.key != "identityfile" {
+			continue
+		}
+		settings[entry.key] = append(settings[entry.key], entry.value)
+	}
+	return settings
+}
+
+// matchHostPatterns reports whether host matches a Host line: at least one pattern must
+// match and no negated ("!") pattern may match.
+func matchHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if !matchHostPattern(strings.TrimPrefix(pattern, "!"), host) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// matchHostPattern matches host against a pattern where "*" stands for any run of
+// characters and "?" for exactly one.
+func matchHostPattern(pattern, host string) bool {
+	var expr strings.Builder
+	expr.WriteString("(?i)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	re, err := compilePattern(expr.String())
+	return err == nil && re.MatchString(host)
+}
+
+// applySSHConfig fills the connection settings for alias from the ssh config, leaving
+// settings already given through options untouched, and returns the host to connect to.
+func (c *SSHConnModel) applySSHConfig(alias string) (string, error) {
+	settings := c.sshConfig.lookup(alias)
+
+	host := alias
+	if v := settings["hostname"]; v != nil {
+		host = strings.ReplaceAll(v[0], "%h", alias)
+	}
+	if v := settings["user"]; v != nil && c.Username == "" {
+		c.Username = v[0]
+	}
+	if v := settings["port"]; v != nil && c.port == 0 && c.Protocol == "ssh" {
+		port, err := strconv.ParseUint(v[0], 10, 16)
+		if err != nil {
+			return "", fmt.Errorf("ssh config for %s: invalid Port %q", alias, v[0])
+		}
+		c.port = uint16(port)
+	}
+	if len(c.Keys) == 0 {
+		for _, path := range settings["identityfile"] {
+			path = expandSSHConfigTokens(path, alias, host, c.Username)
+			if _, err := os.Stat(path); err != nil {
+				log.Debugf("Skipping IdentityFile %s from ssh config: %v", path, err)
+				continue
+			}
+			c.AddPrivateKey(path, "")
+		}
+	}
+	if v := settings["proxyjump"]; v != nil && len(c.JumpHosts) == 0 && !strings.EqualFold(v[0], "none") {
+		for _, hop := range strings.Split(v[0], ",") {
+			jump, err := c.sshConfigJumpHost(strings.TrimSpace(hop))
+			if err != nil {
+				return "", fmt.Errorf("ssh config for %s: ProxyJump %s: %w", alias, hop, err)
+			}
+			c.AddJumpHost(jump)
+		}
+	}
+
+	log.Debugf("Resolved %s from ssh config to %s", alias, host)
+	return host, nil
+}
+
+// sshConfigJumpHost builds a jump host from a ProxyJump hop, "[user@]host[:port]". The hop
+// is itself resolved through the ssh config and falls back to the username, agent and host
+// key settings of the device. The device password is not sent to the hop: it logs in with
+// its identity files, the agent, or the credentials set on it after NewConnection.
+func (c *SSHConnModel) sshConfigJumpHost(hop string) (*SSHConnModel, error) {
+	var user string
+	if i := strings.LastIndex(hop, "@"); i >= 0 {
+		user, hop = hop[:i], hop[i+1:]
+	}
+
+	var port uint16
+	host := hop
+	if strings.HasPrefix(hop, "[") || strings.Count(hop, ":") == 1 {
+		h, p, err := splitHostPort(hop)
+		if err != nil {
+			return nil, err
+		}
+		host, port = h, p
+	}
+
+	jump := &SSHConnModel{
+		Timeout:        c.Timeout,
+		Protocol:       "ssh",
+		Username:       user,
+		UseAgent:       c.UseAgent,
+		HostKeyPolicy:  c.HostKeyPolicy,
+		KnownHostsFile: c.KnownHostsFile,
+		ConnectTimeout: c.ConnectTimeout,
+		port:           port,
+		sshConfig:      &sshConfig{entries: withoutProxyJump(c.sshConfig.entries)},
+	}
+	resolved, err := jump.applySSHConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	if jump.Username == "" {
+		jump.Username = c.Username
+	}
+	if jump.port == 0 {
+		jump.port = defaultPorts["ssh"]
+	}
+	jump.Addr = JoinHostPort(resolved, jump.port)
+	return jump, nil
+}
+
+// withoutProxyJump drops the ProxyJump entries so that resolving a hop cannot loop.
+func withoutProxyJump(entries []sshConfigEntry) []sshConfigEntry {
+	var kept []sshConfigEntry
+	for _, entry := range entries {
+		if entry.key != "proxyjump" {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// splitHostPort splits "host:port" or "[host]:port".
+func splitHostPort(hostport string) (string, uint16, error) {
+	i := strings.LastIndex(hostport, ":")
+	if i < 0 || strings.HasSuffix(hostport, "]") {
+		return strings.Trim(hostport, "[]"), 0, nil
+	}
+	port, err := strconv.ParseUint(hostport[i+1:], 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q", hostport)
+	}
+	return strings.Trim(hostport[:i], "[]"), uint16(port), nil
+}
+
+// expandSSHConfigTokens expands "~" and the %d, %h, %n, %r and %% tokens of a path.
+func expandSSHConfigTokens(path, alias, host, user string) string {
+	home, _ := os.UserHomeDir()
+	path = expandHome(path)
+	replacer := strings.NewReplacer("%%", "%", "%d", home, "%h", host, "%n", alias, "%r", user)
+	return replacer.Replace(path)
+}
+
+// expandHome replaces a leading "~/" with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
@@ -0,0 +1,161 @@
+package netmigo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitSSHConfigLine(t *testing.T) {
+	tests := []struct {
+		line      string
+		wantKey   string
+		wantValue string
+		wantErr   bool
+	}{
+		{line: "", wantKey: ""},
+		{line: "   # comment", wantKey: ""},
+		{line: "HostName 192.0.2.1", wantKey: "hostname", wantValue: "192.0.2.1"},
+		{line: "\tPort=2222", wantKey: "port", wantValue: "2222"},
+		{line: "User = admin", wantKey: "user", wantValue: "admin"},
+		{line: `IdentityFile "~/.ssh/my key"`, wantKey: "identityfile", wantValue: "~/.ssh/my key"},
+		{line: "Host", wantErr: true},
+		{line: `IdentityFile "~/.ssh/key`, wantErr: true},
+		{line: "User =", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			key, value, err := splitSSHConfigLine(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitSSHConfigLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if key != tt.wantKey || value != tt.wantValue {
+				t.Errorf("splitSSHConfigLine() = %q, %q, want %q, %q", key, value, tt.wantKey, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestMatchHostPatterns(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		host     string
+		want     bool
+	}{
+		{patterns: []string{"r1"}, host: "r1", want: true},
+		{patterns: []string{"R1"}, host: "r1", want: true},
+		{patterns: []string{"r?"}, host: "r1", want: true},
+		{patterns: []string{"r?"}, host: "r10", want: false},
+		{patterns: []string{"*.lab"}, host: "pe1.lab", want: true},
+		{patterns: []string{"*.lab"}, host: "pe1.lab.example", want: false},
+		{patterns: []string{"*", "!bastion"}, host: "bastion", want: false},
+		{patterns: []string{"!bastion"}, host: "r1", want: false},
+		{patterns: []string{"10.0.0.*"}, host: "10.0.0.1", want: true},
+		{patterns: []string{"10.0.0.*"}, host: "10a0b0c1", want: false},
+	}
+	for _, tt := range tests {
+		if got := matchHostPatterns(tt.patterns, tt.host); got != tt.want {
+			t.Errorf("matchHostPatterns(%q, %q) = %v, want %v", tt.patterns, tt.host, got, tt.want)
+		}
+	}
+}
+
+// writeFiles creates files, relative to dir, with their contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSSHConfigLookup(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeFiles(t, home, map[string]string{
+		// Relative includes resolve against ~/.ssh, even from a file elsewhere
+		"configs/lab.conf":  "User global\n\nHost pe*\n  Include lab.d/*.conf\n  Port 2222\n\nHost *\n  User fallback\n  IdentityFile ~/.ssh/id_all\n",
+		".ssh/lab.d/a.conf": "HostName 192.0.2.%h\nIdentityFile ~/.ssh/id_pe\nInclude nested.conf\n",
+		".ssh/nested.conf":  "ProxyJump jump@bastion:2200\n",
+	})
+
+	config, err := loadSSHConfig(filepath.Join(home, "configs", "lab.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		alias string
+		want  map[string][]string
+	}{
+		{
+			alias: "pe1",
+			want: map[string][]string{
+				"user":         {"global"},
+				"hostname":     {"192.0.2.%h"},
+				"identityfile": {"~/.ssh/id_pe", "~/.ssh/id_all"},
+				"proxyjump":    {"jump@bastion:2200"},
+				"port":         {"2222"},
+			},
+		},
+		{
+			alias: "core1",
+			want: map[string][]string{
+				"user":         {"global"},
+				"identityfile": {"~/.ssh/id_all"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			if got := config.lookup(tt.alias); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSHConfigIncludeLoop(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeFiles(t, home, map[string]string{".ssh/config": "Include config\n"})
+	if _, err := loadSSHConfig(filepath.Join(home, ".ssh", "config")); err == nil {
+		t.Error("loadSSHConfig() of a file including itself succeeded")
+	}
+}
+
+func TestApplySSHConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeFiles(t, home, map[string]string{
+		".ssh/config": "Host pe1\n  HostName 192.0.2.1\n  User admin\n  Port 2222\n  ProxyJump ops@bastion\n\nHost bastion\n  HostName 198.51.100.1\n  Port 2200\n",
+	})
+
+	c, err := NewConnection("pe1", WithSSHConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != "192.0.2.1:2222" || c.Username != "admin" {
+		t.Errorf("connection to %s as %s, want 192.0.2.1:2222 as admin", c.Addr, c.Username)
+	}
+	if len(c.JumpHosts) != 1 || c.JumpHosts[0].Addr != "198.51.100.1:2200" || c.JumpHosts[0].Username != "ops" {
+		t.Errorf("jump hosts = %+v, want ops@198.51.100.1:2200", c.JumpHosts)
+	}
+
+	// Options win over the file
+	c, err = NewConnection("pe1", WithSSHConfig(""), WithPort(22), WithCredentials("netops", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != "192.0.2.1:22" || c.Username != "netops" {
+		t.Errorf("connection to %s as %s, want 192.0.2.1:22 as netops", c.Addr, c.Username)
+	}
+	if c.JumpHosts[0].Password != "" {
+		t.Error("the device password was handed to the jump host")
+	}
+}
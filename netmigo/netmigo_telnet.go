@@ -0,0 +1,343 @@
+package netmigo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Telnet commands and options (RFC 854, 857, 858, 1073, 1091).
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetSE   = 240
+
+	telnetOptEcho  = 1
+	telnetOptSGA   = 3
+	telnetOptTType = 24
+	telnetOptNAWS  = 31
+
+	telnetTTypeIs   = 0
+	telnetTTypeSend = 1
+)
+
+var (
+	telnetUsernamePrompt = regexp.MustCompile(`(?i)(user\s*name|login)\s*:\s*$`)
+	telnetPasswordPrompt = regexp.MustCompile(`(?i)password\s*:\s*$`)
+	telnetLoginFailed    = regexp.MustCompile(`(?i)(login incorrect|authentication failed|login invalid|access denied)`)
+	telnetShellPrompt    = regexp.MustCompile(`[>#%$\]][ \t]*$`)
+)
+
+// Login prompts are only taken as such once the output has stayed quiet for promptSettleTime,
+// so that a "#" or "login:" in a banner still being sent does not end the dialog early.
+const promptSettleTime = 200 * time.Millisecond
+
+// loginWindow is how much of the end of the login output the prompts are matched against.
+const loginWindow = 1024
+
+// telnetConn is a telnet client connection. Read strips option negotiation from the data
+// stream and answers it, Write escapes IAC bytes and sends NVT line endings.
+type telnetConn struct {
+	conn     net.Conn
+	termType string
+	width    uint16
+	height   uint16
+
+	writeMu sync.Mutex
+	pending []byte // data consumed during login and handed back to the caller
+	raw     []byte // unparsed bytes from the network
+	state   map[byte]bool
+}
+
+// newTelnetConn starts a telnet session on conn and offers terminal type, window size and
+// suppress-go-ahead.
+func newTelnetConn(conn net.Conn, termType string, width, height uint16) (*telnetConn, error) {
+	t := &telnetConn{
+		conn:     conn,
+		termType: termType,
+		width:    width,
+		height:   height,
+		state:    make(map[byte]bool),
+	}
+	offer := []byte{
+		telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetWILL, telnetOptTType,
+		telnetIAC, telnetWILL, telnetOptNAWS,
+	}
+	t.state[telnetOptTType] = true
+	t.state[telnetOptNAWS] = true
+	if err := t.writeRaw(offer); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Read returns data bytes received from the device, with telnet commands removed.
+func (t *telnetConn) Read(p []byte) (int, error) {
+	if len(t.pending) > 0 {
+		n := copy(p, t.pending)
+		t.pending = t.pending[n:]
+		return n, nil
+	}
+
+	buff := make([]byte, 4096)
+	for {
+		n, err := t.conn.Read(buff)
+		if n > 0 {
+			t.raw = append(t.raw, buff[:n]...)
+			data := t.parse()
+			if len(data) > 0 {
+				copied := copy(p, data)
+				t.pending = append(t.pending, data[copied:]...)
+				return copied, nil
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// parse consumes complete telnet commands from t.raw, answers negotiation requests and
+// returns the data bytes. Incomplete commands are kept for the next read.
+func (t *telnetConn) parse() []byte {
+	var data []byte
+	i := 0
+	for i < len(t.raw) {
+		b := t.raw[i]
+		if b != telnetIAC {
+			data = append(data, b)
+			i++
+			continue
+		}
+		if i+1 >= len(t.raw) {
+			break
+		}
+		cmd := t.raw[i+1]
+		switch cmd {
+		case telnetIAC:
+			data = append(data, telnetIAC)
+			i += 2
+		case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+			if i+2 >= len(t.raw) {
+				t.raw = t.raw[i:]
+				return data
+			}
+			t.negotiate(cmd, t.raw[i+2])
+			i += 3
+		case telnetSB:
+			end := bytes.Index(t.raw[i:], []byte{telnetIAC, telnetSE})
+			if end < 0 {
+				t.raw = t.raw[i:]
+				return data
+			}
+			t.subnegotiate(t.raw[i+2 : i+end])
+			i += end + 2
+		default:
+			// NOP, GA, AYT and friends carry no data
+			i += 2
+		}
+	}
+	t.raw = t.raw[i:]
+	return data
+}
+
+// negotiate answers a single DO/DONT/WILL/WONT request.
+func (t *telnetConn) negotiate(cmd, opt byte) {
+	var reply []byte
+	switch cmd {
+	case telnetDO:
+		switch opt {
+		case telnetOptTType, telnetOptNAWS, telnetOptSGA:
+			if !t.state[opt] {
+				t.state[opt] = true
+				reply = []byte{telnetIAC, telnetWILL, opt}
+			}
+			if opt == telnetOptNAWS {
+				reply = append(reply, t.windowSize()...)
+			}
+		default:
+			// Includes ECHO: the device echoes, we never do.
+			reply = []byte{telnetIAC, telnetWONT, opt}
+		}
+	case telnetWILL:
+		switch opt {
+		case telnetOptEcho, telnetOptSGA:
+			reply = []byte{telnetIAC, telnetDO, opt}
+		default:
+			reply = []byte{telnetIAC, telnetDONT, opt}
+		}
+	case telnetDONT:
+		if t.state[opt] {
+			t.state[opt] = false
+			reply = []byte{telnetIAC, telnetWONT, opt}
+		}
+	case telnetWONT:
+		// Nothing to do, the device simply refuses the option.
+	}
+	if reply != nil {
+		if err := t.writeRaw(reply); err != nil {
+			log.Errorf("Failed to answer telnet negotiation: %v", err)
+		}
+	}
+}
+
+// subnegotiate answers a terminal type request. body starts after IAC SB.
+func (t *telnetConn) subnegotiate(body []byte) {
+	if len(body) >= 2 && body[0] == telnetOptTType && body[1] == telnetTTypeSend {
+		reply := []byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeIs}
+		reply = append(reply, []byte(t.termType)...)
+		reply = append(reply, telnetIAC, telnetSE)
+		if err := t.writeRaw(reply); err != nil {
+			log.Errorf("Failed to send telnet terminal type: %v", err)
+		}
+	}
+}
+
+// windowSize returns the NAWS subnegotiation for the configured terminal size.
+func (t *telnetConn) windowSize() []byte {
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint16(size[0:], t.width)
+	binary.BigEndian.PutUint16(size[2:], t.height)
+
+	msg := []byte{telnetIAC, telnetSB, telnetOptNAWS}
+	for _, b := range size {
+		msg = append(msg, b)
+		if b == telnetIAC {
+			msg = append(msg, telnetIAC)
+		}
+	}
+	return append(msg, telnetIAC, telnetSE)
+}
+
+// Write sends data to the device, escaping IAC and translating "\n" to CR LF.
+func (t *telnetConn) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+8)
+	for _, b := range p {
+		switch b {
+		case telnetIAC:
+			out = append(out, telnetIAC, telnetIAC)
+		case '\n':
+			out = append(out, '\r', '\n')
+		default:
+			out = append(out, b)
+		}
+	}
+	if err := t.writeRaw(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *telnetConn) writeRaw(b []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err := t.conn.Write(b)
+	return err
+}
+
+// Close closes the telnet connection.
+func (t *telnetConn) Close() error {
+	return t.conn.Close()
+}
+
+// login answers the Username:/Password: dialog. Devices that go straight to a CLI prompt,
+// or only ask for a password, are handled as well. Output read after the password is
+// handed back to the caller so that prompt discovery still sees it.
+func (t *telnetConn) login(username, password string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	t.conn.SetReadDeadline(deadline)
+	defer t.conn.SetReadDeadline(time.Time{})
+
+	out, err := t.readUntil(deadline, telnetUsernamePrompt, telnetPasswordPrompt, telnetShellPrompt)
+	if err != nil {
+		return fmt.Errorf("telnet login, waiting for username prompt: %w", err)
+	}
+	if telnetUsernamePrompt.MatchString(out) {
+		if _, err := t.Write([]byte(username + "\n")); err != nil {
+			return err
+		}
+		out, err = t.readUntil(deadline, telnetPasswordPrompt, telnetShellPrompt)
+		if err != nil {
+			return fmt.Errorf("telnet login, waiting for password prompt: %w", err)
+		}
+	}
+	if !telnetPasswordPrompt.MatchString(out) {
+		// No password asked, we are at the CLI already.
+		t.pending = append([]byte(out), t.pending...)
+		return nil
+	}
+
+	if _, err := t.Write([]byte(password + "\n")); err != nil {
+		return err
+	}
+	out, err = t.readUntil(deadline, telnetLoginFailed, telnetUsernamePrompt, telnetShellPrompt)
+	if err != nil {
+		return fmt.Errorf("telnet login, waiting for CLI prompt: %w", err)
+	}
+	if telnetLoginFailed.MatchString(out) || telnetUsernamePrompt.MatchString(out) {
+		return &AuthError{Username: username, Err: errors.New("telnet login rejected")}
+	}
+	t.pending = append([]byte(out), t.pending...)
+	return nil
+}
+
+// readUntil reads until one of the patterns matches the end of the received text and no more
+// text follows within promptSettleTime. deadline is the read deadline of the whole login.
+func (t *telnetConn) readUntil(deadline time.Time, patterns ...*regexp.Regexp) (string, error) {
+	var out []byte
+	buff := make([]byte, 4096)
+	for {
+		n, err := t.Read(buff)
+		out = append(out, buff[:n]...)
+		if err != nil {
+			return string(out), err
+		}
+		if !matchesTail(out, patterns) {
+			continue
+		}
+
+		settle := time.Now().Add(promptSettleTime)
+		if settle.After(deadline) {
+			settle = deadline
+		}
+		t.conn.SetReadDeadline(settle)
+		n, err = t.Read(buff)
+		t.conn.SetReadDeadline(deadline)
+		out = append(out, buff[:n]...)
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, io.EOF):
+			// Nothing followed the prompt, or the device hung up right after it
+			return string(out), nil
+		case err != nil:
+			return string(out), err
+		}
+	}
+}
+
+// matchesTail reports whether one of patterns matches the last loginWindow bytes of out,
+// with escape sequences removed.
+func matchesTail(out []byte, patterns []*regexp.Regexp) bool {
+	tail := out[max(0, len(out)-loginWindow):]
+	clean := cleanOutput(string(tail))
+	for _, r := range patterns {
+		if r.MatchString(clean) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package netmigo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordConn is a net.Conn that records what is written to it.
+type recordConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	return c.written.Write(p)
+}
+
+func TestTelnetParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   []byte
+		data  string
+		reply []byte
+		rest  []byte
+	}{
+		{
+			name: "plain data",
+			raw:  []byte("Username: "),
+			data: "Username: ",
+		},
+		{
+			name: "escaped IAC",
+			raw:  []byte{'a', telnetIAC, telnetIAC, 'b'},
+			data: "a\xffb",
+		},
+		{
+			name:  "will echo",
+			raw:   []byte{telnetIAC, telnetWILL, telnetOptEcho, 'x'},
+			data:  "x",
+			reply: []byte{telnetIAC, telnetDO, telnetOptEcho},
+		},
+		{
+			name:  "do echo refused",
+			raw:   []byte{telnetIAC, telnetDO, telnetOptEcho},
+			reply: []byte{telnetIAC, telnetWONT, telnetOptEcho},
+		},
+		{
+			name:  "unknown option refused",
+			raw:   []byte{telnetIAC, telnetWILL, 42},
+			reply: []byte{telnetIAC, telnetDONT, 42},
+		},
+		{
+			name:  "do naws sends window size",
+			raw:   []byte{telnetIAC, telnetDO, telnetOptNAWS},
+			reply: []byte{telnetIAC, telnetSB, telnetOptNAWS, 0, 200, 0, 50, telnetIAC, telnetSE},
+		},
+		{
+			name:  "terminal type request",
+			raw:   []byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeSend, telnetIAC, telnetSE, '>'},
+			data:  ">",
+			reply: append(append([]byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeIs}, "vt100"...), telnetIAC, telnetSE),
+		},
+		{
+			name: "incomplete command kept",
+			raw:  []byte{'a', telnetIAC, telnetDO},
+			data: "a",
+			rest: []byte{telnetIAC, telnetDO},
+		},
+		{
+			name: "incomplete subnegotiation kept",
+			raw:  []byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeSend},
+			rest: []byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeSend},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordConn{}
+			tc := &telnetConn{
+				conn:     conn,
+				termType: "vt100",
+				width:    200,
+				height:   50,
+				state:    map[byte]bool{telnetOptTType: true, telnetOptNAWS: true},
+				raw:      tt.raw,
+			}
+			if got := string(tc.parse()); got != tt.data {
+				t.Errorf("data = %q, want %q", got, tt.data)
+			}
+			if got := conn.written.Bytes(); !bytes.Equal(got, tt.reply) {
+				t.Errorf("reply = %v, want %v", got, tt.reply)
+			}
+			if !bytes.Equal(tc.raw, tt.rest) {
+				t.Errorf("rest = %v, want %v", tc.raw, tt.rest)
+			}
+		})
+	}
+}
+
+func TestTelnetWrite(t *testing.T) {
+	conn := &recordConn{}
+	tc := &telnetConn{conn: conn}
+	if _, err := tc.Write([]byte("show\xff\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conn.written.String(), "show\xff\xff\r\n"; got != want {
+		t.Errorf("written = %q, want %q", got, want)
+	}
+}
+
+// telnetDevice serves one telnet login on a local listener. The banner is written in the
+// given pieces, with a pause between them, before the username prompt.
+func telnetDevice(t *testing.T, banner []string, password string) (net.Conn, <-chan []string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		defer func() { received <- lines }()
+
+		for _, piece := range banner {
+			conn.Write([]byte(piece))
+			time.Sleep(50 * time.Millisecond)
+		}
+		reader := bufio.NewReader(conn)
+		readLine := func() bool {
+			line, err := reader.ReadString('\n')
+			// Skip the option negotiation the client opens with
+			if i := strings.LastIndexByte(line, telnetIAC); i >= 0 && i+3 <= len(line) {
+				line = line[i+3:]
+			}
+			lines = append(lines, strings.TrimSpace(line))
+			return err == nil
+		}
+		conn.Write([]byte("Username: "))
+		if !readLine() {
+			return
+		}
+		conn.Write([]byte("Password: "))
+		if !readLine() {
+			return
+		}
+		if lines[1] != password {
+			conn.Write([]byte("\r\nLogin incorrect\r\nUsername: "))
+			return
+		}
+		conn.Write([]byte("\r\nrouter#"))
+		io.Copy(io.Discard, conn)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, received
+}
+
+func TestTelnetLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		banner   []string
+		password string
+		wantErr  error
+	}{
+		{
+			name:     "no banner",
+			password: "secret",
+		},
+		{
+			name:     "banner with prompt characters",
+			banner:   []string{"####################\r\n# Authorized use only #", "\r\n# login: audited >", "\r\n"},
+			password: "secret",
+		},
+		{
+			name:     "rejected password",
+			password: "wrong",
+			wantErr:  ErrAuthFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, received := telnetDevice(t, tt.banner, "secret")
+			tc, err := newTelnetConn(conn, "vt100", 200, 50)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tc.login("admin", tt.password, 5*time.Second)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("login() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !strings.HasSuffix(string(tc.pending), "router#") {
+				t.Errorf("pending = %q, want the CLI prompt", tc.pending)
+			}
+			conn.Close()
+			if lines := <-received; len(lines) < 2 || lines[0] != "admin" || lines[1] != tt.password {
+				t.Errorf("device received %q, want username and password", lines)
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package netmigo
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -19,142 +22,330 @@ type SSHConnModel struct {
 	Reader   io.Reader
 	Writer   io.WriteCloser
 	Timeout  uint8
-}
+	Protocol string
+	Terminal *Terminal
+	Keys     []SSHKey
+	UseAgent bool
+
+	HostKeyPolicy       HostKeyPolicy
+	KnownHostsFile      string
+	HostKeyFingerprints []string
+
+	JumpHosts []*SSHConnModel
+	Dialer    Dialer
+
+	AlgorithmProfile string
+	Algorithms       Algorithms
+
+	Console bool // console line behind a terminal server, see WithConsole
+
+	CredentialProviders []CredentialProvider
 
-// Supported ciphers for SSH connections.
-var ciphers = []string{
-	"aes256-ctr", "aes128-ctr", "aes128-cbc", "3des-cbc",
-	"aes192-ctr", "aes192-cbc", "aes256-cbc", "aes128-gcm@openssh.com",
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	CommandTimeout time.Duration
+
+	KeepaliveInterval  time.Duration
+	KeepaliveMaxMissed int
+	ReconnectAttempts  int
+
+	agentConn   net.Conn
+	jumpClients []*ssh.Client
+	telnet      *telnetConn
+	session     *ssh.Session
+	port        uint16
+	echo        *bool
+	parent      *SSHConnModel // owner of the shared SSH client, for extra channels
+	sshConfig   *sshConfig
+
+	broken        atomic.Bool
+	keepaliveStop chan struct{}
 }
 
-func NewSSHConnModel(hostname, username, password string, port uint8) (*SSHConnModel, error) {
-	addr := fmt.Sprintf("%s:%d", hostname, port)
-	return &SSHConnModel{
-		Addr:     addr,
-		Username: username,
-		Password: password,
-		Timeout:  6, // Default timeout is 6 seconds
-	}, nil
+// NewSSHConnModel creates an SSH connection to hostname. It is a shorthand for NewConnection.
+func NewSSHConnModel(hostname, username, password string, port uint16) (*SSHConnModel, error) {
+	return NewConnection(hostname, WithCredentials(username, password), WithPort(port))
 }
 
+// SetTimeout sets the connect timeout and the read timeout, in seconds.
 func (c *SSHConnModel) SetTimeout(timeout uint8) {
 	c.Timeout = timeout
+	c.ReadTimeout = time.Duration(timeout) * time.Second
 }
 
-// Connect establishes an SSH connection to the device.
-func (c *SSHConnModel) Connect() error {
-	interactive := getInteractiveCallBack(c.Password)
-	sshConfig := &ssh.ClientConfig{
-		User:            c.Username,
-		Auth:            []ssh.AuthMethod{ssh.Password(c.Password), ssh.KeyboardInteractive(interactive)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         time.Duration(c.Timeout) * time.Second,
+// ClientConfig builds the SSH client configuration shared by the shell session, SFTP and SCP.
+func (c *SSHConnModel) ClientConfig() (*ssh.ClientConfig, error) {
+	auth, err := c.authMethods()
+	if err != nil {
+		return nil, err
 	}
-	sshConfig.Ciphers = append(sshConfig.Ciphers, ciphers...)
-
-	conn, err := ssh.Dial("tcp", c.Addr, sshConfig)
+	hostKeyCallback, err := c.hostKeyCallback()
 	if err != nil {
-		return errors.New("failed to connect to device: " + err.Error())
+		return nil, err
 	}
-	c.Client = conn
-
-	session, err := c.Client.NewSession()
+	algorithms, err := c.algorithms()
 	if err != nil {
-		return errors.New("failed to start a new session: " + err.Error())
+		return nil, err
+	}
+	if len(c.Algorithms.HostKeys) == 0 && (c.HostKeyPolicy == HostKeyKnownHosts || c.HostKeyPolicy == HostKeyTOFU) {
+		algorithms.HostKeys = preferKnownHostKeys(c.KnownHostsFile, c.Addr, algorithms.HostKeys)
 	}
+	sshConfig := &ssh.ClientConfig{
+		User:              c.Username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: algorithms.HostKeys,
+		Timeout:           c.connectTimeout(),
+	}
+	sshConfig.KeyExchanges = algorithms.KeyExchanges
+	sshConfig.MACs = algorithms.MACs
+	sshConfig.Ciphers = algorithms.Ciphers
+	return sshConfig, nil
+}
 
-	reader, _ := session.StdoutPipe()
-	writer, _ := session.StdinPipe()
+// Connect establishes an SSH connection to the device.
+func (c *SSHConnModel) Connect() error {
+	return c.ConnectContext(context.Background())
+}
 
-	c.Reader = reader
-	c.Writer = writer
+// ConnectContext establishes an SSH connection to the device, aborting when ctx is done.
+func (c *SSHConnModel) ConnectContext(ctx context.Context) error {
+	if c.Protocol == "telnet" {
+		return c.connectTelnet(ctx, c.terminal(defaultTerminal))
+	}
 
-	modes := ssh.TerminalModes{
-		ssh.ECHO:          1,
-		ssh.TTY_OP_ISPEED: 14400,
-		ssh.TTY_OP_OSPEED: 14400,
+	return c.openShell(ctx, c.terminal(defaultTerminal), c.echoMode(true))
+}
+
+// NewChannel returns a connection for an extra shell channel over the SSH client of c. The
+// channel has its own session and output reader, so it can run commands concurrently
+// with c; its Connect or ConnectXterm opens the shell without a new TCP connection or SSH
+// handshake. Disconnecting c closes the shared client and with it every channel.
+func (c *SSHConnModel) NewChannel() (*SSHConnModel, error) {
+	if c.Protocol == "telnet" {
+		return nil, errors.New("extra channels are not supported over telnet")
+	}
+	if c.Client == nil {
+		return nil, errors.New("not connected to device, make sure to call .Connect() first")
 	}
+	return &SSHConnModel{
+		Addr:           c.Addr,
+		Username:       c.Username,
+		Timeout:        c.Timeout,
+		Protocol:       c.Protocol,
+		Terminal:       c.Terminal,
+		ConnectTimeout: c.ConnectTimeout,
+		ReadTimeout:    c.ReadTimeout,
+		CommandTimeout: c.CommandTimeout,
+		echo:           c.echo,
+		parent:         c,
+	}, nil
+}
 
-	if err := session.RequestPty("vt100", 0, 200, modes); err != nil {
-		return errors.New("failed to request Pty: " + err.Error())
+// sessionClient returns the SSH client to open the shell on: the shared client for an
+// extra channel, a newly dialled one otherwise.
+func (c *SSHConnModel) sessionClient(ctx context.Context) (*ssh.Client, error) {
+	if c.parent == nil {
+		return c.DialContext(ctx)
 	}
-	if err := session.Shell(); err != nil {
-		return errors.New("failed to invoke shell: " + err.Error())
+	if c.parent.Client == nil || !c.parent.Alive() {
+		return nil, errors.New("the connection owning this channel is closed")
 	}
-	return nil
+	return c.parent.Client, nil
 }
 
 // Connect with xterm
 
 func (c *SSHConnModel) ConnectXterm() error {
-	interactive := getInteractiveCallBack(c.Password)
-	sshConfig := &ssh.ClientConfig{
-		User:            c.Username,
-		Auth:            []ssh.AuthMethod{ssh.Password(c.Password), ssh.KeyboardInteractive(interactive)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         time.Duration(c.Timeout) * time.Second,
+	return c.ConnectXtermContext(context.Background())
+}
+
+// ConnectXtermContext connects with an xterm terminal, aborting when ctx is done.
+func (c *SSHConnModel) ConnectXtermContext(ctx context.Context) error {
+	if c.Protocol == "telnet" {
+		return c.connectTelnet(ctx, c.terminal(defaultXtermTerminal))
 	}
-	sshConfig.Ciphers = append(sshConfig.Ciphers, ciphers...)
 
-	conn, err := ssh.Dial("tcp", c.Addr, sshConfig)
+	return c.openShell(ctx, c.terminal(defaultXtermTerminal), c.echoMode(false))
+}
+
+// openShell connects the SSH client, unless c is an extra channel, and starts an
+// interactive shell on a pseudo terminal. On failure everything opened is closed again.
+func (c *SSHConnModel) openShell(ctx context.Context, term Terminal, echo bool) error {
+	conn, err := c.sessionClient(ctx)
 	if err != nil {
-		log.Error("failed to connect to device: " + err.Error())
+		return fmt.Errorf("failed to connect to device: %w", err)
 	}
 	c.Client = conn
 
 	session, err := c.Client.NewSession()
 	if err != nil {
-		log.Error("failed to start a new session: " + err.Error())
+		c.Disconnect()
+		return fmt.Errorf("failed to start a new session: %w", err)
+	}
+	fail := func(err error) error {
+		session.Close()
+		c.Disconnect()
+		return err
 	}
 
 	reader, err := session.StdoutPipe()
 	if err != nil {
-		log.Fatalf("Unable to setup stdin for session: %v", err)
+		return fail(fmt.Errorf("failed to set up session output: %w", err))
 	}
-
 	writer, err := session.StdinPipe()
 	if err != nil {
-		log.Fatalf("Unable to setup stdout for session: %v", err)
+		return fail(fmt.Errorf("failed to set up session input: %w", err))
 	}
 
-	c.Reader = reader
-	c.Writer = writer
-
 	modes := ssh.TerminalModes{
-		ssh.ECHO:          0,
+		ssh.ECHO:          echoFlag(echo),
 		ssh.TTY_OP_ISPEED: 14400,
 		ssh.TTY_OP_OSPEED: 14400,
 	}
-
-	if err := session.RequestPty("xterm", 80, 100, modes); err != nil {
-		log.Errorf("request for pseudo terminal failed: %v", err)
+	if err := session.RequestPty(term.Type, term.Height, term.Width, modes); err != nil {
+		return fail(fmt.Errorf("failed to request pty: %w", err))
 	}
-
 	if err := session.Shell(); err != nil {
-		log.Errorf("Failed to start shell: %v", err)
+		return fail(fmt.Errorf("failed to invoke shell: %w", err))
+	}
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+
+	c.session = session
+	c.Reader = newSessionReader(reader)
+	c.Writer = writer
+	c.startKeepalive()
+	return nil
+}
+
+// connectTelnet opens a telnet session and answers the login dialog.
+func (c *SSHConnModel) connectTelnet(ctx context.Context, term Terminal) error {
+	var conn *telnetConn
+	err := c.tryCredentials(ctx, func() error {
+		var err error
+		conn, err = c.dialTelnet(ctx, term)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
+	c.telnet = conn
+	c.Reader = newSessionReader(conn)
+	c.Writer = conn
+	c.startKeepalive()
 	return nil
 }
 
-// Disconnect closes the SSH connection.
+// dialTelnet connects and, unless the connection is a console line, logs in.
+func (c *SSHConnModel) dialTelnet(ctx context.Context, term Terminal) (*telnetConn, error) {
+	timeout := c.connectTimeout()
+	tcpConn, err := c.dial(ctx, c.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to device: %w", err)
+	}
+	conn, err := newTelnetConn(tcpConn, term.Type, uint16(term.Width), uint16(term.Height))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to device: %w", err)
+	}
+	if c.Console {
+		// Console lines log in through AttachConsole once the line is awake.
+		return conn, nil
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	err = conn.login(c.Username, c.Password, timeout)
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		var authErr *AuthError
+		if errors.As(err, &authErr) {
+			authErr.Host = c.Addr
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Disconnect closes the SSH connection. On an extra channel only the channel is closed,
+// the shared SSH client stays open.
 func (c *SSHConnModel) Disconnect() {
+	c.stopKeepalive()
+	if c.parent != nil {
+		if c.session != nil {
+			c.session.Close()
+		}
+		c.session = nil
+		c.Client = nil
+		return
+	}
+	if c.telnet != nil {
+		if err := c.telnet.Close(); err != nil {
+			log.Println("warning, device close failed: ", err)
+		}
+		c.telnet = nil
+		return
+	}
+	defer c.closeAgent()
+	defer c.closeJumpHosts()
+	c.session = nil
+	if c.Client == nil {
+		return
+	}
 	if err := c.Client.Close(); err != nil {
 		log.Println("warning, device close failed: ", err)
 	}
+	c.Client = nil
 }
 
 // Read reads data from the SSH connection.
 func (c *SSHConnModel) Read() (string, error) {
-	buff := make([]byte, 2)
-	n, err := c.Reader.Read(buff)
-	return string(buff[:n]), err
+	return c.ReadContext(context.Background())
+}
+
+// abort tears down the interactive channel after a cancelled operation. The session is
+// marked broken, so that the next command reconnects when reconnecting is enabled.
+func (c *SSHConnModel) abort(reason error) {
+	c.markBroken(reason)
+	if c.session != nil {
+		c.session.Close()
+		c.session = nil
+	}
+	if c.telnet != nil {
+		c.telnet.Close()
+	}
 }
 
-// Write writes a command to the SSH connection.
+// Write writes a command to the SSH connection. Write errors are not reported, use
+// WriteString to see them.
 func (c *SSHConnModel) Write(cmd string) int {
-	code, _ := c.Writer.Write([]byte(cmd))
-	return code
+	n, _ := c.WriteString(cmd)
+	return n
+}
+
+// WriteString writes a command to the connection and reports write errors, such as a
+// closed session.
+func (c *SSHConnModel) WriteString(cmd string) (int, error) {
+	if c.Writer == nil {
+		return 0, errors.New("not connected to device, make sure to call .Connect() first")
+	}
+	n, err := c.Writer.Write([]byte(cmd))
+	if err != nil {
+		return n, fmt.Errorf("failed to write to device: %w", err)
+	}
+	return n, nil
+}
+
+// echoFlag converts an echo mode to its terminal mode value.
+func echoFlag(echo bool) uint32 {
+	if echo {
+		return 1
+	}
+	return 0
 }
 
 // getInteractiveCallBack returns a callback function for SSH keyboard-interactive authentication.
@@ -169,15 +360,6 @@ func getInteractiveCallBack(password string) ssh.KeyboardInteractiveChallenge {
 }
 
 // InitTransport initializes a transport connection based on the protocol.
-func InitTransport(host, username, password, protocol string, port uint8) (*SSHConnModel, error) {
-	switch protocol {
-	case "ssh":
-		conn, err := NewSSHConnModel(host, username, password, port)
-		if err != nil {
-			return nil, err
-		}
-		return conn, nil
-	default:
-		return nil, errors.New("unsupported protocol: " + protocol)
-	}
+func InitTransport(host, username, password, protocol string, port uint16) (*SSHConnModel, error) {
+	return NewConnection(host, WithProtocol(protocol), WithCredentials(username, password), WithPort(port))
 }
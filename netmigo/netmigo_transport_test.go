@@ -0,0 +1,85 @@
+package netmigo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// channelDevice opens an extra shell channel over the client of d.
+func channelDevice(t *testing.T, d *DeviceConnection) *DeviceConnection {
+	t.Helper()
+	c, err := d.Connection.NewChannel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := &DeviceConnection{Connection: c, Return: "\n"}
+	if err := channel.Connection.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return channel
+}
+
+func TestNewChannel(t *testing.T) {
+	server, _ := shellServer(t, "", "")
+	d := shellDevice(t, server)
+	channels := []*DeviceConnection{d, channelDevice(t, d), channelDevice(t, d)}
+
+	// The channels run their commands concurrently over the one client
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*len(channels))
+	for i, channel := range channels {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				cmd := fmt.Sprintf("show interface %d/%d", i, j)
+				out, err := channel.SendCommandPatternContext(context.Background(), cmd, "R1#")
+				if err == nil && !strings.Contains(out, "output of "+cmd) {
+					err = fmt.Errorf("channel %d: output %q of %s", i, out, cmd)
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if logins, _ := server.state(); logins != 1 {
+		t.Errorf("%d logins, want the channels to share one client", logins)
+	}
+
+	// Closing a channel leaves the connection open
+	channels[1].Connection.Disconnect()
+	if !d.Connection.Alive() {
+		t.Fatal("connection closed with its channel")
+	}
+	if _, err := d.SendCommandPatternContext(context.Background(), "show clock", "R1#"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channels[2].SendCommandPatternContext(context.Background(), "show clock", "R1#"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once the connection is closed its channels fail
+	extra, err := d.Connection.NewChannel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Connection.Disconnect()
+	if err := extra.Connect(); err == nil {
+		t.Error("Connect() of a channel of a closed connection succeeded")
+	}
+	if _, err := channels[2].SendCommandPatternContext(context.Background(), "show clock", "R1#"); err == nil {
+		t.Error("command on a channel of a closed connection succeeded")
+	}
+	if _, err := d.Connection.NewChannel(); err == nil {
+		t.Error("NewChannel() of a closed connection succeeded")
+	}
+}
@@ -1,7 +1,11 @@
 package netmigo
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -13,26 +17,74 @@ type SROSDeviceConnection struct {
 	Prompt     string
 }
 
+// srosErrorPattern matches the error lines of the MD-CLI ("MINOR: CLI #2069: ...") and of
+// the classic CLI ("Error: Bad command.").
+var srosErrorPattern = regexp.MustCompile(`(?m)^[ \t]*(MINOR: CLI|Error:)[^\r\n]*`)
+
+// srosCommitFailurePattern matches the messages of a rejected MD-CLI commit, such as
+// "MINOR: MGMT_CORE #2201: ...".
+var srosCommitFailurePattern = regexp.MustCompile(`(?m)^[ \t]*MINOR: MGMT_CORE[^\r\n]*`)
+
+// srosContextLine matches the line the MD-CLI prints above each prompt with the working
+// context, such as "[/]", "[ex:/configure router "Base"]" or, in configuration mode,
+// "(ex)[/configure router "Base"]".
+var srosContextLine = regexp.MustCompile(`^(\((ex|gl|pr|ro)\))?\[[^\n]*\]$`)
+
 func NewSROSDeviceConnection(connection *SSHConnModel, DeviceType string) (*SROSDeviceConnection, error) {
-	return &SROSDeviceConnection{
+	sros := &SROSDeviceConnection{
 		DeviceConnection: DeviceConnection{
-			Connection: connection,
-			Return:     "\n",
+			Connection:   connection,
+			Return:       "\n",
+			errorPattern: srosErrorPattern,
 		},
 		DeviceType: DeviceType,
-	}, nil
+	}
+	sros.prepare = sros.prepareSession
+	return sros, nil
 }
 
 func (sros *SROSDeviceConnection) Connect() error {
-	if err := sros.DeviceConnection.Connect(); err != nil {
+	return sros.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect but aborts when ctx is done.
+func (sros *SROSDeviceConnection) ConnectContext(ctx context.Context) error {
+	if err := sros.DeviceConnection.ConnectContext(ctx); err != nil {
 		return err
 	}
+	return sros.prepareSession(ctx)
+}
+
+// OpenChannel opens an extra shell channel over the same SSH client and prepares it like
+// Connect does, so that commands can run on it concurrently with sros.
+func (sros *SROSDeviceConnection) OpenChannel() (*SROSDeviceConnection, error) {
+	return sros.OpenChannelContext(context.Background())
+}
+
+// OpenChannelContext is like OpenChannel but aborts when ctx is done.
+func (sros *SROSDeviceConnection) OpenChannelContext(ctx context.Context) (*SROSDeviceConnection, error) {
+	channel, err := sros.Connection.NewChannel()
+	if err != nil {
+		return nil, err
+	}
+	device, err := NewSROSDeviceConnection(channel, sros.DeviceType)
+	if err != nil {
+		return nil, err
+	}
+	if err := device.ConnectContext(ctx); err != nil {
+		device.Disconnect()
+		return nil, err
+	}
+	return device, nil
+}
 
+// prepareSession discovers the prompt and disables pagination, after Connect and on every reconnect.
+func (sros *SROSDeviceConnection) prepareSession(ctx context.Context) error {
 	// Define the regex pattern to find the SROS device prompt
 	const promptPattern = "\\*?([ABCD]:\\S*@?\\S+)[#>%]"
 	const expectedPromptSuffix = "#"
 
-	prompt, err := sros.FindDevicePrompt(promptPattern, expectedPromptSuffix)
+	prompt, err := sros.FindDevicePromptContext(ctx, promptPattern, expectedPromptSuffix)
 	if err != nil {
 		return err
 	}
@@ -40,40 +92,99 @@ func (sros *SROSDeviceConnection) Connect() error {
 
 	log.Infof("sros.Prompt is: %s", prompt)
 
-	return sros.sessionPreparation()
+	return sros.sessionPreparation(ctx)
 }
 
 func (sros *SROSDeviceConnection) SendCommand(cmd string) (string, error) {
-	return sros.SendCommandPattern(cmd, sros.Prompt)
+	return sros.SendCommandContext(context.Background(), cmd)
+}
+
+// SendCommandContext is like SendCommand but aborts when ctx is done.
+func (sros *SROSDeviceConnection) SendCommandContext(ctx context.Context, cmd string) (string, error) {
+	return sros.SendCommandPatternContext(ctx, cmd, sros.Prompt)
+}
+
+// SendCommandResult is like SendCommand but also returns the output with the echoed command
+// and the trailing prompt removed.
+func (sros *SROSDeviceConnection) SendCommandResult(cmd string) (*CommandResult, error) {
+	return sros.SendCommandResultContext(context.Background(), cmd)
+}
+
+// SendCommandResultContext is like SendCommandResult but aborts when ctx is done.
+func (sros *SROSDeviceConnection) SendCommandResultContext(ctx context.Context, cmd string) (*CommandResult, error) {
+	raw, err := sros.SendCommandContext(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	output := trimOutput(raw, cmd, sros.Prompt)
+	// The MD-CLI prints the working context on its own line above the prompt
+	lines := strings.Split(output, "\n")
+	if last := len(lines) - 1; last >= 0 && srosContextLine.MatchString(strings.TrimSpace(lines[last])) {
+		output = trimOutput(strings.Join(lines[:last], "\n"), "")
+	}
+	return &CommandResult{Command: cmd, Output: output, Raw: raw}, nil
 }
 
 func (sros *SROSDeviceConnection) SendConfigSet(cmds []string) (string, error) {
-	results, _ := sros.SendCommandPattern("configure exclusive", sros.Prompt)
-	cmds = append(cmds, "commit", "exit")
-	out, err := sros.SendCommandsSetPattern(cmds, sros.Prompt)
-	results += out
-	return results, err
+	return sros.SendConfigSetContext(context.Background(), cmds)
 }
 
-func (sros *SROSDeviceConnection) sessionPreparation() error {
-	if _, err := sros.SendCommand("environment no more"); err != nil {
-		return errors.New("failed to disable pagination: " + err.Error())
+// SendConfigSetContext is like SendConfigSet but aborts when ctx is done. A session lost
+// on the way is not replaced: the error wraps ErrSessionBroken and the caller restarts
+// the whole configuration.
+func (sros *SROSDeviceConnection) SendConfigSetContext(ctx context.Context, cmds []string) (string, error) {
+	if err := sros.ensureConnected(ctx); err != nil {
+		return "", err
+	}
+	ctx = transactionContext(ctx)
+	results, err := sros.SendCommandPatternContext(ctx, "configure exclusive", sros.Prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to enter configuration mode: %w", err)
+	}
+	// Release the exclusive lock and leave configuration mode on any failure
+	abort := []string{"discard", "quit-config"}
+	out, err := sros.SendCommandsSetPatternContext(ctx, cmds, sros.Prompt)
+	if err != nil {
+		abortConfig(ctx, &sros.DeviceConnection, sros.Prompt, abort)
+		return "", err
 	}
-	if _, err := sros.SendCommand("environment more false"); err != nil {
-		return errors.New("failed to disable pagination: " + err.Error())
+	results += out
+	for _, cmd := range []string{"commit", "exit"} {
+		out, err := sros.SendCommandPatternContext(ctx, cmd, sros.Prompt)
+		results += out
+		if err == nil {
+			err = commitFailure(srosCommitFailurePattern, out)
+		}
+		if err != nil {
+			abortConfig(ctx, &sros.DeviceConnection, sros.Prompt, abort)
+			return "", commitError(results, err)
+		}
+	}
+	return results, nil
+}
+
+// sessionPreparation disables pagination. Each CLI flavour rejects the command of the other
+// one: "environment no more" is classic CLI, "environment more false" MD-CLI.
+func (sros *SROSDeviceConnection) sessionPreparation(ctx context.Context) error {
+	for _, command := range []string{"environment no more", "environment more false"} {
+		if _, err := sros.SendCommandContext(ctx, command); err != nil && !errors.Is(err, ErrCommandRejected) {
+			return fmt.Errorf("failed to disable pagination: %w", err)
+		}
 	}
 	return nil
 }
 
 // NewDevice initializes a new SROS device connection
-func InitSROSDevice(Host string, Username string, Password string, Port uint8) (*SROSDeviceConnection, error) {
+// Extra options are applied after the credentials and port, a zero Port selects the default.
+func InitSROSDevice(Host string, Username string, Password string, Port uint16, opts ...Option) (*SROSDeviceConnection, error) {
 
 	// Create connection
-	connection, err := InitTransport(Host, Username, Password, "ssh", Port)
+	opts = append([]Option{WithCredentials(Username, Password), WithPort(Port)}, opts...)
+	connection, err := NewConnection(Host, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create the device
-	return NewSROSDeviceConnection(connection, "nokia_sros")
+	return NewSROSDeviceConnection(connection, DeviceTypeSROS)
 }
@@ -1,8 +1,8 @@
 package netmigo
 
 import (
-	"bufio"
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -18,34 +18,78 @@ type SRLDeviceConnection struct {
 	Prompt     string
 }
 
+// srlErrorPattern matches the error lines SR Linux prints for a rejected command.
+var srlErrorPattern = regexp.MustCompile(`(?m)^[ \t]*Error:[^\r\n]*`)
+
+// srlStatusLine starts the line SR Linux prints above each prompt, such as
+// "--{ running }--[  ]--".
+const srlStatusLine = "--{"
+
 func NewSRLDeviceConnection(connection *SSHConnModel, DeviceType string) (*SRLDeviceConnection, error) {
-	return &SRLDeviceConnection{
+	srl := &SRLDeviceConnection{
 		DeviceConnection: DeviceConnection{
-			Connection: connection,
-			Return:     "\n",
+			Connection:   connection,
+			Return:       "\n",
+			errorPattern: srlErrorPattern,
 		},
 		DeviceType: DeviceType,
-	}, nil
+	}
+	srl.prepare = srl.findPrompt
+	return srl, nil
 }
 
 // NewDevice initializes a new SRL device connection
-func InitSRLDevice(Host string, Username string, Password string, Port uint8) (*SRLDeviceConnection, error) {
+// Extra options are applied after the credentials and port, a zero Port selects the default.
+func InitSRLDevice(Host string, Username string, Password string, Port uint16, opts ...Option) (*SRLDeviceConnection, error) {
 
 	// Create connection
-	connection, err := InitTransport(Host, Username, Password, "ssh", Port)
+	opts = append([]Option{WithCredentials(Username, Password), WithPort(Port)}, opts...)
+	connection, err := NewConnection(Host, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create the device
-	return NewSRLDeviceConnection(connection, "nokia_srl")
+	return NewSRLDeviceConnection(connection, DeviceTypeSRL)
 }
 
 func (srl *SRLDeviceConnection) Connect() error {
-	if err := srl.DeviceConnection.ConnectXterm(); err != nil {
+	return srl.ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect but aborts when ctx is done.
+func (srl *SRLDeviceConnection) ConnectContext(ctx context.Context) error {
+	if err := srl.DeviceConnection.ConnectXtermContext(ctx); err != nil {
 		return err
 	}
+	return srl.findPrompt(ctx)
+}
+
+// OpenChannel opens an extra shell channel over the same SSH client and prepares it like
+// Connect does, so that commands can run on it concurrently with srl.
+func (srl *SRLDeviceConnection) OpenChannel() (*SRLDeviceConnection, error) {
+	return srl.OpenChannelContext(context.Background())
+}
 
+// OpenChannelContext is like OpenChannel but aborts when ctx is done.
+func (srl *SRLDeviceConnection) OpenChannelContext(ctx context.Context) (*SRLDeviceConnection, error) {
+	channel, err := srl.Connection.NewChannel()
+	if err != nil {
+		return nil, err
+	}
+	device, err := NewSRLDeviceConnection(channel, srl.DeviceType)
+	if err != nil {
+		return nil, err
+	}
+	if err := device.ConnectContext(ctx); err != nil {
+		device.Disconnect()
+		return nil, err
+	}
+	return device, nil
+}
+
+// findPrompt discovers the device prompt, after Connect and on every reconnect.
+func (srl *SRLDeviceConnection) findPrompt(ctx context.Context) error {
 	// Define the regex pattern to find the SRL device prompt
 	const promptPattern = "\\*?([ABCD]:\\S*@?\\S+)[#>%]"
 	const expectedPromptSuffix = "#"
@@ -53,7 +97,7 @@ func (srl *SRLDeviceConnection) Connect() error {
 	// const promptPattern = `(?im)^--{(\s\[[\w\s]+\]){0,5}[\+\*\s]{1,}running\s}--\[.+?\]--\s*\n[abcd]:\S+#\s*$`
 	// const expectedPromptSuffix = ``
 
-	prompt, err := srl.FindDevicePrompt(promptPattern, expectedPromptSuffix)
+	prompt, err := srl.FindDevicePromptContext(ctx, promptPattern, expectedPromptSuffix)
 	if err != nil {
 		return err
 	}
@@ -65,68 +109,70 @@ func (srl *SRLDeviceConnection) Connect() error {
 }
 
 func (srl *SRLDeviceConnection) SendCommand(command string, cliPromptMode string, timeout time.Duration) (string, error) {
+	return srl.SendCommandContext(context.Background(), command, cliPromptMode, timeout)
+}
+
+// SendCommandContext is like SendCommand but aborts when ctx is done, returning the partial output in a ContextError.
+func (srl *SRLDeviceConnection) SendCommandContext(ctx context.Context, command string, cliPromptMode string, timeout time.Duration) (string, error) {
+	result, err := srl.SendCommandResultContext(ctx, command, cliPromptMode, timeout)
+	if err != nil {
+		return "", err
+	}
+	return result.Output, nil
+}
+
+// SendCommandResult is like SendCommand but also returns the raw output of the device.
+func (srl *SRLDeviceConnection) SendCommandResult(command string, cliPromptMode string, timeout time.Duration) (*CommandResult, error) {
+	return srl.SendCommandResultContext(context.Background(), command, cliPromptMode, timeout)
+}
+
+// SendCommandResultContext is like SendCommandResult but aborts when ctx is done, returning the partial output in a ContextError.
+// The "exec" cliPromptMode runs the command over an exec channel instead of the shell.
+func (srl *SRLDeviceConnection) SendCommandResultContext(ctx context.Context, command string, cliPromptMode string, timeout time.Duration) (*CommandResult, error) {
+	if cliPromptMode == "exec" {
+		// Run the command in its own exec channel, no prompt matching needed
+		output, err := srl.execOutput(ctx, command)
+		if err != nil {
+			return nil, err
+		}
+		return &CommandResult{Command: command, Output: output, Raw: output}, nil
+	}
+	if err := srl.ensureConnected(ctx); err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = srl.commandTimeout(ctx)
+	}
 
-	var outputBuffer bytes.Buffer
 	var promptMode string
-	var processedOutput string
+	var result *CommandResult
 
 	var err error
 
 	stdin := srl.Connection.Writer
-	stdout := srl.Connection.Reader
-
-	scanner := bufio.NewScanner(stdout)
-	done := make(chan bool)
+	srl.syncOutput(ctx)
 
 	if cliPromptMode == "running" {
 		// promptMode = "-{ [OLD STARTUP] + running }--[  ]--"
 		promptMode = "+ running"
 
-		go func() {
-			defer func() { done <- true }()
-			appearanceCount := 0
-			for scanner.Scan() {
-				line := cleanOutput(scanner.Text())
-				outputBuffer.WriteString(line + "\n")
-				log.Info("Received line:", line)
-
-				// Increment appearance count if the line contains the specific string
-				if strings.Contains(line, promptMode) {
-					appearanceCount++
-					if appearanceCount == 2 {
-						log.Info("Detected second appearance of end marker")
-						done <- true
-						return
-					}
-				}
-
-			}
-			if err := scanner.Err(); err != nil {
-				log.Error("Error reading stdout:", err)
-			}
-		}()
-
 		log.Infof("Sending command: %s", command)
 		_, err := fmt.Fprintf(stdin, "%s\n", command)
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
-		// Wait for the output to be read or timeout
-		select {
-		case <-done:
-			log.Info("Reading completed")
-		case <-time.After(timeout):
-			log.Info("Timeout waiting for reading to complete")
+		// Wait for the end marker, answering pagers, or timeout
+		output, err := srl.expectLines(ctx, timeout, "send command "+command, regexp.QuoteMeta(promptMode), 2)
+		if err != nil {
+			return nil, err
+		}
+		if err := srl.commandError(srl.Prompt, command, output); err != nil {
+			return nil, err
 		}
 
-		output := outputBuffer.String()
-
-		lines := strings.Split(output, "\n")               // Split the output string into lines
-		trimmedLines := lines[2 : len(lines)-2]            // Remove the first and last two lines
-		processedOutput = strings.Join(trimmedLines, "\n") // Join the remaining lines into a single string
-		// fmt.Print(processedOutput)
+		result = &CommandResult{Command: command, Output: trimOutput(output, command, srl.Prompt, srlStatusLine), Raw: output}
 
 		log.Info("Final output: ")
 		log.Debug(output)
@@ -135,81 +181,62 @@ func (srl *SRLDeviceConnection) SendCommand(command string, cliPromptMode string
 		// promptMode = "-{ [OLD STARTUP] + running }--[  ]--"
 		promptMode = "+ candidate"
 
-		go func() {
-			defer func() { done <- true }()
-			appearanceCount := 0
-			for scanner.Scan() {
-				line := cleanOutput(scanner.Text())
-				outputBuffer.WriteString(line + "\n")
-				log.Info("Received line: ", line+"\n")
-
-				// Increment appearance count if the line contains the specific string
-				if strings.Contains(line, promptMode) || strings.Contains(line, "Leaving candidate mode") {
-					appearanceCount++
-					if appearanceCount == 3 {
-						log.Info("Detected second appearance of end marker")
-						done <- true
-						return
-					}
-				}
-
-			}
-			if err = scanner.Err(); err != nil {
-				log.Error("Error reading stdout:", err)
-			}
-		}()
-
 		log.Infof("Sending command: %s", command)
 
 		_, err = fmt.Fprintf(stdin, "%s\n", "enter candidate")
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
 		_, err = fmt.Fprintf(stdin, "%s\n", command)
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
 		_, err = fmt.Fprintf(stdin, "%s\n", "commit now")
 		if err != nil {
-			fmt.Println("Error writing to stdin:", err)
-			return "", err
+			log.Errorf("Error writing to stdin: %v", err)
+			return nil, err
 		}
 
-		// Wait for the output to be read or timeout
-		select {
-		case <-done:
-			log.Info("Reading completed")
-		case <-time.After(timeout):
-			log.Info("Timeout waiting for reading to complete")
+		// Wait for the end marker, answering pagers, or timeout
+		output, err := srl.expectLines(ctx, timeout, "send command "+command, regexp.QuoteMeta(promptMode)+"|Leaving candidate mode", 3)
+		if err != nil {
+			var timeoutErr *TimeoutError
+			if errors.As(err, &timeoutErr) {
+				if err := srl.commandError(srl.Prompt, command, timeoutErr.Output); err != nil {
+					return nil, err
+				}
+				return nil, &CommitError{Output: timeoutErr.Output, Err: err}
+			}
+			return nil, err
+		}
+		if err := srl.commandError(srl.Prompt, command, output); err != nil {
+			return nil, err
+		}
+		if !strings.Contains(output, "Leaving candidate mode") {
+			return nil, &CommitError{Output: output}
 		}
 
-		output := outputBuffer.String()
-
-		lines := strings.Split(output, "\n")               // Split the output string into lines
-		trimmedLines := lines[2 : len(lines)-2]            // Remove the first and last two lines
-		processedOutput = strings.Join(trimmedLines, "\n") // Join the remaining lines into a single string
-		// fmt.Print(processedOutput)
+		result = &CommandResult{Command: command, Output: trimOutput(output, command, srl.Prompt, srlStatusLine, promptMode), Raw: output}
 
 		log.Info("Final output: ")
 		log.Debug(output)
 
 	} else {
-		log.Infof("Unspported cliPromptMode: %s", cliPromptMode)
-		return "", nil
+		log.Errorf("Unsupported cliPromptMode: %s", cliPromptMode)
+		return nil, fmt.Errorf("unsupported cliPromptMode %q", cliPromptMode)
 	}
 
-	return processedOutput, nil
+	return result, nil
 
 }
 
 // func (srl *SRLDeviceConnection) SendCommands(commands []string, prompt string) (string, error) {
 
-// 	var outputBuffer bytes.Buffer
-
+//
 // 	stdin := srl.Connection.Writer
 // 	stdout := srl.Connection.Reader
 
@@ -235,8 +262,7 @@ func (srl *SRLDeviceConnection) SendCommand(command string, cliPromptMode string
 // }
 
 func cleanOutput(output string) string {
-	re := regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]|\x1b\[\?2004[lh]|\x1b\[\?25[hl]|\x1b\[\?12[hl]|\x1b\[\?7[hl]`)
-	output = re.ReplaceAllString(output, "")
+	output = ansiEscapeRegexp.ReplaceAllString(output, "")
 
 	// reRunning := regexp.MustCompile(`--\{ \[OLD STARTUP\] \+ running \}--\[  \]--`)
 	// output = reRunning.ReplaceAllString(output, "")